@@ -0,0 +1,73 @@
+package app
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/hdac-io/simulator/block"
+)
+
+// Application represents state machine executing transactions of finalized
+// blocks, blocks are executed in order of height
+type Application interface {
+	// BeginBlock starts executing block of the header
+	BeginBlock(header block.BlockHeader)
+	// DeliverTx executes transaction, failed transaction does not change the state
+	DeliverTx(tx block.Transaction) error
+	// EndBlock ends executing block of the height
+	EndBlock(height int)
+	// Commit finishes the block and returns app hash of the state
+	Commit() [32]byte
+}
+
+// Applications
+const (
+	// Noop ignores transactions
+	Noop = "noop"
+	// KVStore stores key-values and transfers balances of accounts
+	KVStore = "kvstore"
+)
+
+// applications contains constructors of applications by name
+var applications = map[string]func() Application{
+	Noop:    newNoop,
+	KVStore: NewKVStore,
+}
+
+// IsApplication reports whether name is a known application
+func IsApplication(name string) bool {
+	_, exist := applications[name]
+	return exist
+}
+
+// New constructs application of the name
+func New(name string) (Application, error) {
+	newApplication, exist := applications[name]
+	if !exist {
+		return nil, errors.New("Unknown application: " + name)
+	}
+	return newApplication(), nil
+}
+
+// Names returns names of applications
+func Names() []string {
+	names := make([]string, 0, len(applications))
+	for name := range applications {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// noop accepts every transaction and keeps no state
+type noop struct{}
+
+func newNoop() Application {
+	return noop{}
+}
+
+func (noop) BeginBlock(header block.BlockHeader)  {}
+func (noop) DeliverTx(tx block.Transaction) error { return nil }
+func (noop) EndBlock(height int)                  {}
+func (noop) Commit() [32]byte                     { return [32]byte{} }
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/types"
+)
+
+// Operations of kvstore transaction, the first byte of the payload
+const (
+	// OpSet is 1 | key length (1) | key | value
+	OpSet = 1
+	// OpTransfer is 2 | receiver (8) | amount (8)
+	OpTransfer = 2
+)
+
+// InitialBalance is balance of an account before any transfer
+const InitialBalance = 1000000
+
+// kvstore keeps key-values and balances of accounts identified by validator ID
+type kvstore struct {
+	store    map[string][]byte
+	balances map[types.ID]uint64
+}
+
+// NewKVStore constructs empty kvstore application
+func NewKVStore() Application {
+	return &kvstore{
+		store:    make(map[string][]byte),
+		balances: make(map[types.ID]uint64),
+	}
+}
+
+// EncodeSet returns payload setting value of the key
+func EncodeSet(key []byte, value []byte) []byte {
+	if len(key) > 255 {
+		panic("Too long key !")
+	}
+	payload := append([]byte{OpSet, byte(len(key))}, key...)
+	return append(payload, value...)
+}
+
+// EncodeTransfer returns payload transferring amount to the receiver
+func EncodeTransfer(to types.ID, amount uint64) []byte {
+	payload := make([]byte, 17)
+	payload[0] = OpTransfer
+	binary.BigEndian.PutUint64(payload[1:], uint64(to))
+	binary.BigEndian.PutUint64(payload[9:], amount)
+	return payload
+}
+
+func (s *kvstore) BeginBlock(header block.BlockHeader) {}
+
+func (s *kvstore) DeliverTx(tx block.Transaction) error {
+	if len(tx.Payload) == 0 {
+		return errors.New("Empty transaction")
+	}
+	switch tx.Payload[0] {
+	case OpSet:
+		if len(tx.Payload) < 2 || len(tx.Payload) < 2+int(tx.Payload[1]) {
+			return errors.New("Invalid set transaction")
+		}
+		key := string(tx.Payload[2 : 2+tx.Payload[1]])
+		s.store[key] = append([]byte(nil), tx.Payload[2+tx.Payload[1]:]...)
+	case OpTransfer:
+		if len(tx.Payload) != 17 {
+			return errors.New("Invalid transfer transaction")
+		}
+		to := types.ID(binary.BigEndian.Uint64(tx.Payload[1:]))
+		amount := binary.BigEndian.Uint64(tx.Payload[9:])
+		if s.balance(tx.From) < amount {
+			return errors.New("Insufficient balance")
+		}
+		s.balances[tx.From] = s.balance(tx.From) - amount
+		s.balances[to] = s.balance(to) + amount
+	default:
+		return errors.New("Unknown operation")
+	}
+	return nil
+}
+
+func (s *kvstore) balance(id types.ID) uint64 {
+	if balance, exist := s.balances[id]; exist {
+		return balance
+	}
+	return InitialBalance
+}
+
+func (s *kvstore) EndBlock(height int) {}
+
+// Commit returns hash of key-values and balances in sorted order, empty state has zero hash
+func (s *kvstore) Commit() [32]byte {
+	if len(s.store) == 0 && len(s.balances) == 0 {
+		return [32]byte{}
+	}
+
+	keys := make([]string, 0, len(s.store))
+	for key := range s.store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ids := make([]types.ID, 0, len(s.balances))
+	for id := range s.balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	h := sha256.New()
+	buf := make([]byte, 8)
+	write := func(v uint64) {
+		binary.BigEndian.PutUint64(buf, v)
+		h.Write(buf)
+	}
+	write(uint64(len(keys)))
+	for _, key := range keys {
+		write(uint64(len(key)))
+		h.Write([]byte(key))
+		write(uint64(len(s.store[key])))
+		h.Write(s.store[key])
+	}
+	for _, id := range ids {
+		write(uint64(id))
+		write(s.balances[id])
+	}
+
+	var hash [32]byte
+	copy(hash[:], h.Sum(nil))
+	return hash
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStore(t *testing.T) {
+	a := NewKVStore()
+	s := a.(*kvstore)
+	require.Equal(t, [32]byte{}, a.Commit())
+
+	a.BeginBlock(block.BlockHeader{Height: 1})
+	require.NoError(t, a.DeliverTx(block.Transaction{From: 1, Payload: EncodeSet([]byte("key"), []byte("value"))}))
+	require.NoError(t, a.DeliverTx(block.Transaction{From: 1, Payload: EncodeTransfer(2, 300)}))
+	require.Error(t, a.DeliverTx(block.Transaction{From: 3, Payload: EncodeTransfer(2, InitialBalance+1)}))
+	require.Error(t, a.DeliverTx(block.Transaction{From: 1, Payload: []byte{OpSet, 5, 'k'}}))
+	require.Error(t, a.DeliverTx(block.Transaction{From: 1, Payload: []byte{9}}))
+	a.EndBlock(1)
+	hash := a.Commit()
+
+	require.Equal(t, []byte("value"), s.store["key"])
+	require.Equal(t, uint64(InitialBalance-300), s.balance(1))
+	require.Equal(t, uint64(InitialBalance+300), s.balance(2))
+	require.Equal(t, uint64(InitialBalance), s.balance(3))
+
+	// Same transactions make same hash
+	b := NewKVStore()
+	require.NoError(t, b.DeliverTx(block.Transaction{From: 1, Payload: EncodeSet([]byte("key"), []byte("value"))}))
+	require.NoError(t, b.DeliverTx(block.Transaction{From: 1, Payload: EncodeTransfer(2, 300)}))
+	require.Equal(t, hash, b.Commit())
+	require.NoError(t, b.DeliverTx(block.Transaction{From: 2, Payload: EncodeTransfer(1, 1)}))
+	require.NotEqual(t, hash, b.Commit())
+}
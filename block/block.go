@@ -1,10 +1,10 @@
 package block
 
 import (
-	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
+	"errors"
 
+	"github.com/hdac-io/simulator/codec"
 	"github.com/hdac-io/simulator/types"
 	"github.com/hdac-io/simulator/vrfmessage"
 )
@@ -12,8 +12,20 @@ import (
 // BlockHeader represents block header
 type BlockHeader struct {
 	Height    int
+	Round     int
 	Timestamp int64
 	Producer  types.ID
+	Parent    [32]byte // Hash of the previous block, zero for the first block
+	BodyHash  [32]byte // Digest of VRF message and transactions
+	TxRoot    [32]byte // Merkle root of the transactions
+	App       AppState // State of the producer when proposing the block
+}
+
+// AppState represents app hash after executing blocks up to the height,
+// zero height is the empty state before any block
+type AppState struct {
+	Height int
+	Hash   [32]byte
 }
 
 // Block represents simple block structure
@@ -21,29 +33,119 @@ type Block struct {
 	Header BlockHeader
 	Hash   [32]byte
 	VRF    vrfmessage.VRFMessage
+
+	Transactions []Transaction
 }
 
-// New constructs block
-func New(height int, timestamp int64, producer types.ID, vrf vrfmessage.VRFMessage) Block {
+// New constructs block of the header with the body, digests of the body are
+// filled in the header
+func New(header BlockHeader, vrf vrfmessage.VRFMessage, txs []Transaction) Block {
 	b := Block{
-		Header: BlockHeader{
-			Height:    height,
-			Timestamp: timestamp,
-			Producer:  producer,
-		},
-		VRF: vrf,
+		Header:       header,
+		VRF:          vrf,
+		Transactions: txs,
 	}
+	b.Header.TxRoot = MerkleRoot(txs)
+	b.Header.BodyHash = CalculateBodyHash(b)
 	b.Hash = CalculateHashFromBlock(b)
 
 	return b
 }
 
-// CalculateHashFromBlock returns calculated hash using block contents
+// Size returns total size of the transactions in bytes
+func (b Block) Size() int {
+	size := 0
+	for _, tx := range b.Transactions {
+		size += tx.Size()
+	}
+	return size
+}
+
+// CalculateBodyHash returns digest of VRF message and transactions
+func CalculateBodyHash(b Block) [32]byte {
+	txRoot := MerkleRoot(b.Transactions)
+	return sha256.Sum256(codec.EncodeList(b.VRF.Encode(), codec.EncodeBytes(txRoot[:])))
+}
+
+// Validate checks digests of the body and hash of the block
+func Validate(b Block) error {
+	if b.Header.TxRoot != MerkleRoot(b.Transactions) {
+		return errors.New("Invalid transaction root")
+	}
+	if b.Header.BodyHash != CalculateBodyHash(b) {
+		return errors.New("Invalid body hash")
+	}
+	if b.Hash != CalculateHashFromBlock(b) {
+		return errors.New("Invalid block hash")
+	}
+	return nil
+}
+
+// CalculateHashFromBlock returns calculated hash using block contents, the
+// body is covered by its digests in the header
 func CalculateHashFromBlock(b Block) [32]byte {
-	//TODO:: decide encode function(ex: ethereum-rlp, ...)
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	encoder.Encode(b.Header)
+	return sha256.Sum256(b.Header.Encode())
+}
+
+// Encode returns canonical encoding of the header
+func (h BlockHeader) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeInt(int64(h.Height)),
+		codec.EncodeInt(int64(h.Round)),
+		codec.EncodeInt(h.Timestamp),
+		codec.EncodeInt(int64(h.Producer)),
+		codec.EncodeBytes(h.Parent[:]),
+		codec.EncodeBytes(h.BodyHash[:]),
+		codec.EncodeBytes(h.TxRoot[:]),
+		codec.EncodeList(codec.EncodeInt(int64(h.App.Height)), codec.EncodeBytes(h.App.Hash[:])),
+	)
+}
+
+// DecodeHeader decodes header from canonical encoding
+func DecodeHeader(item codec.Item) (BlockHeader, error) {
+	d := codec.NewDecoder(item, 8)
+	h := BlockHeader{
+		Height:    int(d.Int()),
+		Round:     int(d.Int()),
+		Timestamp: d.Int(),
+		Producer:  types.ID(d.Int()),
+		Parent:    d.Hash(),
+		BodyHash:  d.Hash(),
+		TxRoot:    d.Hash(),
+	}
+	app := d.Struct(2)
+	h.App = AppState{Height: int(app.Int()), Hash: app.Hash()}
+	return h, d.Err()
+}
 
-	return sha256.Sum256(buf.Bytes())
+// Encode returns canonical encoding of the block
+func (b Block) Encode() []byte {
+	txs := make([][]byte, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		txs[i] = tx.Encode()
+	}
+	return codec.EncodeList(b.Header.Encode(), codec.EncodeBytes(b.Hash[:]), b.VRF.Encode(), codec.EncodeList(txs...))
+}
+
+// Decode decodes block from canonical encoding
+func Decode(item codec.Item) (Block, error) {
+	d := codec.NewDecoder(item, 4)
+	var b Block
+	var err error
+	if b.Header, err = DecodeHeader(d.Item()); err != nil {
+		d.Fail(err)
+	}
+	b.Hash = d.Hash()
+	if b.VRF, err = vrfmessage.Decode(d.Item()); err != nil {
+		d.Fail(err)
+	}
+	for _, item := range d.List() {
+		tx, err := DecodeTransaction(item)
+		if err != nil {
+			d.Fail(err)
+			break
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+	return b, d.Err()
 }
@@ -0,0 +1,47 @@
+package block
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/vrfmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	header := BlockHeader{Height: 2, Round: 1, Timestamp: 1500000000, Producer: 3, Parent: [32]byte{1}, App: AppState{Height: 1, Hash: [32]byte{2}}}
+	vrf := vrfmessage.VRFMessage{Rand: [32]byte{3}, Proof: []byte{4, 5}, PreviousProposerID: 1, PreviousProposerPubkey: []byte{6}, PreviousBlockHeight: 1}
+	b := New(header, vrf, []Transaction{{From: 1, Nonce: 1, Timestamp: 1000, Payload: []byte("tx")}})
+
+	// Golden vectors, changing them breaks stored chains and other implementations
+	require.Equal(t, "c801018203e8827478", hex.EncodeToString(b.Transactions[0].Encode()))
+	require.Equal(t, "f88e02018459682f0003"+
+		"a00100000000000000000000000000000000000000000000000000000000000000"+
+		"a08f1405d4722d7bea4106fed45eae67bf2f97024b9de6ac5b74b3c8c81c833e51"+
+		"a0fb5907adae025c319f537f12cbc51894837dbd6b99d72b92a05566747a28ab39"+
+		"e201a00200000000000000000000000000000000000000000000000000000000000000",
+		hex.EncodeToString(b.Header.Encode()))
+	require.Equal(t, "5cb1b0113f5c55e47caf8c8161bbb0b559c6be46594c38cb874e6918f35b0ec6", hex.EncodeToString(b.Hash[:]))
+
+	item, err := codec.Decode(b.Encode())
+	require.NoError(t, err)
+	decoded, err := Decode(item)
+	require.NoError(t, err)
+	require.Equal(t, b, decoded)
+	require.NoError(t, Validate(decoded))
+
+	// Block without transactions
+	b = New(BlockHeader{Height: 1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+	item, err = codec.Decode(b.Encode())
+	require.NoError(t, err)
+	decoded, err = Decode(item)
+	require.NoError(t, err)
+	require.Equal(t, b, decoded)
+
+	// Header with missing field is rejected
+	item, err = codec.Decode(codec.EncodeList(codec.EncodeInt(1)))
+	require.NoError(t, err)
+	_, err = DecodeHeader(item)
+	require.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package block
+
+import (
+	"crypto/sha256"
+
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/types"
+)
+
+// Transaction represents transaction submitted by a validator
+type Transaction struct {
+	From      types.ID
+	Nonce     uint64
+	Timestamp int64
+	Payload   []byte
+}
+
+// Hash returns hash of the transaction
+func (tx Transaction) Hash() [32]byte {
+	return sha256.Sum256(tx.Encode())
+}
+
+// Size returns size of the encoded transaction in bytes
+func (tx Transaction) Size() int {
+	return len(tx.Encode())
+}
+
+// Encode returns canonical encoding of the transaction
+func (tx Transaction) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeInt(int64(tx.From)),
+		codec.EncodeUint(tx.Nonce),
+		codec.EncodeInt(tx.Timestamp),
+		codec.EncodeBytes(tx.Payload),
+	)
+}
+
+// DecodeTransaction decodes transaction from canonical encoding
+func DecodeTransaction(item codec.Item) (Transaction, error) {
+	d := codec.NewDecoder(item, 4)
+	tx := Transaction{
+		From:      types.ID(d.Int()),
+		Nonce:     d.Uint(),
+		Timestamp: d.Int(),
+		Payload:   d.Bytes(),
+	}
+	return tx, d.Err()
+}
+
+// Prefixes separating leaf and inner nodes of merkle tree
+const (
+	merkleLeaf  = 0
+	merkleInner = 1
+)
+
+// MerkleRoot returns root of binary merkle tree of transaction hashes, the
+// last node of a level having odd nodes is promoted to the next level. Root
+// of no transactions is zero.
+func MerkleRoot(txs []Transaction) [32]byte {
+	if len(txs) == 0 {
+		return [32]byte{}
+	}
+	level := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		hash := tx.Hash()
+		level[i] = sha256.Sum256(append([]byte{merkleLeaf}, hash[:]...))
+	}
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i+1 < len(level); i += 2 {
+			node := append([]byte{merkleInner}, level[i][:]...)
+			next = append(next, sha256.Sum256(append(node, level[i+1][:]...)))
+		}
+		if len(level)%2 == 1 {
+			next = append(next, level[len(level)-1])
+		}
+		level = next
+	}
+	return level[0]
+}
@@ -0,0 +1,37 @@
+package block
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	txs := []Transaction{
+		{From: 1, Nonce: 1, Payload: []byte{1}},
+		{From: 1, Nonce: 2, Payload: []byte{2}},
+		{From: 2, Nonce: 1, Payload: []byte{3}},
+	}
+	leaf := func(tx Transaction) [32]byte {
+		hash := tx.Hash()
+		return sha256.Sum256(append([]byte{0}, hash[:]...))
+	}
+	pair := func(a [32]byte, b [32]byte) [32]byte {
+		return sha256.Sum256(append(append([]byte{1}, a[:]...), b[:]...))
+	}
+
+	require.Equal(t, [32]byte{}, MerkleRoot(nil))
+	require.Equal(t, leaf(txs[0]), MerkleRoot(txs[:1]))
+	require.Equal(t, pair(leaf(txs[0]), leaf(txs[1])), MerkleRoot(txs[:2]))
+
+	// The last transaction is promoted
+	expected := pair(pair(leaf(txs[0]), leaf(txs[1])), leaf(txs[2]))
+	require.Equal(t, expected, MerkleRoot(txs))
+
+	// Duplicated last transaction changes the root
+	require.NotEqual(t, MerkleRoot(txs), MerkleRoot(append(txs, txs[2])))
+
+	// Order matters
+	require.NotEqual(t, MerkleRoot(txs), MerkleRoot([]Transaction{txs[1], txs[0], txs[2]}))
+}
@@ -0,0 +1,105 @@
+package blocksync
+
+import (
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/signature"
+)
+
+// MaxBlocks is the largest number of blocks in a response
+const MaxBlocks = 64
+
+// Request asks blocks of heights from From to To
+type Request struct {
+	From int
+	To   int
+}
+
+// Response contains blocks of the requested heights, at most MaxBlocks
+type Response struct {
+	// Finalized blocks and their finalization signatures
+	Blocks     []block.Block
+	Signatures [][]signature.Signature
+
+	// Blocks appended but not finalized yet, following the finalized blocks
+	Pending []block.Block
+
+	// Height of the responder
+	Height int
+}
+
+// Encode returns canonical encoding of the request
+func (r Request) Encode() []byte {
+	return codec.EncodeList(codec.EncodeInt(int64(r.From)), codec.EncodeInt(int64(r.To)))
+}
+
+// DecodeRequest decodes request from canonical encoding
+func DecodeRequest(item codec.Item) (Request, error) {
+	d := codec.NewDecoder(item, 2)
+	r := Request{
+		From: int(d.Int()),
+		To:   int(d.Int()),
+	}
+	return r, d.Err()
+}
+
+// Encode returns canonical encoding of the response
+func (r Response) Encode() []byte {
+	signatures := make([][]byte, len(r.Signatures))
+	for i, signs := range r.Signatures {
+		signatures[i] = signature.EncodeList(signs)
+	}
+	return codec.EncodeList(
+		encodeBlocks(r.Blocks),
+		codec.EncodeList(signatures...),
+		encodeBlocks(r.Pending),
+		codec.EncodeInt(int64(r.Height)),
+	)
+}
+
+// DecodeResponse decodes response from canonical encoding
+func DecodeResponse(item codec.Item) (Response, error) {
+	d := codec.NewDecoder(item, 4)
+	var r Response
+	var err error
+	if r.Blocks, err = decodeBlocks(d.Item()); err != nil {
+		d.Fail(err)
+	}
+	for _, item := range d.List() {
+		signs, err := signature.DecodeList(item)
+		if err != nil {
+			d.Fail(err)
+			break
+		}
+		r.Signatures = append(r.Signatures, signs)
+	}
+	if r.Pending, err = decodeBlocks(d.Item()); err != nil {
+		d.Fail(err)
+	}
+	r.Height = int(d.Int())
+	return r, d.Err()
+}
+
+func encodeBlocks(blocks []block.Block) []byte {
+	items := make([][]byte, len(blocks))
+	for i, b := range blocks {
+		items[i] = b.Encode()
+	}
+	return codec.EncodeList(items...)
+}
+
+func decodeBlocks(item codec.Item) ([]block.Block, error) {
+	items, err := item.List()
+	if err != nil {
+		return nil, err
+	}
+	var blocks []block.Block
+	for _, item := range items {
+		b, err := block.Decode(item)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
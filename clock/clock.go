@@ -0,0 +1,99 @@
+package clock
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// Clock represents source of time and scheduler of goroutines.
+// Every goroutine, sleep and blocking channel operation of a simulation should
+// go through the clock so that virtual clock can tell when it may advance time.
+type Clock interface {
+	// Now returns current time
+	Now() time.Time
+	// Sleep pauses current goroutine for duration d
+	Sleep(d time.Duration)
+	// Go starts f in new goroutine
+	Go(f func())
+	// Send sends v to channel ch, blocks while the channel is full
+	Send(ch interface{}, v interface{})
+	// Receive receives value from channel ch, blocks while the channel is empty
+	Receive(ch interface{}) interface{}
+	// ReceiveTimeout receives value from channel ch, it returns false if
+	// nothing is received within duration d
+	ReceiveTimeout(ch interface{}, d time.Duration) (interface{}, bool)
+	// NewCond constructs condition variable associated with l
+	NewCond(l sync.Locker) Cond
+}
+
+// Cond represents condition variable, see sync.Cond
+type Cond interface {
+	Wait()
+	Signal()
+	Broadcast()
+}
+
+// LogHandler stamps log records with the time of clock
+func LogHandler(c Clock, h log.Handler) log.Handler {
+	return log.FuncHandler(func(r *log.Record) error {
+		r.Time = c.Now()
+		return h.Log(r)
+	})
+}
+
+// valueOf returns reflected v, nil is converted to zero value of element type of ch
+func valueOf(ch reflect.Value, v interface{}) reflect.Value {
+	if v == nil {
+		return reflect.Zero(ch.Type().Elem())
+	}
+	return reflect.ValueOf(v)
+}
+
+type realClock struct{}
+
+// NewReal returns wall clock
+func NewReal() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (realClock) Go(f func()) {
+	go f()
+}
+
+func (realClock) Send(ch interface{}, v interface{}) {
+	c := reflect.ValueOf(ch)
+	c.Send(valueOf(c, v))
+}
+
+func (realClock) Receive(ch interface{}) interface{} {
+	v, _ := reflect.ValueOf(ch).Recv()
+	return v.Interface()
+}
+
+func (realClock) ReceiveTimeout(ch interface{}, d time.Duration) (interface{}, bool) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	chosen, v, _ := reflect.Select([]reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)},
+	})
+	if chosen != 0 {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+func (realClock) NewCond(l sync.Locker) Cond {
+	return sync.NewCond(l)
+}
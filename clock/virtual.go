@@ -0,0 +1,260 @@
+package clock
+
+import (
+	"container/heap"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// task is a goroutine managed by virtual clock
+type task struct {
+	wake chan struct{}
+}
+
+// waiter represents single blocking of a task, it is woken at most once
+type waiter struct {
+	task  *task
+	woken bool
+}
+
+type event struct {
+	at     time.Time
+	seq    uint64
+	waiter *waiter
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Virtual is discrete-event clock. Goroutines started by Go run one at a time
+// in deterministic order, and time jumps to the next event only when every
+// goroutine is blocked on the clock. Goroutines must not block on anything
+// but the clock, e.g. holding a mutex while sleeping deadlocks the simulation,
+// and channels must be buffered since Send never meets a waiting receiver.
+type Virtual struct {
+	now      time.Time
+	seq      uint64
+	events   eventQueue
+	runnable []*task
+	current  *task
+	yield    chan struct{}
+
+	// Goroutines blocked on channels
+	receivers map[interface{}][]*waiter
+	senders   map[interface{}][]*waiter
+}
+
+// NewVirtual constructs virtual clock starting at start
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{
+		now:       start,
+		events:    make(eventQueue, 0),
+		runnable:  make([]*task, 0),
+		yield:     make(chan struct{}),
+		receivers: make(map[interface{}][]*waiter),
+		senders:   make(map[interface{}][]*waiter),
+	}
+}
+
+// Run executes goroutines until every goroutine is blocked forever or
+// virtual time passes duration d. Run can be called again to continue.
+func (v *Virtual) Run(d time.Duration) {
+	end := v.now.Add(d)
+	for {
+		if len(v.runnable) > 0 {
+			t := v.runnable[0]
+			v.runnable = v.runnable[1:]
+
+			// Hand over execution until the task is blocked or finished
+			v.current = t
+			t.wake <- struct{}{}
+			<-v.yield
+			v.current = nil
+			continue
+		}
+
+		if len(v.events) == 0 {
+			// Nothing will happen anymore
+			return
+		}
+		e := heap.Pop(&v.events).(*event)
+		if e.at.After(end) {
+			heap.Push(&v.events, e)
+			v.now = end
+			return
+		}
+		if e.at.After(v.now) {
+			v.now = e.at
+		}
+		v.ready(e.waiter)
+	}
+}
+
+// Now returns virtual time
+func (v *Virtual) Now() time.Time {
+	return v.now
+}
+
+// Sleep pauses current goroutine for virtual duration d
+func (v *Virtual) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	w := v.newWaiter()
+	v.schedule(v.now.Add(d), w)
+	v.block(w)
+}
+
+// Go starts f in new goroutine managed by the clock
+func (v *Virtual) Go(f func()) {
+	t := &task{wake: make(chan struct{})}
+	v.runnable = append(v.runnable, t)
+	go func() {
+		<-t.wake
+		f()
+		v.yield <- struct{}{}
+	}()
+}
+
+// Send sends v to channel ch
+func (v *Virtual) Send(ch interface{}, value interface{}) {
+	c := reflect.ValueOf(ch)
+	x := valueOf(c, value)
+	for !c.TrySend(x) {
+		w := v.newWaiter()
+		v.senders[ch] = append(v.senders[ch], w)
+		v.block(w)
+	}
+	v.notify(v.receivers, ch)
+}
+
+// Receive receives value from channel ch
+func (v *Virtual) Receive(ch interface{}) interface{} {
+	c := reflect.ValueOf(ch)
+	for {
+		if x, _ := c.TryRecv(); x.IsValid() {
+			v.notify(v.senders, ch)
+			return x.Interface()
+		}
+		w := v.newWaiter()
+		v.receivers[ch] = append(v.receivers[ch], w)
+		v.block(w)
+	}
+}
+
+// ReceiveTimeout receives value from channel ch waiting at most virtual duration d
+func (v *Virtual) ReceiveTimeout(ch interface{}, d time.Duration) (interface{}, bool) {
+	c := reflect.ValueOf(ch)
+	deadline := v.now.Add(d)
+	for {
+		if x, _ := c.TryRecv(); x.IsValid() {
+			v.notify(v.senders, ch)
+			return x.Interface(), true
+		}
+		if !v.now.Before(deadline) {
+			return nil, false
+		}
+		// Woken by either a sender or the deadline
+		w := v.newWaiter()
+		v.receivers[ch] = append(v.receivers[ch], w)
+		v.schedule(deadline, w)
+		v.block(w)
+	}
+}
+
+// NewCond constructs condition variable scheduled by virtual clock
+func (v *Virtual) NewCond(l sync.Locker) Cond {
+	return &virtualCond{clock: v, l: l}
+}
+
+func (v *Virtual) newWaiter() *waiter {
+	if v.current == nil {
+		panic("Blocking outside of virtual clock goroutine !")
+	}
+	return &waiter{task: v.current}
+}
+
+func (v *Virtual) schedule(at time.Time, w *waiter) {
+	v.seq++
+	heap.Push(&v.events, &event{at: at, seq: v.seq, waiter: w})
+}
+
+// block yields execution until w is woken
+func (v *Virtual) block(w *waiter) {
+	v.yield <- struct{}{}
+	<-w.task.wake
+}
+
+func (v *Virtual) ready(w *waiter) {
+	if w.woken {
+		return
+	}
+	w.woken = true
+	v.runnable = append(v.runnable, w.task)
+}
+
+// notify wakes the first waiter of ch
+func (v *Virtual) notify(waiters map[interface{}][]*waiter, ch interface{}) {
+	list := waiters[ch]
+	for len(list) > 0 {
+		w := list[0]
+		list = list[1:]
+		if !w.woken {
+			v.ready(w)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(waiters, ch)
+	} else {
+		waiters[ch] = list
+	}
+}
+
+type virtualCond struct {
+	clock   *Virtual
+	l       sync.Locker
+	waiters []*waiter
+}
+
+func (c *virtualCond) Wait() {
+	w := c.clock.newWaiter()
+	c.waiters = append(c.waiters, w)
+	c.l.Unlock()
+	c.clock.block(w)
+	c.l.Lock()
+}
+
+func (c *virtualCond) Signal() {
+	for len(c.waiters) > 0 {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		if !w.woken {
+			c.clock.ready(w)
+			return
+		}
+	}
+}
+
+func (c *virtualCond) Broadcast() {
+	for _, w := range c.waiters {
+		c.clock.ready(w)
+	}
+	c.waiters = nil
+}
@@ -0,0 +1,149 @@
+package clock
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var genesis = time.Unix(0, 0)
+
+func TestVirtualSleep(t *testing.T) {
+	c := NewVirtual(genesis)
+	woken := make([]time.Duration, 0)
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		d := d
+		c.Go(func() {
+			c.Sleep(d)
+			woken = append(woken, c.Now().Sub(genesis))
+		})
+	}
+
+	startTime := time.Now()
+	c.Run(time.Hour)
+	require.True(t, time.Since(startTime) < time.Second)
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, woken)
+	// Every goroutine finished, so the clock stops at the last event
+	require.Equal(t, 3*time.Second, c.Now().Sub(genesis))
+}
+
+func TestVirtualRunStopsAtDuration(t *testing.T) {
+	c := NewVirtual(genesis)
+	ticks := 0
+	c.Go(func() {
+		for {
+			c.Sleep(time.Second)
+			ticks++
+		}
+	})
+
+	c.Run(10 * time.Second)
+	require.Equal(t, 10, ticks)
+	c.Run(5 * time.Second)
+	require.Equal(t, 15, ticks)
+	require.Equal(t, 15*time.Second, c.Now().Sub(genesis))
+}
+
+func TestVirtualChannel(t *testing.T) {
+	c := NewVirtual(genesis)
+	ch := make(chan int, 1)
+	received := make([]int, 0)
+	c.Go(func() {
+		for {
+			received = append(received, c.Receive(ch).(int))
+		}
+	})
+	c.Go(func() {
+		for i := 0; i < 5; i++ {
+			c.Sleep(time.Second)
+			// Second send blocks until the receiver runs
+			c.Send(ch, i)
+			c.Send(ch, i*10)
+		}
+	})
+
+	c.Run(time.Minute)
+	require.Equal(t, []int{0, 0, 1, 10, 2, 20, 3, 30, 4, 40}, received)
+}
+
+func TestVirtualReceiveTimeout(t *testing.T) {
+	c := NewVirtual(genesis)
+	ch := make(chan int, 1)
+	results := make([]bool, 0)
+	times := make([]time.Duration, 0)
+	c.Go(func() {
+		for i := 0; i < 2; i++ {
+			_, ok := c.ReceiveTimeout(ch, 2*time.Second)
+			results = append(results, ok)
+			times = append(times, c.Now().Sub(genesis))
+		}
+	})
+	c.Go(func() {
+		c.Sleep(time.Second)
+		c.Send(ch, 1)
+	})
+
+	c.Run(time.Minute)
+	require.Equal(t, []bool{true, false}, results)
+	require.Equal(t, []time.Duration{time.Second, 3 * time.Second}, times)
+}
+
+func TestVirtualCond(t *testing.T) {
+	c := NewVirtual(genesis)
+	var mutex sync.Mutex
+	cond := c.NewCond(&mutex)
+	ready := 0
+	finished := make([]time.Duration, 0)
+	for i := 0; i < 3; i++ {
+		c.Go(func() {
+			mutex.Lock()
+			for ready < 3 {
+				cond.Wait()
+			}
+			mutex.Unlock()
+			finished = append(finished, c.Now().Sub(genesis))
+		})
+	}
+	c.Go(func() {
+		for i := 0; i < 3; i++ {
+			c.Sleep(time.Second)
+			mutex.Lock()
+			ready++
+			cond.Broadcast()
+			mutex.Unlock()
+		}
+	})
+
+	c.Run(time.Minute)
+	require.Equal(t, []time.Duration{3 * time.Second, 3 * time.Second, 3 * time.Second}, finished)
+}
+
+func TestVirtualDeterministic(t *testing.T) {
+	run := func() []int {
+		c := NewVirtual(genesis)
+		ch := make(chan int, 1)
+		order := make([]int, 0)
+		for i := 0; i < 10; i++ {
+			i := i
+			c.Go(func() {
+				c.Sleep(time.Duration(i%3) * time.Millisecond)
+				c.Send(ch, i)
+			})
+		}
+		c.Go(func() {
+			for {
+				order = append(order, c.Receive(ch).(int))
+			}
+		})
+		c.Run(time.Second)
+		return order
+	}
+
+	first := run()
+	require.Equal(t, 10, len(first))
+	for i := 0; i < 10; i++ {
+		require.Equal(t, first, run())
+	}
+}
@@ -0,0 +1,397 @@
+// Package codec implements canonical binary encoding following RLP. An item
+// is a byte string or a list of items:
+//   - single byte less than 0x80 is the byte itself
+//   - string up to 55 bytes is 0x80+length followed by the string
+//   - longer string is 0xb7+length of length, big endian length and the string
+//   - list whose encoded items take up to 55 bytes is 0xc0+length followed by the items
+//   - longer list is 0xf7+length of length, big endian length and the items
+//
+// Unsigned integers are big endian strings without leading zeros, so zero is
+// the empty string. Signed integers are encoded as their two's complement
+// unsigned value. Decoding accepts only the canonical encoding so that every
+// value has exactly one encoding.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxItemSize limits size of decoded item from a stream
+const maxItemSize = 64 << 20
+
+// Errors of decoding
+var (
+	ErrUnexpectedEnd = errors.New("Unexpected end of input")
+	ErrNonCanonical  = errors.New("Non-canonical encoding")
+	ErrTrailingBytes = errors.New("Trailing bytes after item")
+	ErrExpectedList  = errors.New("Expected list")
+	ErrExpectedBytes = errors.New("Expected bytes")
+	ErrTooLarge      = errors.New("Too large item")
+)
+
+// EncodeBytes encodes byte string
+func EncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return []byte{b[0]}
+	}
+	return append(header(0x80, len(b)), b...)
+}
+
+// EncodeString encodes string as byte string
+func EncodeString(s string) []byte {
+	return EncodeBytes([]byte(s))
+}
+
+// EncodeUint encodes unsigned integer
+func EncodeUint(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	i := 0
+	for i < len(buf) && buf[i] == 0 {
+		i++
+	}
+	return EncodeBytes(buf[i:])
+}
+
+// EncodeInt encodes signed integer
+func EncodeInt(v int64) []byte {
+	return EncodeUint(uint64(v))
+}
+
+// EncodeBool encodes boolean as integer 0 or 1
+func EncodeBool(v bool) []byte {
+	if v {
+		return EncodeUint(1)
+	}
+	return EncodeUint(0)
+}
+
+// EncodeList encodes list of encoded items
+func EncodeList(items ...[]byte) []byte {
+	size := 0
+	for _, item := range items {
+		size += len(item)
+	}
+	buf := header(0xc0, size)
+	for _, item := range items {
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+func header(offset byte, size int) []byte {
+	if size <= 55 {
+		return []byte{offset + byte(size)}
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	i := 0
+	for buf[i] == 0 {
+		i++
+	}
+	return append([]byte{offset + 55 + byte(8-i)}, buf[i:]...)
+}
+
+// Item represents decoded item, either byte string or list
+type Item struct {
+	list  bool
+	bytes []byte
+	items []Item
+}
+
+// Decode decodes data holding exactly one item
+func Decode(data []byte) (Item, error) {
+	item, rest, err := decode(data)
+	if err != nil {
+		return Item{}, err
+	}
+	if len(rest) != 0 {
+		return Item{}, ErrTrailingBytes
+	}
+	return item, nil
+}
+
+// decode decodes the first item of data and returns the rest
+func decode(data []byte) (Item, []byte, error) {
+	list, offset, size, err := readHeader(data)
+	if err != nil {
+		return Item{}, nil, err
+	}
+	if len(data) < offset+size {
+		return Item{}, nil, ErrUnexpectedEnd
+	}
+	content, rest := data[offset:offset+size], data[offset+size:]
+	if !list {
+		if offset == 1 && size == 1 && content[0] < 0x80 {
+			// Single byte should be encoded as itself
+			return Item{}, nil, ErrNonCanonical
+		}
+		if size == 0 {
+			// Empty string is decoded as nil like the zero value
+			content = nil
+		}
+		return Item{bytes: content}, rest, nil
+	}
+
+	items := make([]Item, 0)
+	for len(content) > 0 {
+		var item Item
+		if item, content, err = decode(content); err != nil {
+			return Item{}, nil, err
+		}
+		items = append(items, item)
+	}
+	return Item{list: true, items: items}, rest, nil
+}
+
+// readHeader returns kind, header size and content size of the item starting data
+func readHeader(data []byte) (bool, int, int, error) {
+	if len(data) == 0 {
+		return false, 0, 0, ErrUnexpectedEnd
+	}
+	prefix := data[0]
+	switch {
+	case prefix < 0x80:
+		return false, 0, 1, nil
+	case prefix <= 0xb7:
+		return false, 1, int(prefix - 0x80), nil
+	case prefix < 0xc0:
+		size, err := readSize(data[1:], int(prefix-0xb7))
+		return false, 1 + int(prefix-0xb7), size, err
+	case prefix <= 0xf7:
+		return true, 1, int(prefix - 0xc0), nil
+	default:
+		size, err := readSize(data[1:], int(prefix-0xf7))
+		return true, 1 + int(prefix-0xf7), size, err
+	}
+}
+
+// readSize reads big endian size of long item, size should not fit in short form
+func readSize(data []byte, length int) (int, error) {
+	if len(data) < length {
+		return 0, ErrUnexpectedEnd
+	}
+	if data[0] == 0 {
+		return 0, ErrNonCanonical
+	}
+	var size uint64
+	for _, b := range data[:length] {
+		size = size<<8 | uint64(b)
+	}
+	if size <= 55 {
+		return 0, ErrNonCanonical
+	}
+	if size > maxItemSize {
+		return 0, ErrTooLarge
+	}
+	return int(size), nil
+}
+
+// ReadItem reads encoding of the next item from r
+func ReadItem(r io.Reader) ([]byte, error) {
+	buf := make([]byte, 1, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[0] < 0x80 {
+		return buf, nil
+	}
+	if (buf[0] > 0xb7 && buf[0] < 0xc0) || buf[0] > 0xf7 {
+		// Long form, read length of the size
+		length := int(buf[0] - 0xb7)
+		if buf[0] > 0xf7 {
+			length = int(buf[0] - 0xf7)
+		}
+		buf = buf[:1+length]
+		if _, err := io.ReadFull(r, buf[1:]); err != nil {
+			return nil, err
+		}
+	}
+	_, offset, size, err := readHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	item := make([]byte, offset+size)
+	copy(item, buf)
+	if _, err := io.ReadFull(r, item[offset:]); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// IsList reports whether the item is a list
+func (item Item) IsList() bool {
+	return item.list
+}
+
+// Bytes returns byte string of the item
+func (item Item) Bytes() ([]byte, error) {
+	if item.list {
+		return nil, ErrExpectedBytes
+	}
+	return item.bytes, nil
+}
+
+// Uint returns unsigned integer of the item
+func (item Item) Uint() (uint64, error) {
+	b, err := item.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > 8 {
+		return 0, errors.New("Too large integer")
+	}
+	if len(b) > 0 && b[0] == 0 {
+		return 0, ErrNonCanonical
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// Int returns signed integer of the item
+func (item Item) Int() (int64, error) {
+	v, err := item.Uint()
+	return int64(v), err
+}
+
+// Bool returns boolean of the item
+func (item Item) Bool() (bool, error) {
+	v, err := item.Uint()
+	if err == nil && v > 1 {
+		err = ErrNonCanonical
+	}
+	return v == 1, err
+}
+
+// Hash returns 32 bytes hash of the item
+func (item Item) Hash() ([32]byte, error) {
+	var hash [32]byte
+	b, err := item.Bytes()
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != len(hash) {
+		return hash, errors.New("Invalid hash length")
+	}
+	copy(hash[:], b)
+	return hash, nil
+}
+
+// List returns items of the list
+func (item Item) List() ([]Item, error) {
+	if !item.list {
+		return nil, ErrExpectedList
+	}
+	return item.items, nil
+}
+
+// Fields returns items of the list having exactly n items, used for structs
+func (item Item) Fields(n int) ([]Item, error) {
+	items, err := item.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(items) != n {
+		return nil, errors.New("Invalid number of fields")
+	}
+	return items, nil
+}
+
+// Decoder reads fields of a list in order, the first error is kept and
+// following reads return zero values
+type Decoder struct {
+	items []Item
+	next  int
+	err   *error
+}
+
+// NewDecoder returns decoder of the item which should be a list of n fields
+func NewDecoder(item Item, n int) *Decoder {
+	var err error
+	d := &Decoder{err: &err}
+	d.items, err = item.Fields(n)
+	return d
+}
+
+func (d *Decoder) item() Item {
+	if *d.err != nil || d.next >= len(d.items) {
+		return Item{}
+	}
+	d.next++
+	return d.items[d.next-1]
+}
+
+func (d *Decoder) check(err error) {
+	if *d.err == nil && err != nil {
+		*d.err = err
+	}
+}
+
+// Item returns the next field as item
+func (d *Decoder) Item() Item {
+	return d.item()
+}
+
+// Uint reads unsigned integer
+func (d *Decoder) Uint() uint64 {
+	v, err := d.item().Uint()
+	d.check(err)
+	return v
+}
+
+// Int reads signed integer
+func (d *Decoder) Int() int64 {
+	v, err := d.item().Int()
+	d.check(err)
+	return v
+}
+
+// Bool reads boolean
+func (d *Decoder) Bool() bool {
+	v, err := d.item().Bool()
+	d.check(err)
+	return v
+}
+
+// Bytes reads byte string
+func (d *Decoder) Bytes() []byte {
+	v, err := d.item().Bytes()
+	d.check(err)
+	return v
+}
+
+// Hash reads 32 bytes hash
+func (d *Decoder) Hash() [32]byte {
+	v, err := d.item().Hash()
+	d.check(err)
+	return v
+}
+
+// List reads items of a list
+func (d *Decoder) List() []Item {
+	v, err := d.item().List()
+	d.check(err)
+	return v
+}
+
+// Struct reads list of n fields, errors of the returned decoder are kept by d
+func (d *Decoder) Struct(n int) *Decoder {
+	fields, err := d.item().Fields(n)
+	d.check(err)
+	return &Decoder{items: fields, err: d.err}
+}
+
+// Fail records error found by the caller
+func (d *Decoder) Fail(err error) {
+	d.check(err)
+}
+
+// Err returns the first error
+func (d *Decoder) Err() error {
+	return *d.err
+}
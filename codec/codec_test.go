@@ -0,0 +1,121 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors of RLP specification
+var golden = []struct {
+	encoded string
+	value   []byte
+}{
+	{"80", nil},
+	{"00", []byte{0x00}},
+	{"7f", []byte{0x7f}},
+	{"8180", []byte{0x80}},
+	{"83646f67", []byte("dog")},
+	{"b8384c6f72656d20697073756d20646f6c6f722073697420616d65742c20636f6e7365637465747572206164697069736963696e6720656c6974",
+		[]byte("Lorem ipsum dolor sit amet, consectetur adipisicing elit")},
+	{"820400", EncodeUint(1024)[1:]},
+}
+
+func TestGolden(t *testing.T) {
+	for _, g := range golden {
+		require.Equal(t, g.encoded, hex.EncodeToString(EncodeBytes(g.value)))
+		item, err := Decode(EncodeBytes(g.value))
+		require.NoError(t, err)
+		b, err := item.Bytes()
+		require.NoError(t, err)
+		require.Equal(t, g.value, b)
+	}
+
+	require.Equal(t, "80", hex.EncodeToString(EncodeUint(0)))
+	require.Equal(t, "0f", hex.EncodeToString(EncodeUint(15)))
+	require.Equal(t, "820400", hex.EncodeToString(EncodeUint(1024)))
+	require.Equal(t, "c0", hex.EncodeToString(EncodeList()))
+	require.Equal(t, "c88363617483646f67", hex.EncodeToString(EncodeList(EncodeString("cat"), EncodeString("dog"))))
+
+	// Set theoretical representation of three
+	empty := EncodeList()
+	one := EncodeList(empty)
+	three := EncodeList(empty, one, EncodeList(empty, one))
+	require.Equal(t, "c7c0c1c0c3c0c1c0", hex.EncodeToString(three))
+
+	long := EncodeList(EncodeString(strings.Repeat("a", 60)))
+	require.Equal(t, "f83eb83c", hex.EncodeToString(long[:4]))
+}
+
+func TestDecode(t *testing.T) {
+	data := EncodeList(EncodeUint(7), EncodeInt(-1), EncodeList(EncodeBool(true)), EncodeBytes(make([]byte, 32)))
+	item, err := Decode(data)
+	require.NoError(t, err)
+	fields, err := item.Fields(4)
+	require.NoError(t, err)
+
+	v, err := fields[0].Uint()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), v)
+	i, err := fields[1].Int()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1), i)
+	inner, err := fields[2].Fields(1)
+	require.NoError(t, err)
+	b, err := inner[0].Bool()
+	require.NoError(t, err)
+	require.True(t, b)
+	_, err = fields[3].Hash()
+	require.NoError(t, err)
+
+	_, err = item.Fields(3)
+	require.Error(t, err)
+	_, err = fields[0].List()
+	require.Equal(t, ErrExpectedList, err)
+	_, err = fields[2].Bytes()
+	require.Equal(t, ErrExpectedBytes, err)
+}
+
+func TestDecodeNonCanonical(t *testing.T) {
+	for _, encoded := range []string{
+		"8100",      // single byte in string
+		"b80161",    // long form of short string
+		"b9003861",  // leading zero of size
+		"c3",        // truncated list
+		"8361",      // truncated string
+		"80" + "00", // trailing bytes
+		"c28100",    // invalid item in list
+	} {
+		data, _ := hex.DecodeString(encoded)
+		_, err := Decode(data)
+		require.Error(t, err, encoded)
+	}
+
+	// Integer with leading zero
+	item, err := Decode([]byte{0x82, 0x00, 0x01})
+	require.NoError(t, err)
+	_, err = item.Uint()
+	require.Equal(t, ErrNonCanonical, err)
+}
+
+func TestReadItem(t *testing.T) {
+	items := [][]byte{
+		EncodeUint(5),
+		EncodeString("dog"),
+		EncodeList(EncodeString(strings.Repeat("a", 100)), EncodeUint(1)),
+	}
+	stream := bytes.NewBuffer(nil)
+	for _, item := range items {
+		stream.Write(item)
+	}
+	for _, item := range items {
+		read, err := ReadItem(stream)
+		require.NoError(t, err)
+		require.Equal(t, item, read)
+	}
+	_, err := ReadItem(stream)
+	require.Error(t, err)
+}
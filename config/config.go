@@ -2,26 +2,150 @@ package config
 
 import (
 	"time"
+
+	"github.com/hdac-io/simulator/load"
+	"github.com/hdac-io/simulator/types"
 )
 
 // Config contains various configuration
 type Config struct {
-	Consensus *consensusConfig
+	Consensus  *consensusConfig
+	Simulation *simulationConfig
+	Byzantine  *byzantineConfig
+	Storage    *storageConfig
+	Block      *blockConfig
+	Mempool    *mempoolConfig
+	App        *appConfig
+	Network    *networkConfig
+	Gossip     *gossipConfig
+	Keystore   *keystoreConfig
 }
 
 type consensusConfig struct {
+	Algorithm string        // Name of consensus engine, friday-vrf or friday-fbft
 	BlockTime time.Duration // Block time
 	LenULB    int           // Length of unconfirmed leading blocks
+
+	RoundTimeout time.Duration // Timeout of the first round before voting to skip the proposer, it grows with the round
+}
+
+type simulationConfig struct {
+	Virtual bool  // Run on virtual clock
+	Seed    int64 // Random seed of keys and network for deterministic run
+}
+
+type storageConfig struct {
+	Path string // Directory of block stores of validators, blocks are kept in memory if empty
+}
+
+type blockConfig struct {
+	MaxTransactions int // Maximum number of transactions in a block
+	MaxBytes        int // Maximum total size of transactions in a block
+}
+
+type mempoolConfig struct {
+	Size int       // Maximum number of pending transactions
+	Load load.Spec // Synthetic load submitted by each validator
+}
+
+type appConfig struct {
+	Name string // Name of application executing finalized blocks
+}
+
+type networkConfig struct {
+	TLS    bool // Encrypt TCP connections between validators with TLS
+	Degree int  // Number of peers of each validator on average, every pair is connected if zero
+}
+
+type gossipConfig struct {
+	Fanout    int // Number of peers a message is relayed to, messages are sent to every peer if zero
+	TTL       int // Maximum hops of a message
+	CacheSize int // Number of recent messages remembered to drop duplicates
+}
+
+type keystoreConfig struct {
+	Dir        string // Directory of keystore files, keys of addressbook are used if empty
+	Passphrase string // Passphrase of encrypted keystore files
+}
+
+type byzantineConfig struct {
+	Faults map[types.ID][]Fault // Byzantine behaviors of validators
+}
+
+// Fault represents byzantine behavior active from Start height until Stop height,
+// zero Stop means the behavior never stops
+type Fault struct {
+	Kind  string
+	Start int
+	Stop  int
+}
+
+// Active reports whether the fault is active at the height
+func (f Fault) Active(height int) bool {
+	return height >= f.Start && (f.Stop == 0 || height <= f.Stop)
 }
 
 // GetDefault retrieves default configuration
 func GetDefault() *Config {
 	c := consensusConfig{
+		Algorithm: "friday-vrf",
 		BlockTime: 1 * time.Second,
 		LenULB:    2,
+
+		RoundTimeout: 3 * time.Second,
+	}
+	s := simulationConfig{
+		Virtual: false,
+		Seed:    0,
+	}
+
+	b := byzantineConfig{
+		Faults: make(map[types.ID][]Fault),
+	}
+
+	st := storageConfig{
+		Path: "",
+	}
+
+	bl := blockConfig{
+		MaxTransactions: 1000,
+		MaxBytes:        1 << 20,
+	}
+
+	m := mempoolConfig{
+		Size: 10000,
+	}
+
+	a := appConfig{
+		Name: "kvstore",
+	}
+
+	n := networkConfig{
+		TLS:    false,
+		Degree: 0,
+	}
+
+	g := gossipConfig{
+		Fanout:    0,
+		TTL:       8,
+		CacheSize: 8192,
+	}
+
+	k := keystoreConfig{
+		Dir:        "",
+		Passphrase: "",
 	}
 
 	return &Config{
-		Consensus: &c,
+		Consensus:  &c,
+		Simulation: &s,
+		Byzantine:  &b,
+		Storage:    &st,
+		Block:      &bl,
+		Mempool:    &m,
+		App:        &a,
+		Network:    &n,
+		Gossip:     &g,
+		Keystore:   &k,
 	}
 }
@@ -1,25 +1,91 @@
 package main
 
 import (
+	"crypto/tls"
+	"flag"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/hdac-io/simulator/app"
 	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
 	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/load"
+	"github.com/hdac-io/simulator/metrics"
+	mynet "github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/net/tcp"
 	"github.com/hdac-io/simulator/node"
-	"github.com/hdac-io/simulator/node/status"
+	"github.com/hdac-io/simulator/scenario"
+	"github.com/hdac-io/simulator/types"
 	log "github.com/inconshreveable/log15"
 )
 
 const defaultIP = "127.0.0.1"
 
+// virtualEpoch is starting time of virtual clock, so that every virtual run has same timestamps
+var virtualEpoch = time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		if err := keygen(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	simulate := flag.Bool("simulate", false, "run all validators in this process over simulated network")
+	delay := flag.String("delay", "constant:0s", "delay of simulated links (constant:<delay>, uniform:<min>:<max>, normal:<mean>:<stddev>, pareto:<scale>:<shape>)")
+	uplink := flag.String("uplink", "", "uplink bandwidth of each simulated validator, e.g. 100Mbps, unlimited if empty")
+	downlink := flag.String("downlink", "", "downlink bandwidth of each simulated validator, e.g. 100Mbps, unlimited if empty")
+	rttMatrix := flag.String("rtt-matrix", "", "CSV file of round-trip times between regions, simulated validators are placed in the regions in turn")
+	seed := flag.Int64("seed", 0, "random seed of simulated network and keys")
+	virtual := flag.Bool("virtual", false, "run simulated network on virtual clock, implies -simulate")
+	duration := flag.Duration("duration", 1000*time.Second, "virtual duration of the run")
+	byzantine := flag.String("byzantine", "", "byzantine validators, e.g. 3:equivocation,5:withhold-vote+garbage-payload")
+	roundTimeout := flag.Duration("round-timeout", 3*time.Second, "timeout of a round before voting to skip the silent proposer")
+	consensus := flag.String("consensus", node.FridayVRF, "consensus engine ("+strings.Join(node.Consensuses(), ", ")+")")
+	dataDir := flag.String("data-dir", "", "directory storing blocks of validators, blocks are kept in memory if empty")
+	loadSpec := flag.String("load", "", "synthetic transactions submitted by each validator (constant:<tps>:<size>[:<payload>], poisson:<tps>:<size>[:<payload>], payload is random or kvstore)")
+	appName := flag.String("app", app.KVStore, "application executing finalized blocks ("+strings.Join(app.Names(), ", ")+")")
+	maxBlockTxs := flag.Int("max-block-txs", 1000, "maximum number of transactions in a block")
+	maxBlockBytes := flag.Int("max-block-bytes", 1<<20, "maximum total size of transactions in a block")
+	metricsAddress := flag.String("metrics-addr", "", "address serving /metrics in Prometheus text format, e.g. :9100")
+	metricsFile := flag.String("metrics", "", "file to write metrics of validators at the end of the run")
+	degree := flag.Int("degree", 0, "number of peers of each validator on average, every pair of validators is connected if zero, requires -gossip-fanout")
+	gossipFanout := flag.Int("gossip-fanout", 0, "number of peers a block, vote or transaction is relayed to, messages are sent to every peer if zero")
+	gossipTTL := flag.Int("gossip-ttl", 8, "maximum hops of a gossiped message")
+	encrypt := flag.Bool("tls", false, "encrypt TCP connections between validators with TLS, certificates are signed by BLS keys of validators")
+	validateChain := flag.Bool("validate-chain", false, "validate chains stored in -data-dir by every validator and exit")
+	keystoreDir := flag.String("keystore", "", "directory of keystore files and addressbook generated by keygen command, validators load their keys from it")
+	passphraseFile := flag.String("passphrase-file", "", "file of passphrase decrypting keystore files")
+	scenarioFile := flag.String("scenario", "", "scenario file describing the whole simulation run, overrides other flags")
+	flag.Parse()
+
+	// Load scenario
+	var run *scenario.Scenario
+	if *scenarioFile != "" {
+		var err error
+		run, err = scenario.Load(*scenarioFile)
+		if err != nil {
+			panic(err)
+		}
+		*simulate = true
+		*virtual = run.Virtual
+		*seed = run.Seed
+		*duration = run.Duration.Duration
+	}
+
 	// Set my TCP address
 	address := defaultIP
-	if len(os.Args) > 1 {
-		address = os.Args[1]
+	if flag.NArg() > 0 {
+		address = flag.Arg(0)
 	}
 	nodeAddress, err := net.ResolveTCPAddr("tcp", address+":0")
 	if err != nil {
@@ -28,14 +94,27 @@ func main() {
 
 	// Set default genesis time for local test
 	genesisTime := time.Now().Add(5 * time.Second).Round(1 * time.Second)
-	if len(os.Args) > 2 {
-		time, err := time.Parse(time.RFC3339, os.Args[2])
+	if *virtual {
+		genesisTime = virtualEpoch.Add(5 * time.Second)
+	}
+	if flag.NArg() > 1 {
+		time, err := time.Parse(time.RFC3339, flag.Arg(1))
 		if err != nil {
 			panic(err)
 		}
 		genesisTime = time
 	}
 
+	// Initialize clock
+	var clk clock.Clock = clock.NewReal()
+	var virtualClock *clock.Virtual
+	if *virtual {
+		*simulate = true
+		virtualClock = clock.NewVirtual(genesisTime.Add(-5 * time.Second))
+		clk = virtualClock
+		log.Root().SetHandler(clock.LogHandler(clk, log.Root().GetHandler()))
+	}
+
 	// Initialize external BLS package
 	bls.Init(bls.CurveFp254BNb)
 
@@ -47,46 +126,510 @@ func main() {
 	addressbook := node.PrepareAddressbook()
 
 	config := config.GetDefault()
-	nodes := make([]*node.Node, 0)
-	for _, address := range addressbook {
+	config.Simulation.Virtual = *virtual
+	config.Simulation.Seed = *seed
+	config.Consensus.RoundTimeout = *roundTimeout
+	config.Consensus.Algorithm = *consensus
+	config.Storage.Path = *dataDir
+	config.App.Name = *appName
+	if !app.IsApplication(*appName) {
+		panic("Unknown application: " + *appName)
+	}
+	config.Network.TLS = *encrypt
+	config.Network.Degree = *degree
+	config.Gossip.Fanout = *gossipFanout
+	config.Gossip.TTL = *gossipTTL
+	if *degree > 0 && *gossipFanout == 0 {
+		panic("Partial connectivity requires gossip !")
+	}
+	config.Block.MaxTransactions = *maxBlockTxs
+	config.Block.MaxBytes = *maxBlockBytes
+	if *loadSpec != "" {
+		if config.Mempool.Load, err = load.ParseSpec(*loadSpec); err != nil {
+			panic(err)
+		}
+	}
+	if !node.IsConsensus(*consensus) {
+		panic("Unknown consensus: " + *consensus)
+	}
+	config.Byzantine.Faults, err = node.ParseFaults(*byzantine)
+	if err != nil {
+		panic(err)
+	}
+	if *keystoreDir != "" {
+		if run != nil {
+			panic("Scenario keys are given by the scenario !")
+		}
+		logger.Info("Load addressbook", "Keystore", *keystoreDir)
+		if addressbook, err = node.LoadAddressbook(*keystoreDir); err != nil {
+			panic(err)
+		}
+		config.Keystore.Dir = *keystoreDir
+		if config.Keystore.Passphrase, err = readPassphrase(*passphraseFile); err != nil {
+			panic(err)
+		}
+	}
+	var simulation *mynet.Simulation
+	if run != nil {
+		logger.Info("Run scenario", "File", *scenarioFile, "Name", run.Name, "Validators", run.Validators, "Seed", run.Seed)
+		addressbook = run.Addressbook()
+		config = run.Config()
+		config.Storage.Path = *dataDir
+		simulation = run.NewSimulation(clk, addressbook)
+	} else if *simulate {
+		delaySpec, err := mynet.ParseDelaySpec(*delay)
+		if err != nil {
+			panic(err)
+		}
+		var up, down mynet.Bandwidth
+		if *uplink != "" {
+			if up, err = mynet.ParseBandwidth(*uplink); err != nil {
+				panic(err)
+			}
+		}
+		if *downlink != "" {
+			if down, err = mynet.ParseBandwidth(*downlink); err != nil {
+				panic(err)
+			}
+		}
+		logger.Info("Simulate network", "Delay", *delay, "Uplink", up, "Downlink", down, "Seed", *seed, "Virtual clock", *virtual)
+		simulation = mynet.NewSimulation(clk, *seed, delaySpec)
+		simulation.SetSizer(node.LoadSize)
+		simulation.SetBandwidth(up, down)
+		if *rttMatrix != "" {
+			matrix, err := mynet.LoadRTTMatrix(*rttMatrix)
+			if err != nil {
+				panic(err)
+			}
+			simulation.SetRTTMatrix(matrix, 0)
+			for i, id := range addressbook.IDs() {
+				region := matrix.Regions[i%len(matrix.Regions)]
+				logger.Debug("Place validator", "ID", id, "Region", region)
+				simulation.SetRegion(addressbook[id].Address, region)
+			}
+		}
+	}
+
+	if *validateChain {
+		if !validateChains(logger, addressbook, config) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	nodes := &validators{}
+	listen := func(id types.ID) mynet.Transport {
+		return simulation.Listen(addressbook[id].Address)
+	}
+	newValidator := func(id types.ID) *node.Node {
+		return node.NewValidator(id, addressbook, config, listen(id), clk)
+	}
+	for _, id := range addressbook.IDs() {
+		address := addressbook[id]
 		ip, err := net.ResolveTCPAddr("tcp", address.Address.(string))
 		if err != nil {
 			panic(err)
 		}
-		if ip.IP.Equal(nodeAddress.IP) {
+		if simulation != nil {
+			if run != nil {
+				if _, late := run.JoinTime(id); late {
+					// Started by schedule
+					continue
+				}
+			}
+			// All validators share simulated network
+			nodes.add(newValidator(id))
+		} else if ip.IP.Equal(nodeAddress.IP) {
 			// FIXME: we should copy addressbook for runtime modification by nodes
-			nodes = append(nodes, node.NewValidator(address.ID, addressbook, config.Consensus.LenULB, config.Consensus.BlockTime))
+			var tlsConfig *tls.Config
+			if config.Network.TLS {
+				if tlsConfig, err = node.TLSConfig(address.ID, addressbook, config); err != nil {
+					panic(err)
+				}
+			}
+			transport, err := tcp.New(address.Address, tlsConfig)
+			if err != nil {
+				panic(err)
+			}
+			nodes.add(node.NewValidator(address.ID, addressbook, config, transport, clk))
 		}
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(len(nodes))
-	for _, node := range nodes {
+	for _, node := range nodes.list() {
+		node := node
+		wg.Add(1)
 		// Genesis time for testing
-		go node.Start(genesisTime, &wg)
+		clk.Go(func() { node.Start(genesisTime, &wg) })
 	}
 
 	// For analysis, do not wait this goroutine
-	startAnalyze(logger, genesisTime)
+	startAnalyze(logger, genesisTime, clk, nodes)
+	monitor := startMonitor(logger, genesisTime, clk, nodes, 2*config.Consensus.RoundTimeout)
+
+	if run != nil {
+		startSchedule(logger, run, clk, genesisTime, nodes, newValidator, listen)
+		startPartitions(logger, run, clk, genesisTime, simulation, addressbook, monitor)
+	}
+	if *metricsAddress != "" {
+		serveMetrics(logger, *metricsAddress, nodes)
+	}
+
+	// Export metrics at the end of the run
+	finish := func() {
+		if *metricsFile == "" {
+			return
+		}
+		if err := writeMetrics(*metricsFile, nodes.list()); err != nil {
+			logger.Error("Cannot write metrics", "File", *metricsFile, "Error", err)
+			return
+		}
+		logger.Info("Metrics written", "File", *metricsFile)
+	}
 
+	if virtualClock != nil {
+		virtualClock.Run(genesisTime.Add(*duration).Sub(virtualClock.Now()))
+		finish()
+		return
+	}
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		<-interrupt
+		finish()
+		os.Exit(1)
+	}()
+	if run != nil {
+		clk.Sleep(genesisTime.Add(*duration).Sub(clk.Now()))
+		finish()
+		return
+	}
 	wg.Wait()
 }
 
-func startAnalyze(logger log.Logger, genesisTime time.Time) {
-	status.Analysis.Enabled = true
-	status.Analysis.FastestFinalizedTime = time.Duration(10) * time.Second
+// validateChains validates chain stored by every validator, it returns false if any chain is invalid
+func validateChains(logger log.Logger, addressbook node.Addressbook, config *config.Config) bool {
+	if config.Storage.Path == "" {
+		panic("Chain validation needs data directory !")
+	}
+	valid := true
+	for _, id := range addressbook.IDs() {
+		height, err := node.ValidateStore(id, addressbook, config)
+		if err != nil {
+			logger.Error("Invalid chain", "Validator", id, "Valid height", height, "Error", err)
+			valid = false
+			continue
+		}
+		logger.Info("Valid chain", "Validator", id, "Height", height)
+	}
+	return valid
+}
 
-	go func() {
+// validators are nodes run by this process, late joining validators are added while running
+type validators struct {
+	sync.Mutex
+	nodes []*node.Node
+}
+
+func (v *validators) add(n *node.Node) {
+	v.Lock()
+	v.nodes = append(v.nodes, n)
+	v.Unlock()
+}
+
+// replace puts restarted validator into the slot of its stopped instance
+func (v *validators) replace(n *node.Node) {
+	v.Lock()
+	defer v.Unlock()
+	for i, old := range v.nodes {
+		if old.ID() == n.ID() {
+			v.nodes[i] = n
+			return
+		}
+	}
+	v.nodes = append(v.nodes, n)
+}
+
+func (v *validators) list() []*node.Node {
+	v.Lock()
+	defer v.Unlock()
+	return append([]*node.Node{}, v.nodes...)
+}
+
+func (v *validators) get(id types.ID) *node.Node {
+	for _, n := range v.list() {
+		if n.ID() == id {
+			return n
+		}
+	}
+	return nil
+}
+
+func startAnalyze(logger log.Logger, genesisTime time.Time, clk clock.Clock, nodes *validators) {
+	clk.Go(func() {
 		// Wait for genesis time
-		time.Sleep(genesisTime.Sub(time.Now()))
+		clk.Sleep(genesisTime.Sub(clk.Now()))
 
 		for {
-			time.Sleep(5 * time.Second)
-			status.Analysis.Lock()
-			logger.Crit("Fastest finalized time", "time", status.Analysis.FastestFinalizedTime)
-			logger.Crit("Laziest finalized time", "time", status.Analysis.LaziestFinalizedTime)
-			logger.Crit("Average finalized time", "time", status.Analysis.AverageFinalizedTime)
-			status.Analysis.Unlock()
+			clk.Sleep(5 * time.Second)
+			snapshots := make([]metrics.Snapshot, 0)
+			for _, n := range nodes.list() {
+				snapshots = append(snapshots, n.Metrics().Snapshot())
+			}
+			latency, exist := metrics.Merge(snapshots...).Histograms[metrics.FinalizationLatency]
+			if !exist {
+				continue
+			}
+			logger.Crit("Fastest finalized time", "time", latency.Min())
+			logger.Crit("Laziest finalized time", "time", latency.Max())
+			logger.Crit("Average finalized time", "time", latency.Mean())
+
+			// Every validator finalizes the same transactions
+			var finalized int64
+			for _, s := range snapshots {
+				if s.Counters[metrics.TransactionsFinalized] > finalized {
+					finalized = s.Counters[metrics.TransactionsFinalized]
+				}
+			}
+			if finalized > 0 {
+				logger.Crit("Finalized transactions", "count", finalized,
+					"tps", float64(finalized)/clk.Now().Sub(genesisTime).Seconds())
+			}
+		}
+	})
+}
+
+// monitorInterval is interval of checking finalized blocks of validators
+const monitorInterval = 100 * time.Millisecond
+
+// monitor reports stalls of finalization, conflicting finalized blocks and
+// recovery after partition heals
+type monitor struct {
+	sync.Mutex
+	logger       log.Logger
+	stallTimeout time.Duration
+
+	// Hash of the first finalized block seen at each height
+	finalized map[int][32]byte
+	// Height checked for each validator
+	checked map[*node.Node]int
+
+	// Highest finalized height and when it is reached
+	highest    int
+	progressed time.Time
+	stalled    bool
+
+	// Highest finalized height when partition healed, until every running
+	// validator finalizes above it
+	healing      bool
+	healedAt     time.Time
+	healedHeight int
+}
+
+// startMonitor checks finalized blocks of validators from genesis, finalization
+// is stalled if no block is finalized for stallTimeout
+func startMonitor(logger log.Logger, genesisTime time.Time, clk clock.Clock, nodes *validators, stallTimeout time.Duration) *monitor {
+	m := &monitor{
+		logger:       logger,
+		stallTimeout: stallTimeout,
+		finalized:    make(map[int][32]byte),
+		checked:      make(map[*node.Node]int),
+	}
+	clk.Go(func() {
+		clk.Sleep(genesisTime.Sub(clk.Now()))
+		m.progressed = clk.Now()
+		for {
+			clk.Sleep(monitorInterval)
+			m.check(clk.Now(), nodes.list())
+		}
+	})
+	return m
+}
+
+// check compares blocks finalized by running validators since the last check
+func (m *monitor) check(now time.Time, nodes []*node.Node) {
+	m.Lock()
+	defer m.Unlock()
+	highest := m.highest
+	lowest := -1
+	for _, n := range nodes {
+		if n.Stopped() {
+			continue
+		}
+		height := n.FinalizedHeight()
+		for h := m.checked[n] + 1; h <= height; h++ {
+			b, err := n.FinalizedBlock(h)
+			if err != nil {
+				break
+			}
+			if hash, exist := m.finalized[h]; !exist {
+				m.finalized[h] = b.Hash
+			} else if hash != b.Hash {
+				m.logger.Crit("Safety violation", "Validator", n.ID(), "Height", h,
+					"Hash", fmt.Sprintf("%x", b.Hash), "Conflicting hash", fmt.Sprintf("%x", hash))
+			}
+			m.checked[n] = h
+		}
+		if height > highest {
+			highest = height
+		}
+		if lowest < 0 || height < lowest {
+			lowest = height
+		}
+	}
+
+	if highest > m.highest {
+		if m.stalled {
+			m.stalled = false
+			m.logger.Crit("Finalization resumed", "Height", highest, "Stall", now.Sub(m.progressed))
+		}
+		m.highest = highest
+		m.progressed = now
+	} else if !m.stalled && now.Sub(m.progressed) >= m.stallTimeout {
+		m.stalled = true
+		m.logger.Crit("Finalization stalled", "Height", m.highest)
+	}
+	if m.healing && lowest > m.healedHeight {
+		m.healing = false
+		m.logger.Crit("Recovered from partition", "Height", lowest, "Recovery time", now.Sub(m.healedAt))
+	}
+}
+
+// finalizedHeight returns the highest height finalized by validators
+func (m *monitor) finalizedHeight() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.highest
+}
+
+// heal starts measuring recovery from partition healed at now
+func (m *monitor) heal(now time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.healing = true
+	m.healedAt = now
+	m.healedHeight = m.highest
+}
+
+// startPartitions partitions and heals simulated network as scheduled by the scenario
+func startPartitions(logger log.Logger, run *scenario.Scenario, clk clock.Clock, genesisTime time.Time,
+	simulation *mynet.Simulation, addressbook node.Addressbook, m *monitor) {
+	if len(run.Partitions) == 0 {
+		return
+	}
+	clk.Go(func() {
+		clk.Sleep(genesisTime.Sub(clk.Now()))
+		for _, p := range run.Partitions {
+			if p.Height > 0 {
+				for m.finalizedHeight() < p.Height {
+					clk.Sleep(monitorInterval)
+				}
+			} else {
+				clk.Sleep(genesisTime.Add(p.At.Duration).Sub(clk.Now()))
+			}
+
+			groups := make([][]mynet.Address, len(p.Groups))
+			for i, group := range p.Groups {
+				for _, id := range group {
+					groups[i] = append(groups[i], addressbook[id].Address)
+				}
+			}
+			logger.Warn("Partition network", "Groups", fmt.Sprint(p.Groups), "Hold", p.Hold)
+			simulation.Partition(groups, p.Hold)
+			if p.Duration.Duration == 0 {
+				// Partitioned until the next partition if any, scenario validation
+				// keeps permanent partition the last one
+				continue
+			}
+
+			clk.Sleep(p.Duration.Duration)
+			simulation.Heal()
+			m.heal(clk.Now())
+			logger.Warn("Heal network")
+		}
+	})
+}
+
+// startSchedule starts late joining validators and stops and restarts
+// validators as scheduled by the scenario, restarted validator listens on
+// its address again
+func startSchedule(logger log.Logger, run *scenario.Scenario, clk clock.Clock, genesisTime time.Time,
+	nodes *validators, newValidator func(id types.ID) *node.Node, listen func(id types.ID) mynet.Transport) {
+	for i := 1; i <= run.Validators; i++ {
+		id := types.ID(i)
+		join, late := run.JoinTime(id)
+		churn := make([]scenario.Churn, 0)
+		for _, c := range run.Churn {
+			if c.Validator == id {
+				churn = append(churn, c)
+			}
+		}
+		if !late && len(churn) == 0 {
+			continue
+		}
+
+		clk.Go(func() {
+			start := func(n *node.Node) {
+				var wg sync.WaitGroup
+				wg.Add(1)
+				clk.Go(func() { n.Start(genesisTime, &wg) })
+			}
+
+			n := nodes.get(id)
+			if late {
+				clk.Sleep(genesisTime.Add(join).Sub(clk.Now()))
+				logger.Warn("Join validator", "ID", id)
+				n = newValidator(id)
+				nodes.add(n)
+				start(n)
+			}
+
+			for _, c := range churn {
+				clk.Sleep(genesisTime.Add(c.Stop.Duration).Sub(clk.Now()))
+				logger.Warn("Stop validator", "ID", id)
+				n.Stop()
+				if c.Restart.Duration == 0 {
+					return
+				}
+
+				clk.Sleep(genesisTime.Add(c.Restart.Duration).Sub(clk.Now()))
+				logger.Warn("Restart validator", "ID", id)
+				n = n.Restart(listen(id))
+				nodes.replace(n)
+				start(n)
+			}
+		})
+	}
+}
+
+// serveMetrics serves metrics of nodes for Prometheus
+func serveMetrics(logger log.Logger, address string, nodes *validators) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(func() map[int]metrics.Snapshot {
+		return snapshot(nodes.list())
+	}))
+	go func() {
+		if err := http.ListenAndServe(address, mux); err != nil {
+			logger.Error("Cannot serve metrics", "Address", address, "Error", err)
 		}
 	}()
+	logger.Info("Serve metrics", "Address", address)
+}
+
+func snapshot(nodes []*node.Node) map[int]metrics.Snapshot {
+	snapshots := make(map[int]metrics.Snapshot)
+	for _, n := range nodes {
+		snapshots[int(n.ID())] = n.Metrics().Snapshot()
+	}
+	return snapshots
+}
+
+// writeMetrics writes metrics of every node to the file
+func writeMetrics(path string, nodes []*node.Node) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return metrics.WriteJSON(file, snapshot(nodes))
 }
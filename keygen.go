@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mathrand "math/rand"
+	"os"
+	"strings"
+
+	"github.com/hdac-io/simulator/keystore"
+	"github.com/hdac-io/simulator/types"
+)
+
+// keygen generates keys of validators into keystore files and public addressbook
+func keygen(args []string) error {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	validators := flags.Int("validators", 21, "number of validators")
+	out := flags.String("out", "keys", "directory of keystore files and addressbook")
+	host := flags.String("host", defaultIP, "IP address of validators in addressbook")
+	port := flags.Int("port", 7000, "validator i listens on port + i")
+	seed := flags.Int64("seed", 0, "random seed of reproducible insecure keys for tests, keys are random if zero")
+	passphraseFile := flags.String("passphrase-file", "", "file of passphrase encrypting keystore files, they are not encrypted if empty")
+	flags.Parse(args)
+
+	if *validators <= 0 {
+		return fmt.Errorf("Invalid number of validators: %d", *validators)
+	}
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	var random io.Reader = rand.Reader
+	if *seed != 0 {
+		random = mathrand.New(mathrand.NewSource(*seed))
+	}
+	if err := os.MkdirAll(*out, 0700); err != nil {
+		return err
+	}
+
+	keys := make([]keystore.Key, 0, *validators)
+	for i := 1; i <= *validators; i++ {
+		key, err := keystore.Generate(types.ID(i), fmt.Sprintf("%s:%d", *host, *port+i), random)
+		if err != nil {
+			return err
+		}
+		if err := keystore.Save(*out, key, passphrase); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	if err := keystore.SaveAddressbook(*out, keys); err != nil {
+		return err
+	}
+	fmt.Printf("Generated keys of %d validators in %s\n", *validators, *out)
+	return nil
+}
+
+// readPassphrase reads passphrase of the file without trailing newline, empty if path is empty
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,297 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+)
+
+// AddressbookFile is name of public addressbook in keystore directory
+const AddressbookFile = "addressbook.json"
+
+// iterations of PBKDF2 deriving encryption key from passphrase
+const iterations = 1 << 18
+
+// Key represents BLS and VRF key pairs of a validator, keys are hex encoded
+type Key struct {
+	ID           types.ID `json:"id"`
+	Address      string   `json:"address"`
+	BLSSecret    string   `json:"blsSecret"`
+	BLSPublicKey string   `json:"blsPublicKey"`
+	VRFSecret    string   `json:"vrfSecret"`
+	VRFPublicKey string   `json:"vrfPublicKey"`
+}
+
+// Entry represents public keys of a validator in addressbook
+type Entry struct {
+	ID           types.ID `json:"id"`
+	Address      string   `json:"address"`
+	BLSPublicKey string   `json:"blsPublicKey"`
+	VRFPublicKey string   `json:"vrfPublicKey"`
+}
+
+// file is keystore file of a validator, secrets are either plain or encrypted
+type file struct {
+	Entry
+	Secrets *secrets `json:"secrets,omitempty"`
+	Crypto  *crypto  `json:"crypto,omitempty"`
+}
+
+type secrets struct {
+	BLS string `json:"bls"`
+	VRF string `json:"vrf"`
+}
+
+// crypto is secrets encrypted by AES-256-GCM with key derived from passphrase
+// by PBKDF2-HMAC-SHA256
+type crypto struct {
+	Cipher     string `json:"cipher"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// Generate generates key pairs of the validator from random
+func Generate(id types.ID, address string, random io.Reader) (Key, error) {
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(random, buf); err != nil {
+		return Key{}, err
+	}
+	var secret bls.SecretKey
+	if err := secret.SetLittleEndian(buf); err != nil {
+		return Key{}, err
+	}
+	vrfSecret, vrfPublicKey := vrfmessage.GenerateKey(random)
+
+	return Key{
+		ID:           id,
+		Address:      address,
+		BLSSecret:    secret.SerializeToHexStr(),
+		BLSPublicKey: secret.GetPublicKey().SerializeToHexStr(),
+		VRFSecret:    hex.EncodeToString(vrfmessage.EncodePrivateKey(vrfSecret)),
+		VRFPublicKey: hex.EncodeToString(vrfmessage.EncodePublicKey(vrfPublicKey)),
+	}, nil
+}
+
+// Validate checks that public keys belong to secrets
+func (k Key) Validate() error {
+	var secret bls.SecretKey
+	if err := secret.DeserializeHexStr(k.BLSSecret); err != nil {
+		return err
+	}
+	if secret.GetPublicKey().SerializeToHexStr() != k.BLSPublicKey {
+		return errors.New("BLS public key does not match secret")
+	}
+	data, err := hex.DecodeString(k.VRFSecret)
+	if err != nil {
+		return err
+	}
+	_, vrfPublicKey, err := vrfmessage.DecodePrivateKey(data)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(vrfmessage.EncodePublicKey(vrfPublicKey)) != k.VRFPublicKey {
+		return errors.New("VRF public key does not match secret")
+	}
+	return nil
+}
+
+// Entry returns public part of the key
+func (k Key) Entry() Entry {
+	return Entry{ID: k.ID, Address: k.Address, BLSPublicKey: k.BLSPublicKey, VRFPublicKey: k.VRFPublicKey}
+}
+
+// Path returns path of keystore file of the validator in directory
+func Path(dir string, id types.ID) string {
+	return filepath.Join(dir, fmt.Sprintf("validator-%d.json", id))
+}
+
+// Save writes keystore file of the key readable only by owner, secrets are
+// encrypted unless passphrase is empty
+func Save(dir string, key Key, passphrase string) error {
+	f := file{Entry: key.Entry()}
+	plain := secrets{BLS: key.BLSSecret, VRF: key.VRFSecret}
+	if passphrase == "" {
+		f.Secrets = &plain
+	} else {
+		data, err := json.Marshal(plain)
+		if err != nil {
+			return err
+		}
+		if f.Crypto, err = encrypt(data, passphrase); err != nil {
+			return err
+		}
+	}
+	return writeJSON(Path(dir, key.ID), f, 0600)
+}
+
+// Load reads keystore file of the validator, passphrase is required if it is encrypted
+func Load(dir string, id types.ID, passphrase string) (Key, error) {
+	var f file
+	if err := readJSON(Path(dir, id), &f); err != nil {
+		return Key{}, err
+	}
+	if f.ID != id {
+		return Key{}, fmt.Errorf("Keystore file of validator %d has ID %d", id, f.ID)
+	}
+
+	plain := f.Secrets
+	if f.Crypto != nil {
+		if passphrase == "" {
+			return Key{}, errors.New("Keystore is encrypted, passphrase is required")
+		}
+		data, err := decrypt(f.Crypto, passphrase)
+		if err != nil {
+			return Key{}, err
+		}
+		plain = &secrets{}
+		if err := json.Unmarshal(data, plain); err != nil {
+			return Key{}, err
+		}
+	}
+	if plain == nil {
+		return Key{}, errors.New("Keystore without secrets")
+	}
+
+	key := Key{
+		ID:           f.ID,
+		Address:      f.Address,
+		BLSSecret:    plain.BLS,
+		BLSPublicKey: f.BLSPublicKey,
+		VRFSecret:    plain.VRF,
+		VRFPublicKey: f.VRFPublicKey,
+	}
+	return key, key.Validate()
+}
+
+// SaveAddressbook writes public keys of validators to addressbook file in directory
+func SaveAddressbook(dir string, keys []Key) error {
+	entries := make([]Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = key.Entry()
+	}
+	return writeJSON(filepath.Join(dir, AddressbookFile), entries, 0644)
+}
+
+// LoadAddressbook reads addressbook file in directory
+func LoadAddressbook(dir string) ([]Entry, error) {
+	var entries []Entry
+	if err := readJSON(filepath.Join(dir, AddressbookFile), &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("Empty addressbook")
+	}
+	return entries, nil
+}
+
+func writeJSON(path string, v interface{}, perm os.FileMode) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), perm)
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func encrypt(data []byte, passphrase string) (*crypto, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &crypto{
+		Cipher:     "aes-256-gcm",
+		KDF:        "pbkdf2-sha256",
+		Iterations: iterations,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(gcm.Seal(nil, nonce, data, nil)),
+	}, nil
+}
+
+func decrypt(c *crypto, passphrase string) ([]byte, error) {
+	if c.Cipher != "aes-256-gcm" || c.KDF != "pbkdf2-sha256" || c.Iterations <= 0 {
+		return nil, errors.New("Unsupported keystore encryption")
+	}
+	salt, err := hex.DecodeString(c.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(c.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := hex.DecodeString(c.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt, c.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("Invalid keystore nonce")
+	}
+	data, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("Wrong passphrase or corrupted keystore")
+	}
+	return data, nil
+}
+
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives 32 bytes key by PBKDF2-HMAC-SHA256 of RFC 8018
+func pbkdf2(passphrase []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
@@ -0,0 +1,54 @@
+package keystore
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	random := rand.New(rand.NewSource(1))
+	plain, err := Generate(1, "127.0.0.1:7001", random)
+	require.NoError(t, err)
+	require.NoError(t, plain.Validate())
+	encrypted, err := Generate(2, "127.0.0.1:7002", random)
+	require.NoError(t, err)
+
+	require.NoError(t, Save(dir, plain, ""))
+	require.NoError(t, Save(dir, encrypted, "secret"))
+	require.NoError(t, SaveAddressbook(dir, []Key{plain, encrypted}))
+
+	key, err := Load(dir, 1, "")
+	require.NoError(t, err)
+	require.Equal(t, plain, key)
+	key, err = Load(dir, 2, "secret")
+	require.NoError(t, err)
+	require.Equal(t, encrypted, key)
+	_, err = Load(dir, 2, "")
+	require.Error(t, err)
+	_, err = Load(dir, 2, "wrong")
+	require.Error(t, err)
+
+	// Keystore file of encrypted key does not reveal secrets
+	data, err := ioutil.ReadFile(Path(dir, 2))
+	require.NoError(t, err)
+	require.NotContains(t, string(data), encrypted.VRFSecret)
+
+	entries, err := LoadAddressbook(dir)
+	require.NoError(t, err)
+	require.Equal(t, []Entry{plain.Entry(), encrypted.Entry()}, entries)
+}
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector of RFC 7914
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1)
+	require.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc", hex.EncodeToString(key))
+}
@@ -0,0 +1,128 @@
+package load
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/types"
+)
+
+// Arrival patterns of transactions
+const (
+	Constant = "constant"
+	Poisson  = "poisson"
+)
+
+// Payloads of transactions
+const (
+	// Random payload is random bytes
+	Random = "random"
+	// KVStore payload sets values and transfers balances on kvstore application
+	KVStore = "kvstore"
+)
+
+// Keys and accounts used by kvstore payloads
+const (
+	kvstoreKeys     = 1024
+	kvstoreAccounts = 64
+)
+
+// Spec describes synthetic load submitted by each validator, zero rate submits nothing
+type Spec struct {
+	Pattern string
+	Rate    float64 // Transactions per second
+	Size    int     // Payload size in bytes, value size of kvstore payload
+	Payload string
+}
+
+// Generator generates transactions of a validator
+type Generator struct {
+	spec   Spec
+	from   types.ID
+	random *rand.Rand
+	nonce  uint64
+}
+
+// New constructs generator of transactions from the validator drawing samples from random
+func (spec Spec) New(from types.ID, random *rand.Rand) *Generator {
+	return &Generator{
+		spec:   spec,
+		from:   from,
+		random: random,
+	}
+}
+
+// Interval returns time until the next transaction
+func (g *Generator) Interval() time.Duration {
+	mean := float64(time.Second) / g.spec.Rate
+	if g.spec.Pattern == Poisson {
+		// Exponentially distributed inter-arrival time
+		return time.Duration(g.random.ExpFloat64() * mean)
+	}
+	return time.Duration(mean)
+}
+
+// Next returns the next transaction submitted at now
+func (g *Generator) Next(now time.Time) block.Transaction {
+	g.nonce++
+	return block.Transaction{
+		From:      g.from,
+		Nonce:     g.nonce,
+		Timestamp: now.UnixNano(),
+		Payload:   g.payload(),
+	}
+}
+
+func (g *Generator) payload() []byte {
+	if g.spec.Payload != KVStore {
+		payload := make([]byte, g.spec.Size)
+		g.random.Read(payload)
+		return payload
+	}
+
+	if g.random.Intn(2) == 0 {
+		return app.EncodeTransfer(types.ID(g.random.Intn(kvstoreAccounts)+1), uint64(g.random.Intn(100)+1))
+	}
+	value := make([]byte, g.spec.Size)
+	g.random.Read(value)
+	return app.EncodeSet([]byte(fmt.Sprintf("key-%d", g.random.Intn(kvstoreKeys))), value)
+}
+
+// ParseSpec parses load description such as "constant:100:256" or
+// "poisson:100:256:kvstore", arguments are transactions per second, payload
+// size and optional payload which is random by default
+func ParseSpec(s string) (Spec, error) {
+	fields := strings.Split(s, ":")
+	spec := Spec{Pattern: fields[0], Payload: Random}
+	if spec.Pattern != Constant && spec.Pattern != Poisson {
+		return spec, errors.New("Unknown load pattern: " + spec.Pattern)
+	}
+	if len(fields) != 3 && len(fields) != 4 {
+		return spec, errors.New("Usage: " + spec.Pattern + ":<rate>:<size>[:<payload>]")
+	}
+	if len(fields) == 4 {
+		spec.Payload = fields[3]
+		if spec.Payload != Random && spec.Payload != KVStore {
+			return spec, errors.New("Unknown load payload: " + spec.Payload)
+		}
+	}
+
+	var err error
+	if spec.Rate, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return spec, err
+	}
+	if spec.Size, err = strconv.Atoi(fields[2]); err != nil {
+		return spec, err
+	}
+	if spec.Rate <= 0 || spec.Size < 0 {
+		return spec, errors.New("Load rate should be positive and size should not be negative")
+	}
+
+	return spec, nil
+}
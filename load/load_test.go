@@ -0,0 +1,47 @@
+package load
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec("poisson:100:256")
+	require.NoError(t, err)
+	require.Equal(t, Spec{Pattern: Poisson, Rate: 100, Size: 256, Payload: Random}, spec)
+	spec, err = ParseSpec("constant:10:32:kvstore")
+	require.NoError(t, err)
+	require.Equal(t, Spec{Pattern: Constant, Rate: 10, Size: 32, Payload: KVStore}, spec)
+
+	_, err = ParseSpec("constant:100")
+	require.Error(t, err)
+	_, err = ParseSpec("constant:0:256")
+	require.Error(t, err)
+	_, err = ParseSpec("burst:100:256")
+	require.Error(t, err)
+	_, err = ParseSpec("constant:100:256:json")
+	require.Error(t, err)
+}
+
+func TestGenerator(t *testing.T) {
+	g := Spec{Pattern: Constant, Rate: 50, Size: 16}.New(3, rand.New(rand.NewSource(1)))
+	require.Equal(t, 20*time.Millisecond, g.Interval())
+
+	first := g.Next(time.Unix(1, 0))
+	second := g.Next(time.Unix(2, 0))
+	require.Equal(t, 16, len(first.Payload))
+	require.Equal(t, uint64(1), first.Nonce)
+	require.Equal(t, uint64(2), second.Nonce)
+	require.NotEqual(t, first.Hash(), second.Hash())
+
+	// Poisson arrivals average to the rate
+	g = Spec{Pattern: Poisson, Rate: 50, Size: 16}.New(3, rand.New(rand.NewSource(1)))
+	var total time.Duration
+	for i := 0; i < 10000; i++ {
+		total += g.Interval()
+	}
+	require.InDelta(t, float64(20*time.Millisecond), float64(total/10000), float64(time.Millisecond))
+}
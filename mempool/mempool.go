@@ -0,0 +1,122 @@
+package mempool
+
+import (
+	"sync"
+
+	"github.com/hdac-io/simulator/block"
+)
+
+// Mempool keeps pending transactions in arrival order until they are included in a block
+type Mempool struct {
+	sync.Mutex
+	capacity int
+	pending  []block.Transaction
+	hashes   map[[32]byte]bool
+
+	// Included transactions are not accepted again until the block is finalized
+	included map[[32]byte]bool
+	heights  map[int][][32]byte
+}
+
+// New constructs mempool keeping at most capacity transactions
+func New(capacity int) *Mempool {
+	return &Mempool{
+		capacity: capacity,
+		pending:  make([]block.Transaction, 0),
+		hashes:   make(map[[32]byte]bool),
+		included: make(map[[32]byte]bool),
+		heights:  make(map[int][][32]byte),
+	}
+}
+
+// Add adds transaction, it returns false if the transaction is known or the mempool is full
+func (m *Mempool) Add(tx block.Transaction) bool {
+	hash := tx.Hash()
+	m.Lock()
+	defer m.Unlock()
+	if m.hashes[hash] || m.included[hash] || len(m.pending) >= m.capacity {
+		return false
+	}
+	m.pending = append(m.pending, tx)
+	m.hashes[hash] = true
+	return true
+}
+
+// Reap returns the oldest transactions up to maxCount transactions and
+// maxBytes bytes, the transactions remain until they are included
+func (m *Mempool) Reap(maxCount int, maxBytes int) []block.Transaction {
+	m.Lock()
+	defer m.Unlock()
+	txs := make([]block.Transaction, 0)
+	size := 0
+	for _, tx := range m.pending {
+		if len(txs) >= maxCount || size+tx.Size() > maxBytes {
+			break
+		}
+		txs = append(txs, tx)
+		size += tx.Size()
+	}
+	return txs
+}
+
+// Update removes transactions included in a block of the height
+func (m *Mempool) Update(height int, txs []block.Transaction) {
+	if len(txs) == 0 {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, tx := range txs {
+		hash := tx.Hash()
+		m.included[hash] = true
+		m.heights[height] = append(m.heights[height], hash)
+		delete(m.hashes, hash)
+	}
+	pending := make([]block.Transaction, 0, len(m.pending))
+	for _, tx := range m.pending {
+		if m.hashes[tx.Hash()] {
+			pending = append(pending, tx)
+		}
+	}
+	m.pending = pending
+}
+
+// Rollback returns transactions of the rolled back block of the height to
+// the front of pending transactions
+func (m *Mempool) Rollback(height int, txs []block.Transaction) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.heights, height)
+	restored := make([]block.Transaction, 0, len(txs)+len(m.pending))
+	for _, tx := range txs {
+		hash := tx.Hash()
+		delete(m.included, hash)
+		if !m.hashes[hash] {
+			restored = append(restored, tx)
+			m.hashes[hash] = true
+		}
+	}
+	m.pending = append(restored, m.pending...)
+}
+
+// Prune forgets transactions included in blocks up to the finalized height
+func (m *Mempool) Prune(height int) {
+	m.Lock()
+	defer m.Unlock()
+	for h, hashes := range m.heights {
+		if h > height {
+			continue
+		}
+		for _, hash := range hashes {
+			delete(m.included, hash)
+		}
+		delete(m.heights, h)
+	}
+}
+
+// Size returns number of pending transactions
+func (m *Mempool) Size() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.pending)
+}
@@ -0,0 +1,41 @@
+package mempool
+
+import (
+	"testing"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/stretchr/testify/require"
+)
+
+func transaction(nonce uint64, size int) block.Transaction {
+	return block.Transaction{From: 1, Nonce: nonce, Payload: make([]byte, size)}
+}
+
+func TestMempool(t *testing.T) {
+	m := New(3)
+	require.True(t, m.Add(transaction(1, 10)))
+	require.False(t, m.Add(transaction(1, 10)))
+	require.True(t, m.Add(transaction(2, 10)))
+	require.True(t, m.Add(transaction(3, 10)))
+	require.False(t, m.Add(transaction(4, 10)))
+
+	// Limited by count and bytes in arrival order
+	require.Equal(t, []block.Transaction{transaction(1, 10), transaction(2, 10)}, m.Reap(2, 1000))
+	require.Equal(t, []block.Transaction{transaction(1, 10)}, m.Reap(10, 2*transaction(1, 10).Size()-1))
+	require.Equal(t, 3, m.Size())
+
+	// Included transactions are removed and not accepted again
+	m.Update(1, []block.Transaction{transaction(2, 10)})
+	require.Equal(t, []block.Transaction{transaction(1, 10), transaction(3, 10)}, m.Reap(10, 1000))
+	require.False(t, m.Add(transaction(2, 10)))
+	require.True(t, m.Add(transaction(4, 10)))
+
+	// Transactions of finalized blocks are forgotten
+	m.Update(2, []block.Transaction{transaction(1, 10)})
+	m.Prune(1)
+	require.Len(t, m.included, 1)
+	require.Len(t, m.heights, 1)
+	m.Prune(2)
+	require.Empty(t, m.included)
+	require.Empty(t, m.heights)
+}
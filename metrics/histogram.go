@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Buckets are upper bounds of histogram buckets, from 1ms doubling up to about 1 minute
+var Buckets = func() []time.Duration {
+	buckets := make([]time.Duration, 0)
+	for b := time.Millisecond; b <= time.Minute; b *= 2 {
+		buckets = append(buckets, b)
+	}
+	return buckets
+}()
+
+// Histogram represents distribution of durations
+type Histogram struct {
+	count int64
+	sum   time.Duration
+	min   time.Duration
+	max   time.Duration
+	// Last bucket counts durations over every upper bound
+	buckets []int64
+}
+
+func newHistogram() *Histogram {
+	return &Histogram{
+		buckets: make([]int64, len(Buckets)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if h.count == 0 || d > h.max {
+		h.max = d
+	}
+	h.count++
+	h.sum += d
+	i := 0
+	for i < len(Buckets) && d > Buckets[i] {
+		i++
+	}
+	h.buckets[i]++
+}
+
+func (h *Histogram) copy() *Histogram {
+	c := *h
+	c.buckets = append([]int64(nil), h.buckets...)
+	return &c
+}
+
+func (h *Histogram) merge(other *Histogram) {
+	if other.count == 0 {
+		return
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if h.count == 0 || other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.sum += other.sum
+	for i := range h.buckets {
+		h.buckets[i] += other.buckets[i]
+	}
+}
+
+// Count returns number of observations
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Sum returns sum of observations
+func (h *Histogram) Sum() time.Duration {
+	return h.sum
+}
+
+// Min returns the smallest observation
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest observation
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns average of observations
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// BucketCounts returns cumulative number of observations less than or equal to each of Buckets
+func (h *Histogram) BucketCounts() []int64 {
+	counts := make([]int64, len(Buckets))
+	var cumulative int64
+	for i := range Buckets {
+		cumulative += h.buckets[i]
+		counts[i] = cumulative
+	}
+	return counts
+}
+
+// Quantile estimates q-quantile by interpolating in the bucket
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := q * float64(h.count)
+	var cumulative int64
+	for i, n := range h.buckets {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		lower := h.min
+		if i > 0 && Buckets[i-1] > lower {
+			lower = Buckets[i-1]
+		}
+		upper := h.max
+		if i < len(Buckets) && Buckets[i] < upper {
+			upper = Buckets[i]
+		}
+		fraction := (rank - float64(cumulative)) / float64(n)
+		return lower + time.Duration(fraction*float64(upper-lower))
+	}
+	return h.max
+}
+
+// MarshalJSON writes summary of the histogram
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count   int64   `json:"count"`
+		Sum     string  `json:"sum"`
+		Min     string  `json:"min"`
+		Max     string  `json:"max"`
+		Mean    string  `json:"mean"`
+		P50     string  `json:"p50"`
+		P90     string  `json:"p90"`
+		P99     string  `json:"p99"`
+		Buckets []int64 `json:"buckets"`
+	}{
+		Count:   h.count,
+		Sum:     h.sum.String(),
+		Min:     h.min.String(),
+		Max:     h.max.String(),
+		Mean:    h.Mean().String(),
+		P50:     h.Quantile(0.5).String(),
+		P90:     h.Quantile(0.9).String(),
+		P99:     h.Quantile(0.99).String(),
+		Buckets: h.buckets,
+	})
+}
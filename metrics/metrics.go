@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Histograms
+const (
+	// BlockPropagation is delay from block timestamp to receiving the block
+	BlockPropagation = "block_propagation"
+	// PrepareQuorumWait is time waiting for quorum of prepare votes
+	PrepareQuorumWait = "prepare_quorum_wait"
+	// CommitQuorumWait is time waiting for quorum of commit votes
+	CommitQuorumWait = "commit_quorum_wait"
+	// BLSAggregation is wall time aggregating BLS signatures of votes, also under virtual clock
+	BLSAggregation = "bls_aggregation"
+	// FinalizationLatency is delay from block timestamp to finalizing the block
+	FinalizationLatency = "finalization_latency"
+	// RecoveryTime is time from starting a validator after genesis by restart
+	// or late join to joining consensus
+	RecoveryTime = "recovery_time"
+	// TransactionLatency is delay from submitting transaction to finalizing it
+	TransactionLatency = "transaction_latency"
+)
+
+// Gauges
+const (
+	Height          = "height"
+	FinalizedHeight = "finalized_height"
+	ConfirmedHeight = "confirmed_height"
+	MempoolSize     = "mempool_size"
+	Peers           = "peers"
+)
+
+// Counters
+const (
+	// TransactionsFinalized is number of transactions in finalized blocks
+	TransactionsFinalized = "transactions_finalized"
+	// TransactionsFailed is number of finalized transactions rejected by the application
+	TransactionsFailed = "transactions_failed"
+	// StateDivergences is number of finalized blocks proposed on app state different from this node
+	StateDivergences = "state_divergences"
+	// HandshakeFailures is number of connections closed by failed handshake
+	HandshakeFailures = "handshake_failures"
+	// PeerDisconnects is number of connections lost to authenticated peers
+	PeerDisconnects = "peer_disconnects"
+	// Reconnects is number of lost peers connected again by redialing
+	Reconnects = "reconnects"
+	// GossipDuplicates is number of gossiped messages received again
+	GossipDuplicates = "gossip_duplicates"
+)
+
+// Counters by kind of message
+const (
+	MessagesSent     = "messages_sent"
+	MessagesReceived = "messages_received"
+	BytesSent        = "bytes_sent"
+	BytesReceived    = "bytes_received"
+)
+
+// WithKind returns name of the metric labeled by kind
+func WithKind(name string, kind string) string {
+	return name + `{kind="` + kind + `"}`
+}
+
+// Registry contains metrics of a node
+type Registry struct {
+	sync.Mutex
+	gauges     map[string]func() float64
+	counters   map[string]int64
+	histograms map[string]*Histogram
+}
+
+// NewRegistry constructs empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		gauges:     make(map[string]func() float64),
+		counters:   make(map[string]int64),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// Gauge registers gauge whose value is read by value when taking snapshot
+func (r *Registry) Gauge(name string, value func() float64) {
+	r.Lock()
+	r.gauges[name] = value
+	r.Unlock()
+}
+
+// Add adds delta to the counter
+func (r *Registry) Add(name string, delta int64) {
+	r.Lock()
+	r.counters[name] += delta
+	r.Unlock()
+}
+
+// Observe records duration to the histogram
+func (r *Registry) Observe(name string, d time.Duration) {
+	r.Lock()
+	h, exist := r.histograms[name]
+	if !exist {
+		h = newHistogram()
+		r.histograms[name] = h
+	}
+	h.observe(d)
+	r.Unlock()
+}
+
+// Snapshot returns copy of the metrics
+func (r *Registry) Snapshot() Snapshot {
+	r.Lock()
+	s := newSnapshot()
+	gauges := make(map[string]func() float64, len(r.gauges))
+	for name, value := range r.gauges {
+		gauges[name] = value
+	}
+	for name, value := range r.counters {
+		s.Counters[name] = value
+	}
+	for name, h := range r.histograms {
+		s.Histograms[name] = h.copy()
+	}
+	r.Unlock()
+
+	// Gauges take locks of their owners which may update metrics meanwhile
+	for name, value := range gauges {
+		s.Gauges[name] = value()
+	}
+	return s
+}
+
+// Snapshot represents metrics at a moment
+type Snapshot struct {
+	Gauges     map[string]float64    `json:"gauges"`
+	Counters   map[string]int64      `json:"counters"`
+	Histograms map[string]*Histogram `json:"histograms"`
+}
+
+func newSnapshot() Snapshot {
+	return Snapshot{
+		Gauges:     make(map[string]float64),
+		Counters:   make(map[string]int64),
+		Histograms: make(map[string]*Histogram),
+	}
+}
+
+// Merge sums up snapshots of nodes, gauges are merged into the largest value
+func Merge(snapshots ...Snapshot) Snapshot {
+	merged := newSnapshot()
+	for _, s := range snapshots {
+		for name, value := range s.Gauges {
+			if current, exist := merged.Gauges[name]; !exist || value > current {
+				merged.Gauges[name] = value
+			}
+		}
+		for name, value := range s.Counters {
+			merged.Counters[name] += value
+		}
+		for name, h := range s.Histograms {
+			if _, exist := merged.Histograms[name]; !exist {
+				merged.Histograms[name] = newHistogram()
+			}
+			merged.Histograms[name].merge(h)
+		}
+	}
+
+	return merged
+}
+
+// Names returns sorted names of the histograms
+func (s Snapshot) Names() []string {
+	names := make([]string, 0, len(s.Histograms))
+	for name := range s.Histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GaugeNames returns sorted names of the gauges
+func (s Snapshot) GaugeNames() []string {
+	names := make([]string, 0, len(s.Gauges))
+	for name := range s.Gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CounterNames returns sorted names of the counters
+func (s Snapshot) CounterNames() []string {
+	names := make([]string, 0, len(s.Counters))
+	for name := range s.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteJSON writes snapshots of nodes by ID
+func WriteJSON(w io.Writer, snapshots map[int]Snapshot) error {
+	out := make(map[string]Snapshot)
+	for id, s := range snapshots {
+		out[strconv.Itoa(id)] = s
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
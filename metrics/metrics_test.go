@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	for i := 1; i <= 100; i++ {
+		r.Observe(FinalizationLatency, time.Duration(i)*time.Millisecond)
+	}
+	h := r.Snapshot().Histograms[FinalizationLatency]
+	require.Equal(t, int64(100), h.Count())
+	require.Equal(t, time.Millisecond, h.Min())
+	require.Equal(t, 100*time.Millisecond, h.Max())
+	require.Equal(t, 50500*time.Microsecond, h.Mean())
+
+	// Quantiles are estimated within the bucket
+	p50 := h.Quantile(0.5)
+	require.True(t, p50 > 32*time.Millisecond && p50 <= 64*time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, h.Quantile(1))
+}
+
+func TestMerge(t *testing.T) {
+	a := NewRegistry()
+	b := NewRegistry()
+	a.Add(WithKind(MessagesSent, "block"), 1)
+	b.Add(WithKind(MessagesSent, "block"), 2)
+	a.Observe(BlockPropagation, time.Millisecond)
+	b.Observe(BlockPropagation, time.Second)
+
+	merged := Merge(a.Snapshot(), b.Snapshot())
+	require.Equal(t, int64(3), merged.Counters[`messages_sent{kind="block"}`])
+	require.Equal(t, int64(2), merged.Histograms[BlockPropagation].Count())
+	require.Equal(t, time.Millisecond, merged.Histograms[BlockPropagation].Min())
+	require.Equal(t, time.Second, merged.Histograms[BlockPropagation].Max())
+
+	// Snapshot is not changed by later observations
+	snapshot := a.Snapshot()
+	a.Observe(BlockPropagation, time.Minute)
+	require.Equal(t, int64(1), snapshot.Histograms[BlockPropagation].Count())
+}
+
+func TestSnapshotGauge(t *testing.T) {
+	r := NewRegistry()
+	// Gauge may update the registry like status counting divergence under its lock
+	r.Gauge(Height, func() float64 {
+		r.Add(StateDivergences, 1)
+		return 1
+	})
+	snapshot := r.Snapshot()
+	require.Equal(t, float64(1), snapshot.Gauges[Height])
+	require.Equal(t, int64(1), r.Snapshot().Counters[StateDivergences])
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Gauge(Height, func() float64 { return 3 })
+	r.Add(WithKind(MessagesSent, "prepare"), 5)
+	r.Observe(FinalizationLatency, 3*time.Millisecond)
+
+	var buf bytes.Buffer
+	require.Nil(t, WritePrometheus(&buf, map[int]Snapshot{1: r.Snapshot()}))
+	out := buf.String()
+	require.Contains(t, out, "# TYPE simulator_height gauge\nsimulator_height{validator=\"1\"} 3\n")
+	require.Contains(t, out, "# TYPE simulator_messages_sent_total counter\nsimulator_messages_sent_total{validator=\"1\",kind=\"prepare\"} 5\n")
+	require.Contains(t, out, "simulator_finalization_latency_seconds_bucket{validator=\"1\",le=\"0.002\"} 0\n")
+	require.Contains(t, out, "simulator_finalization_latency_seconds_bucket{validator=\"1\",le=\"0.004\"} 1\n")
+	require.Contains(t, out, "simulator_finalization_latency_seconds_bucket{validator=\"1\",le=\"+Inf\"} 1\n")
+	require.Contains(t, out, "simulator_finalization_latency_seconds_sum{validator=\"1\"} 0.003\n")
+	require.Contains(t, out, "simulator_finalization_latency_seconds_count{validator=\"1\"} 1\n")
+}
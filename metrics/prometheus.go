@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// namespace prefixes every exported metric
+const namespace = "simulator"
+
+type sample struct {
+	suffix string // _bucket, _sum or _count of histogram
+	labels string
+	value  string
+}
+
+type family struct {
+	kind    string
+	samples []sample
+}
+
+// WritePrometheus writes snapshots of validators by ID in Prometheus text format
+func WritePrometheus(w io.Writer, snapshots map[int]Snapshot) error {
+	families := make(map[string]*family)
+	add := func(name string, kind string, s sample) {
+		f, exist := families[name]
+		if !exist {
+			f = &family{kind: kind}
+			families[name] = f
+		}
+		f.samples = append(f.samples, s)
+	}
+
+	ids := make([]int, 0, len(snapshots))
+	for id := range snapshots {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		s := snapshots[id]
+		validator := `validator="` + strconv.Itoa(id) + `"`
+
+		for _, name := range s.GaugeNames() {
+			add(namespace+"_"+name, "gauge", sample{labels: validator, value: formatFloat(s.Gauges[name])})
+		}
+		for _, name := range s.CounterNames() {
+			base, labels := splitLabels(name)
+			add(namespace+"_"+base+"_total", "counter", sample{labels: joinLabels(validator, labels), value: strconv.FormatInt(s.Counters[name], 10)})
+		}
+		for _, name := range s.Names() {
+			h := s.Histograms[name]
+			base, labels := splitLabels(name)
+			metric := namespace + "_" + base + "_seconds"
+			labels = joinLabels(validator, labels)
+			for i, count := range h.BucketCounts() {
+				le := `le="` + formatFloat(Buckets[i].Seconds()) + `"`
+				add(metric, "histogram", sample{suffix: "_bucket", labels: joinLabels(labels, le), value: strconv.FormatInt(count, 10)})
+			}
+			add(metric, "histogram", sample{suffix: "_bucket", labels: joinLabels(labels, `le="+Inf"`), value: strconv.FormatInt(h.Count(), 10)})
+			add(metric, "histogram", sample{suffix: "_sum", labels: labels, value: formatFloat(h.Sum().Seconds())})
+			add(metric, "histogram", sample{suffix: "_count", labels: labels, value: strconv.FormatInt(h.Count(), 10)})
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		f := families[name]
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, f.kind)
+		for _, s := range f.samples {
+			fmt.Fprintf(buf, "%s%s{%s} %s\n", name, s.suffix, s.labels, s.value)
+		}
+	}
+	return buf.Flush()
+}
+
+// Handler serves snapshots returned by source in Prometheus text format
+func Handler(source func() map[int]Snapshot) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WritePrometheus(w, source())
+	})
+}
+
+// splitLabels splits `name{kind="x"}` into name and labels
+func splitLabels(name string) (string, string) {
+	i := strings.IndexByte(name, '{')
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], strings.TrimSuffix(name[i+1:], "}")
+}
+
+func joinLabels(a string, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "," + b
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
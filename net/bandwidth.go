@@ -0,0 +1,86 @@
+package net
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth is transmission rate in bits per second, zero is unlimited
+type Bandwidth int64
+
+var bandwidthUnits = []struct {
+	suffix string
+	bits   int64
+}{
+	{"Gbps", 1000 * 1000 * 1000},
+	{"Mbps", 1000 * 1000},
+	{"kbps", 1000},
+	{"bps", 1},
+}
+
+// ParseBandwidth parses bandwidth written as "100Mbps", "512kbps" or "1Gbps"
+func ParseBandwidth(s string) (Bandwidth, error) {
+	for _, unit := range bandwidthUnits {
+		if !strings.HasSuffix(s, unit.suffix) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+		if err != nil {
+			return 0, err
+		}
+		if value <= 0 {
+			return 0, errors.New("Bandwidth must be positive: " + s)
+		}
+		return Bandwidth(value * float64(unit.bits)), nil
+	}
+	return 0, errors.New("Invalid bandwidth: " + s)
+}
+
+func (b Bandwidth) String() string {
+	if b <= 0 {
+		return "unlimited"
+	}
+	for _, unit := range bandwidthUnits {
+		if int64(b) >= unit.bits && int64(b)%unit.bits == 0 {
+			return strconv.FormatInt(int64(b)/unit.bits, 10) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "bps"
+}
+
+// transmitTime returns time to serialize size bytes
+func (b Bandwidth) transmitTime(size int) time.Duration {
+	if b <= 0 {
+		return 0
+	}
+	return time.Duration(int64(size) * 8 * int64(time.Second) / int64(b))
+}
+
+// Sizer returns size of the load on the wire in bytes
+type Sizer func(l Load) int
+
+// pipe is uplink or downlink of a node shared by its links, loads are
+// transmitted one by one and queue behind loads being transmitted. Nil pipe
+// is unlimited.
+type pipe struct {
+	sync.Mutex
+	bandwidth Bandwidth
+	free      time.Time
+}
+
+// transmit queues size bytes ready at the time and returns when they are transmitted
+func (p *pipe) transmit(at time.Time, size int) time.Time {
+	if p == nil {
+		return at
+	}
+	p.Lock()
+	defer p.Unlock()
+	if at.Before(p.free) {
+		at = p.free
+	}
+	p.free = at.Add(p.bandwidth.transmitTime(size))
+	return p.free
+}
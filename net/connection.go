@@ -3,9 +3,22 @@ package net
 // Load represents network payload
 type Load = interface{}
 
-// Connection represents virtual public network
+// Connection represents virtual public network.
+// Read returns nil after the connection is closed by either side,
+// Err returns the error closed the connection, nil if it is closed normally.
 type Connection interface {
 	Read() Load
 	Write(l Load)
 	GetAddress() Address
+	Close()
+	Err() error
+}
+
+// Transport makes connections between nodes.
+// Accept returns nil after the transport is closed, Connect returns nil
+// if the destination is not listening.
+type Transport interface {
+	Accept() Connection
+	Connect(destination Address) Connection
+	Close()
 }
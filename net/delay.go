@@ -0,0 +1,142 @@
+package net
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delay generates transmission delay of a link
+type Delay func() time.Duration
+
+// Delay distributions
+const (
+	Constant = "constant"
+	Uniform  = "uniform"
+	Normal   = "normal"
+	Pareto   = "pareto"
+)
+
+// DelaySpec describes delay distribution of a link
+type DelaySpec struct {
+	Distribution string
+
+	// Constant
+	Delay time.Duration
+
+	// Uniform
+	Min time.Duration
+	Max time.Duration
+
+	// Normal
+	Mean   time.Duration
+	StdDev time.Duration
+
+	// Pareto (long-tail)
+	Scale time.Duration
+	Shape float64
+}
+
+// ConstantDelay returns fixed delay
+func ConstantDelay(delay time.Duration) Delay {
+	return func() time.Duration {
+		return delay
+	}
+}
+
+// UniformDelay returns delay uniformly distributed in [min, max)
+func UniformDelay(min time.Duration, max time.Duration, random *rand.Rand) Delay {
+	return func() time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(random.Int63n(int64(max-min)))
+	}
+}
+
+// NormalDelay returns normally distributed delay, negative samples are truncated to 0
+func NormalDelay(mean time.Duration, stddev time.Duration, random *rand.Rand) Delay {
+	return func() time.Duration {
+		delay := time.Duration(random.NormFloat64()*float64(stddev)) + mean
+		if delay < 0 {
+			return 0
+		}
+		return delay
+	}
+}
+
+// ParetoDelay returns pareto distributed delay, scale is the minimum delay and
+// smaller shape makes longer tail
+func ParetoDelay(scale time.Duration, shape float64, random *rand.Rand) Delay {
+	return func() time.Duration {
+		// 1 - Float64() is in (0, 1], so the sample never diverges
+		return time.Duration(float64(scale) / math.Pow(1-random.Float64(), 1/shape))
+	}
+}
+
+// New constructs delay generator drawing samples from random
+func (spec DelaySpec) New(random *rand.Rand) (Delay, error) {
+	switch spec.Distribution {
+	case Constant, "":
+		return ConstantDelay(spec.Delay), nil
+	case Uniform:
+		if spec.Max < spec.Min {
+			return nil, errors.New("Maximum delay is less than minimum delay")
+		}
+		return UniformDelay(spec.Min, spec.Max, random), nil
+	case Normal:
+		return NormalDelay(spec.Mean, spec.StdDev, random), nil
+	case Pareto:
+		if spec.Shape <= 0 {
+			return nil, errors.New("Shape of pareto distribution should be positive")
+		}
+		return ParetoDelay(spec.Scale, spec.Shape, random), nil
+	}
+
+	return nil, errors.New("Unknown delay distribution: " + spec.Distribution)
+}
+
+// ParseDelaySpec parses delay description such as "constant:100ms", "uniform:50ms:150ms",
+// "normal:100ms:20ms" or "pareto:50ms:1.5"
+func ParseDelaySpec(s string) (DelaySpec, error) {
+	fields := strings.Split(s, ":")
+	spec := DelaySpec{Distribution: fields[0]}
+	args := fields[1:]
+
+	var err error
+	switch spec.Distribution {
+	case Constant:
+		if len(args) != 1 {
+			return spec, errors.New("Usage: constant:<delay>")
+		}
+		spec.Delay, err = time.ParseDuration(args[0])
+	case Uniform:
+		if len(args) != 2 {
+			return spec, errors.New("Usage: uniform:<min>:<max>")
+		}
+		if spec.Min, err = time.ParseDuration(args[0]); err == nil {
+			spec.Max, err = time.ParseDuration(args[1])
+		}
+	case Normal:
+		if len(args) != 2 {
+			return spec, errors.New("Usage: normal:<mean>:<stddev>")
+		}
+		if spec.Mean, err = time.ParseDuration(args[0]); err == nil {
+			spec.StdDev, err = time.ParseDuration(args[1])
+		}
+	case Pareto:
+		if len(args) != 2 {
+			return spec, errors.New("Usage: pareto:<scale>:<shape>")
+		}
+		if spec.Scale, err = time.ParseDuration(args[0]); err == nil {
+			spec.Shape, err = strconv.ParseFloat(args[1], 64)
+		}
+	default:
+		return spec, errors.New("Unknown delay distribution: " + spec.Distribution)
+	}
+
+	return spec, err
+}
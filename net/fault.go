@@ -0,0 +1,77 @@
+package net
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// DefaultReorderDelay is maximum extra delay of reordered load unless it is given
+const DefaultReorderDelay = 100 * time.Millisecond
+
+// FaultSpec describes faults injected to loads of a link
+type FaultSpec struct {
+	Drop      float64 // Probability of dropping a load
+	Duplicate float64 // Probability of delivering a load twice
+	Reorder   float64 // Probability of delaying a load behind loads written later
+
+	// Maximum extra delay of reordered load
+	ReorderDelay time.Duration
+}
+
+// Validate checks probabilities of the spec
+func (spec FaultSpec) Validate() error {
+	for _, p := range []float64{spec.Drop, spec.Duplicate, spec.Reorder} {
+		if p < 0 || p > 1 {
+			return errors.New("Fault probability must be in [0, 1]")
+		}
+	}
+	if spec.ReorderDelay < 0 {
+		return errors.New("Negative reorder delay")
+	}
+	return nil
+}
+
+// Classifier returns kind of the load choosing its faults
+type Classifier func(l Load) string
+
+// injection is faults drawn for a load
+type injection struct {
+	drop      bool
+	duplicate bool
+	// Extra delay of reordered load, zero keeps order
+	reorder time.Duration
+}
+
+// injector draws faults of loads written to a link by kind of the loads,
+// spec of empty kind applies to loads of other kinds
+type injector struct {
+	classify Classifier
+	specs    map[string]FaultSpec
+	random   *rand.Rand
+}
+
+func (i *injector) inject(l Load) injection {
+	spec, exist := FaultSpec{}, false
+	if i.classify != nil {
+		spec, exist = i.specs[i.classify(l)]
+	}
+	if !exist {
+		if spec, exist = i.specs[""]; !exist {
+			return injection{}
+		}
+	}
+
+	in := injection{
+		drop:      spec.Drop > 0 && i.random.Float64() < spec.Drop,
+		duplicate: spec.Duplicate > 0 && i.random.Float64() < spec.Duplicate,
+	}
+	if spec.Reorder > 0 && i.random.Float64() < spec.Reorder {
+		delay := spec.ReorderDelay
+		if delay == 0 {
+			delay = DefaultReorderDelay
+		}
+		in.reorder = time.Duration(i.random.Int63n(int64(delay))) + 1
+	}
+	return in
+}
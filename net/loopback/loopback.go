@@ -1,11 +1,12 @@
 package loopback
 
 import (
+	"github.com/hdac-io/simulator/clock"
 	"github.com/hdac-io/simulator/net"
 )
 
 // Connect construct connection to destination
-func Connect(destination net.Address) net.Connection {
+func Connect(destination net.Address, clock clock.Clock) net.Connection {
 	// FIXME: should confirm destination
-	return newConnection()
+	return newConnection(clock)
 }
@@ -1,31 +1,59 @@
 package loopback
 
-import "github.com/hdac-io/simulator/net"
+import (
+	"sync"
+
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/net"
+)
 
 type connection struct {
+	sync.Mutex
 	address  string
 	loopback chan net.Load
+	clock    clock.Clock
+	closed   bool
 }
 
-func newConnection() connection {
-	return connection{
+func newConnection(clock clock.Clock) *connection {
+	return &connection{
 		// FIXME: type
 		address:  "loopback",
 		loopback: make(chan net.Load, 16),
+		clock:    clock,
 	}
 }
 
 // Write load to loopback network
-func (c connection) Write(l net.Load) {
-	c.loopback <- l
+func (c *connection) Write(l net.Load) {
+	c.Lock()
+	closed := c.closed
+	c.Unlock()
+	if closed {
+		return
+	}
+	c.clock.Send(c.loopback, l)
 }
 
 // Read load from loopback network
-func (c connection) Read() net.Load {
-	return <-c.loopback
+func (c *connection) Read() net.Load {
+	return c.clock.Receive(c.loopback)
 }
 
 // GetAddress retrieves network address
-func (c connection) GetAddress() net.Address {
+func (c *connection) GetAddress() net.Address {
 	return c.address
 }
+
+// Err returns nil since loopback never fails
+func (c *connection) Err() error {
+	return nil
+}
+
+// Close drops later loads and wakes the reader up
+func (c *connection) Close() {
+	c.Lock()
+	c.closed = true
+	c.Unlock()
+	c.clock.Send(c.loopback, nil)
+}
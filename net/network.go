@@ -0,0 +1,199 @@
+package net
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hdac-io/simulator/clock"
+)
+
+// networkSize is capacity of loads delivered to a link but not read yet,
+// delivery waits while it is full. Loads in flight are not bounded so that
+// Write never blocks.
+const networkSize = 1024
+
+type delivery struct {
+	load Load
+	at   time.Time
+}
+
+// Network represents simulated one-way link which delays every load.
+// Loads are delivered in written order like a TCP stream unless they are
+// reordered by injected faults.
+type Network struct {
+	sync.Mutex
+	address  Address
+	getDelay Delay
+	lost     func() bool
+	inject   func(l Load) injection
+	network  chan Load
+	clock    clock.Clock
+
+	// Loads are serialized on uplink of the sender and downlink of the
+	// receiver if size is given
+	size     Sizer
+	uplink   *pipe
+	downlink *pipe
+
+	// Loads are dropped or held while the link is cut by partition
+	cut  bool
+	hold bool
+	held []Load
+
+	// In-flight loads
+	pending []delivery
+	last    time.Time
+	cond    clock.Cond
+	closed  bool
+}
+
+// NewNetwork constructs simulated link without delay
+func NewNetwork() *Network {
+	return newNetwork("simulated", ConstantDelay(0), clock.NewReal())
+}
+
+func newNetwork(address Address, delay Delay, clock clock.Clock) *Network {
+	n := &Network{
+		address:  address,
+		getDelay: delay,
+		network:  make(chan Load, networkSize),
+		clock:    clock,
+		pending:  make([]delivery, 0),
+	}
+	n.cond = clock.NewCond(n)
+
+	// Start delivering loop
+	clock.Go(n.deliverLoop)
+
+	return n
+}
+
+// Write load to simulated link, it does not block
+func (n *Network) Write(l Load) {
+	n.Lock()
+	defer n.Unlock()
+	if n.closed || n.cutOff(l) {
+		return
+	}
+	if n.lost != nil && n.lost() {
+		return
+	}
+	var in injection
+	if n.inject != nil {
+		in = n.inject(l)
+	}
+	if in.drop {
+		return
+	}
+	if in.reorder > 0 {
+		// Loads written meanwhile overtake the reordered load
+		n.clock.Go(func() {
+			n.clock.Sleep(in.reorder)
+			n.Lock()
+			defer n.Unlock()
+			// Partition may be started meanwhile
+			if !n.closed && !n.cutOff(l) {
+				n.send(l)
+			}
+		})
+	} else {
+		n.send(l)
+	}
+	if in.duplicate {
+		n.send(l)
+	}
+}
+
+// cutOff drops or holds the load if the link is cut by partition and
+// reports whether it is cut, it must be called with the link locked
+func (n *Network) cutOff(l Load) bool {
+	if !n.cut {
+		return false
+	}
+	if n.hold {
+		n.held = append(n.held, l)
+	}
+	return true
+}
+
+// send schedules delivery of the load, it is transmitted on uplink,
+// propagated with delay and received on downlink
+func (n *Network) send(l Load) {
+	now := n.clock.Now()
+	delay := n.getDelay()
+	at := now.Add(delay)
+	if n.size != nil {
+		size := n.size(l)
+		at = n.downlink.transmit(n.uplink.transmit(now, size).Add(delay), size)
+	}
+	// Keep order of loads
+	if at.Before(n.last) {
+		at = n.last
+	}
+	n.last = at
+	n.pending = append(n.pending, delivery{load: l, at: at})
+	n.cond.Signal()
+}
+
+// setCut cuts or restores the link, held loads are sent when it is restored
+func (n *Network) setCut(cut bool, hold bool) {
+	n.Lock()
+	defer n.Unlock()
+	n.cut, n.hold = cut, hold
+	if cut {
+		return
+	}
+	if !n.closed {
+		for _, l := range n.held {
+			n.send(l)
+		}
+	}
+	n.held = nil
+}
+
+// isClosed reports whether the link is closed
+func (n *Network) isClosed() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.closed
+}
+
+// Close stops accepting loads, Read returns nil after in-flight loads are delivered
+func (n *Network) Close() {
+	n.Lock()
+	n.closed = true
+	n.held = nil
+	n.cond.Signal()
+	n.Unlock()
+}
+
+// Read load from simulated link
+func (n *Network) Read() Load {
+	return n.clock.Receive(n.network)
+}
+
+// GetAddress retrieves network address
+func (n *Network) GetAddress() Address {
+	return n.address
+}
+
+func (n *Network) deliverLoop() {
+	for {
+		n.Lock()
+		for len(n.pending) == 0 && !n.closed {
+			n.cond.Wait()
+		}
+		if len(n.pending) == 0 {
+			n.Unlock()
+			// Wake the reader up
+			n.clock.Send(n.network, nil)
+			return
+		}
+		d := n.pending[0]
+		n.pending = n.pending[1:]
+		n.Unlock()
+
+		n.clock.Sleep(d.at.Sub(n.clock.Now()))
+		n.clock.Send(n.network, d.load)
+	}
+}
@@ -0,0 +1,132 @@
+package net
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RTTMatrix is round-trip time between regions
+type RTTMatrix struct {
+	Regions []string
+	rtt     map[string]map[string]time.Duration
+}
+
+// ParseRTTMatrix reads matrix written as CSV, the header row and the first
+// column name regions and cells are round-trip times in milliseconds or
+// durations like "150ms". Empty cell takes round-trip time of the reverse
+// direction.
+//
+//	region,us-east,eu-west
+//	us-east,2,80
+//	eu-west,,2
+func ParseRTTMatrix(r io.Reader) (*RTTMatrix, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("RTT matrix without regions")
+	}
+
+	m := &RTTMatrix{
+		Regions: make([]string, 0, len(records)-1),
+		rtt:     make(map[string]map[string]time.Duration),
+	}
+	header := records[0][1:]
+	for _, region := range header {
+		region = strings.TrimSpace(region)
+		if _, exist := m.rtt[region]; exist || region == "" {
+			return nil, errors.New("Invalid region in RTT matrix: " + region)
+		}
+		m.Regions = append(m.Regions, region)
+		m.rtt[region] = make(map[string]time.Duration)
+	}
+	if len(records)-1 != len(header) {
+		return nil, errors.New("RTT matrix is not square")
+	}
+
+	for i, record := range records[1:] {
+		if strings.TrimSpace(record[0]) != m.Regions[i] {
+			return nil, errors.New("Regions of rows and columns differ in RTT matrix")
+		}
+		for j, cell := range record[1:] {
+			cell = strings.TrimSpace(cell)
+			if cell == "" {
+				continue
+			}
+			rtt, err := parseRTT(cell)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid RTT from %s to %s: %v", m.Regions[i], m.Regions[j], err)
+			}
+			m.rtt[m.Regions[i]][m.Regions[j]] = rtt
+		}
+	}
+
+	for _, from := range m.Regions {
+		for _, to := range m.Regions {
+			if _, exist := m.rtt[from][to]; exist {
+				continue
+			}
+			rtt, exist := m.rtt[to][from]
+			if !exist {
+				return nil, fmt.Errorf("Missing RTT from %s to %s", from, to)
+			}
+			m.rtt[from][to] = rtt
+		}
+	}
+	return m, nil
+}
+
+// LoadRTTMatrix reads CSV file of RTT matrix
+func LoadRTTMatrix(path string) (*RTTMatrix, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRTTMatrix(f)
+}
+
+func parseRTT(s string) (time.Duration, error) {
+	var rtt time.Duration
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		rtt = time.Duration(ms * float64(time.Millisecond))
+	} else if rtt, err = time.ParseDuration(s); err != nil {
+		return 0, err
+	}
+	if rtt < 0 {
+		return 0, errors.New("Negative RTT")
+	}
+	return rtt, nil
+}
+
+// HasRegion reports whether the region is in the matrix
+func (m *RTTMatrix) HasRegion(region string) bool {
+	_, exist := m.rtt[region]
+	return exist
+}
+
+// RTT returns round-trip time between the regions, false if either is unknown
+func (m *RTTMatrix) RTT(from string, to string) (time.Duration, bool) {
+	rtt, exist := m.rtt[from][to]
+	return rtt, exist
+}
+
+// delay returns delay of one-way link between the regions, it is half of
+// the round-trip time varied normally by jitter ratio
+func (m *RTTMatrix) delay(from string, to string, jitter float64) (DelaySpec, bool) {
+	rtt, exist := m.RTT(from, to)
+	if !exist {
+		return DelaySpec{}, false
+	}
+	if jitter <= 0 {
+		return DelaySpec{Distribution: Constant, Delay: rtt / 2}, true
+	}
+	return DelaySpec{Distribution: Normal, Mean: rtt / 2, StdDev: time.Duration(float64(rtt/2) * jitter)}, true
+}
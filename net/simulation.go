@@ -0,0 +1,391 @@
+package net
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+
+	"github.com/hdac-io/simulator/clock"
+)
+
+type link struct {
+	from Address
+	to   Address
+}
+
+// linkNetwork is simulated link of a connection
+type linkNetwork struct {
+	link
+	network *Network
+}
+
+// Simulation represents in-process network connecting nodes with simulated links
+type Simulation struct {
+	sync.Mutex
+	clock     clock.Clock
+	seed      int64
+	delay     DelaySpec
+	links     map[link]DelaySpec
+	loss      float64
+	losses    map[link]float64
+	endpoints map[Address]*endpoint
+
+	// Faults by kind of loads of every link, and of each link
+	classify   Classifier
+	faults     map[string]FaultSpec
+	linkFaults map[link]map[string]FaultSpec
+
+	// Links between regions are delayed by RTT matrix
+	rtt     *RTTMatrix
+	jitter  float64
+	regions map[Address]string
+
+	// Bandwidth of every node unless configured for the node
+	size       Sizer
+	uplink     Bandwidth
+	downlink   Bandwidth
+	bandwidths map[Address][2]Bandwidth
+	uplinks    map[Address]*pipe
+	downlinks  map[Address]*pipe
+
+	// Links of connections in created order for partitioning
+	networks []linkNetwork
+	// Group of each address while partitioned, nil if not partitioned
+	groups map[Address]int
+	hold   bool
+}
+
+// NewSimulation constructs simulated network driven by clock,
+// every link uses delay unless configured by SetLinkDelay or SetRTTMatrix
+func NewSimulation(clock clock.Clock, seed int64, delay DelaySpec) *Simulation {
+	return &Simulation{
+		clock:      clock,
+		seed:       seed,
+		delay:      delay,
+		links:      make(map[link]DelaySpec),
+		losses:     make(map[link]float64),
+		faults:     make(map[string]FaultSpec),
+		linkFaults: make(map[link]map[string]FaultSpec),
+		endpoints:  make(map[Address]*endpoint),
+		regions:    make(map[Address]string),
+		bandwidths: make(map[Address][2]Bandwidth),
+		uplinks:    make(map[Address]*pipe),
+		downlinks:  make(map[Address]*pipe),
+	}
+}
+
+// SetLinkDelay configures delay of the one-way link from -> to
+func (s *Simulation) SetLinkDelay(from Address, to Address, delay DelaySpec) {
+	s.Lock()
+	defer s.Unlock()
+	s.links[link{from: from, to: to}] = delay
+}
+
+// SetLoss configures probability of dropping a load on every link
+func (s *Simulation) SetLoss(loss float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.loss = loss
+}
+
+// SetLinkLoss configures probability of dropping a load on the one-way link from -> to
+func (s *Simulation) SetLinkLoss(from Address, to Address, loss float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.losses[link{from: from, to: to}] = loss
+}
+
+// SetRTTMatrix configures delay of links between nodes placed in regions,
+// one-way delay is half of the round-trip time varied normally by jitter ratio
+func (s *Simulation) SetRTTMatrix(rtt *RTTMatrix, jitter float64) {
+	s.Lock()
+	defer s.Unlock()
+	s.rtt, s.jitter = rtt, jitter
+}
+
+// SetRegion places the node in the region of RTT matrix
+func (s *Simulation) SetRegion(address Address, region string) {
+	s.Lock()
+	defer s.Unlock()
+	s.regions[address] = region
+}
+
+// linkDelay returns delay of the link configured by SetLinkDelay, by regions
+// of the nodes or for every link in order
+func (s *Simulation) linkDelay(from Address, to Address) DelaySpec {
+	if spec, exist := s.links[link{from: from, to: to}]; exist {
+		return spec
+	}
+	if s.rtt != nil {
+		if spec, exist := s.rtt.delay(s.regions[from], s.regions[to], s.jitter); exist {
+			return spec
+		}
+	}
+	return s.delay
+}
+
+// SetClassifier configures classifier telling kinds of loads for faults
+func (s *Simulation) SetClassifier(classify Classifier) {
+	s.Lock()
+	defer s.Unlock()
+	s.classify = classify
+}
+
+// SetFaults configures faults injected to loads of the kind on every link,
+// empty kind configures loads of kinds without their faults
+func (s *Simulation) SetFaults(kind string, spec FaultSpec) {
+	s.Lock()
+	defer s.Unlock()
+	s.faults[kind] = spec
+}
+
+// SetLinkFaults configures faults injected to loads of the kind on the
+// one-way link from -> to, they override faults of every link
+func (s *Simulation) SetLinkFaults(from Address, to Address, kind string, spec FaultSpec) {
+	s.Lock()
+	defer s.Unlock()
+	l := link{from: from, to: to}
+	if _, exist := s.linkFaults[l]; !exist {
+		s.linkFaults[l] = make(map[string]FaultSpec)
+	}
+	s.linkFaults[l][kind] = spec
+}
+
+// SetSizer configures sizer telling sizes of loads for bandwidth
+func (s *Simulation) SetSizer(size Sizer) {
+	s.Lock()
+	defer s.Unlock()
+	s.size = size
+}
+
+// SetBandwidth configures uplink and downlink bandwidth of every node,
+// zero is unlimited
+func (s *Simulation) SetBandwidth(uplink Bandwidth, downlink Bandwidth) {
+	s.Lock()
+	defer s.Unlock()
+	s.uplink, s.downlink = uplink, downlink
+}
+
+// SetNodeBandwidth configures uplink and downlink bandwidth of the node
+func (s *Simulation) SetNodeBandwidth(address Address, uplink Bandwidth, downlink Bandwidth) {
+	s.Lock()
+	defer s.Unlock()
+	s.bandwidths[address] = [2]Bandwidth{uplink, downlink}
+}
+
+// pipes returns uplink and downlink of the node shared by its links, nil if unlimited
+func (s *Simulation) pipes(address Address) (*pipe, *pipe) {
+	bandwidth, exist := s.bandwidths[address]
+	if !exist {
+		bandwidth = [2]Bandwidth{s.uplink, s.downlink}
+	}
+	get := func(pipes map[Address]*pipe, b Bandwidth) *pipe {
+		if b <= 0 {
+			return nil
+		}
+		if _, exist := pipes[address]; !exist {
+			pipes[address] = &pipe{bandwidth: b}
+		}
+		return pipes[address]
+	}
+	return get(s.uplinks, bandwidth[0]), get(s.downlinks, bandwidth[1])
+}
+
+// Partition splits nodes into groups, addresses not in any group form
+// another group. Loads between groups are dropped, or held until Heal if
+// hold is set. Loads already in flight are delivered.
+func (s *Simulation) Partition(groups [][]Address, hold bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.groups = make(map[Address]int)
+	for i, group := range groups {
+		for _, address := range group {
+			s.groups[address] = i
+		}
+	}
+	s.hold = hold
+	s.updateLinks()
+}
+
+// Heal removes partition, held loads are delivered
+func (s *Simulation) Heal() {
+	s.Lock()
+	defer s.Unlock()
+	s.groups = nil
+	s.updateLinks()
+}
+
+// isCut reports whether the link crosses groups of partition
+func (s *Simulation) isCut(l link) bool {
+	if s.groups == nil {
+		return false
+	}
+	group := func(address Address) int {
+		if g, exist := s.groups[address]; exist {
+			return g
+		}
+		return -1
+	}
+	return group(l.from) != group(l.to)
+}
+
+// updateLinks applies partition to links and forgets closed links
+func (s *Simulation) updateLinks() {
+	networks := make([]linkNetwork, 0, len(s.networks))
+	for _, n := range s.networks {
+		if n.network.isClosed() {
+			continue
+		}
+		n.network.setCut(s.isCut(n.link), s.hold)
+		networks = append(networks, n)
+	}
+	s.networks = networks
+}
+
+// Listen returns transport of the node having address
+func (s *Simulation) Listen(address Address) Transport {
+	s.Lock()
+	defer s.Unlock()
+	if _, exist := s.endpoints[address]; exist {
+		panic("Address is already in use !")
+	}
+	e := &endpoint{
+		simulation: s,
+		address:    address,
+		accept:     make(chan Connection, networkSize),
+	}
+	s.endpoints[address] = e
+
+	return e
+}
+
+// newLink constructs one-way link, random source of the link depends only on
+// the seed and addresses so that the delays are reproducible
+func (s *Simulation) newLink(from Address, to Address) *Network {
+	spec := s.linkDelay(from, to)
+
+	h := fnv.New64a()
+	fmt.Fprint(h, from, "->", to)
+	random := rand.New(rand.NewSource(s.seed ^ int64(h.Sum64())))
+
+	delay, err := spec.New(random)
+	if err != nil {
+		panic(err)
+	}
+
+	n := newNetwork(to, delay, s.clock)
+	if s.size != nil {
+		n.uplink, _ = s.pipes(from)
+		_, n.downlink = s.pipes(to)
+		if n.uplink != nil || n.downlink != nil {
+			n.size = s.size
+		}
+	}
+	n.cut, n.hold = s.isCut(link{from: from, to: to}), s.hold
+	s.networks = append(s.networks, linkNetwork{link: link{from: from, to: to}, network: n})
+
+	loss, exist := s.losses[link{from: from, to: to}]
+	if !exist {
+		loss = s.loss
+	}
+	if loss > 0 {
+		n.lost = func() bool {
+			return random.Float64() < loss
+		}
+	}
+
+	specs := make(map[string]FaultSpec)
+	for kind, spec := range s.faults {
+		specs[kind] = spec
+	}
+	for kind, spec := range s.linkFaults[link{from: from, to: to}] {
+		specs[kind] = spec
+	}
+	if len(specs) > 0 {
+		// Faults are drawn apart from delays and losses
+		i := &injector{
+			classify: s.classify,
+			specs:    specs,
+			random:   rand.New(rand.NewSource(s.seed ^ int64(h.Sum64()) ^ 1)),
+		}
+		n.inject = i.inject
+	}
+
+	return n
+}
+
+type endpoint struct {
+	simulation *Simulation
+	address    Address
+	accept     chan Connection
+}
+
+// Accept waits connection request
+func (e *endpoint) Accept() Connection {
+	connection, _ := e.simulation.clock.Receive(e.accept).(Connection)
+	return connection
+}
+
+// Close stops listening, the address can be listened again
+func (e *endpoint) Close() {
+	s := e.simulation
+	s.Lock()
+	if s.endpoints[e.address] == e {
+		delete(s.endpoints, e.address)
+	}
+	s.Unlock()
+
+	s.clock.Send(e.accept, nil)
+}
+
+// Connect construct connection to destination
+func (e *endpoint) Connect(destination Address) Connection {
+	s := e.simulation
+	s.Lock()
+	remote, exist := s.endpoints[destination]
+	if !exist {
+		s.Unlock()
+		return nil
+	}
+	outbound := s.newLink(e.address, destination)
+	inbound := s.newLink(destination, e.address)
+	s.Unlock()
+
+	s.clock.Send(remote.accept, &simulatedConnection{address: e.address, inbound: outbound, outbound: inbound})
+
+	return &simulatedConnection{address: destination, inbound: inbound, outbound: outbound}
+}
+
+// simulatedConnection is a pair of one-way links
+type simulatedConnection struct {
+	address  Address
+	inbound  *Network
+	outbound *Network
+}
+
+// Write load to the remote node
+func (c *simulatedConnection) Write(l Load) {
+	c.outbound.Write(l)
+}
+
+// Read load from the remote node
+func (c *simulatedConnection) Read() Load {
+	return c.inbound.Read()
+}
+
+// GetAddress retrieves address of the remote node
+func (c *simulatedConnection) GetAddress() Address {
+	return c.address
+}
+
+// Err returns nil since simulated connections are closed only by either side
+func (c *simulatedConnection) Err() error {
+	return nil
+}
+
+// Close closes links of both directions
+func (c *simulatedConnection) Close() {
+	c.inbound.Close()
+	c.outbound.Close()
+}
@@ -0,0 +1,292 @@
+package net
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/clock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulationConnect(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	require.Equal(t, "b", outbound.GetAddress())
+	require.Equal(t, "a", inbound.GetAddress())
+
+	outbound.Write(1)
+	require.Equal(t, 1, inbound.Read())
+	inbound.Write(2)
+	require.Equal(t, 2, outbound.Read())
+}
+
+func TestSimulationKeepsOrder(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Uniform, Min: 0, Max: 20 * time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	for i := 0; i < 100; i++ {
+		outbound.Write(i)
+	}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, i, inbound.Read())
+	}
+}
+
+func TestSimulationClose(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	outbound.Write(1)
+	outbound.Close()
+	// In-flight load is delivered before closing
+	require.Equal(t, 1, inbound.Read())
+	require.Nil(t, inbound.Read())
+	require.Nil(t, outbound.Read())
+
+	// Closed address is not reachable until listened again
+	b.Close()
+	require.Nil(t, b.Accept())
+	require.Nil(t, a.Connect("b"))
+	b = sim.Listen("b")
+	require.NotNil(t, a.Connect("b"))
+}
+
+func TestDelayDistributions(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	uniform := UniformDelay(10*time.Millisecond, 20*time.Millisecond, random)
+	pareto := ParetoDelay(10*time.Millisecond, 1.5, random)
+	normal := NormalDelay(time.Millisecond, 10*time.Millisecond, random)
+	for i := 0; i < 1000; i++ {
+		d := uniform()
+		require.True(t, d >= 10*time.Millisecond && d < 20*time.Millisecond)
+		require.True(t, pareto() >= 10*time.Millisecond)
+		require.True(t, normal() >= 0)
+	}
+}
+
+func TestParseDelaySpec(t *testing.T) {
+	spec, err := ParseDelaySpec("pareto:50ms:1.5")
+	require.Nil(t, err)
+	require.Equal(t, DelaySpec{Distribution: Pareto, Scale: 50 * time.Millisecond, Shape: 1.5}, spec)
+
+	spec, err = ParseDelaySpec("uniform:50ms:150ms")
+	require.Nil(t, err)
+	require.Equal(t, DelaySpec{Distribution: Uniform, Min: 50 * time.Millisecond, Max: 150 * time.Millisecond}, spec)
+
+	_, err = ParseDelaySpec("normal:50ms")
+	require.NotNil(t, err)
+	_, err = ParseDelaySpec("exponential:50ms")
+	require.NotNil(t, err)
+}
+
+func TestSimulationLoss(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant})
+	sim.SetLinkLoss("a", "b", 1)
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+
+	// Every load from a to b is dropped
+	outbound.Write(1)
+	inbound.Write(2)
+	require.Equal(t, 2, outbound.Read())
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 0, len(inbound.(*simulatedConnection).inbound.network))
+}
+
+func TestSimulationPartition(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+	c := sim.Listen("c")
+
+	ab := a.Connect("b")
+	ba := b.Accept()
+	ac := a.Connect("c")
+	ca := c.Accept()
+
+	// Loads across groups are dropped, c is in the group of the rest
+	sim.Partition([][]Address{{"a", "c"}}, false)
+	ab.Write(1)
+	ac.Write(2)
+	require.Equal(t, 2, ca.Read())
+
+	// Held loads are delivered in order after healing
+	sim.Partition([][]Address{{"a"}, {"b"}}, true)
+	ab.Write(3)
+	ba.Write(4)
+	sim.Heal()
+	ab.Write(5)
+	require.Equal(t, 3, ba.Read())
+	require.Equal(t, 5, ba.Read())
+	require.Equal(t, 4, ab.Read())
+}
+
+func TestSimulationFaults(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: time.Millisecond})
+	sim.SetClassifier(func(l Load) string {
+		switch {
+		case l.(int) < 0:
+			return "dropped"
+		case l.(int) == 0:
+			return "late"
+		}
+		return ""
+	})
+	sim.SetFaults("dropped", FaultSpec{Drop: 1})
+	sim.SetLinkFaults("a", "b", "", FaultSpec{Duplicate: 1})
+	sim.SetLinkFaults("b", "a", "late", FaultSpec{Reorder: 1, ReorderDelay: 50 * time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	// Loads of dropped kind never arrive, the others arrive twice
+	outbound.Write(-1)
+	outbound.Write(1)
+	outbound.Write(2)
+	for _, expected := range []int{1, 1, 2, 2} {
+		require.Equal(t, expected, inbound.Read())
+	}
+
+	// Reordered load is overtaken by loads written later
+	inbound.Write(0)
+	inbound.Write(1)
+	inbound.Write(-1)
+	for _, expected := range []int{1, 0} {
+		require.Equal(t, expected, outbound.Read())
+	}
+}
+
+func TestSimulationReorderPartition(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: time.Millisecond})
+	sim.SetClassifier(func(l Load) string {
+		if l.(int) == 0 {
+			return "late"
+		}
+		return ""
+	})
+	sim.SetLinkFaults("a", "b", "late", FaultSpec{Reorder: 1, ReorderDelay: 50 * time.Millisecond})
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	// Reordered load does not cross partition started meanwhile
+	outbound.Write(0)
+	sim.Partition([][]Address{{"a"}, {"b"}}, false)
+	time.Sleep(100 * time.Millisecond)
+	sim.Heal()
+	outbound.Write(1)
+	require.Equal(t, 1, inbound.Read())
+
+	// It is held until healing if partition holds loads
+	outbound.Write(0)
+	sim.Partition([][]Address{{"a"}, {"b"}}, true)
+	time.Sleep(100 * time.Millisecond)
+	outbound.Write(2)
+	sim.Heal()
+	require.Equal(t, 0, inbound.Read())
+	require.Equal(t, 2, inbound.Read())
+}
+
+func TestSimulationBandwidth(t *testing.T) {
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: 0})
+	sim.SetSizer(func(l Load) int { return l.(int) })
+	// 1000 bytes take 100ms on uplink of a
+	sim.SetNodeBandwidth("a", 80*1000, 0)
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+	c := sim.Listen("c")
+
+	aToB := a.Connect("b")
+	bFromA := b.Accept()
+	aToC := a.Connect("c")
+	cFromA := c.Accept()
+
+	// Loads to b and c queue on the shared uplink
+	start := time.Now()
+	aToB.Write(1000)
+	aToC.Write(1000)
+	require.Equal(t, 1000, bFromA.Read())
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+	require.Equal(t, 1000, cFromA.Read())
+	require.True(t, time.Since(start) >= 200*time.Millisecond)
+
+	// Loads from b are not limited
+	start = time.Now()
+	bFromA.Write(1000)
+	require.Equal(t, 1000, aToB.Read())
+	require.True(t, time.Since(start) < 100*time.Millisecond)
+}
+
+func TestParseBandwidth(t *testing.T) {
+	b, err := ParseBandwidth("1.5Mbps")
+	require.NoError(t, err)
+	require.Equal(t, Bandwidth(1500*1000), b)
+	require.Equal(t, "1500kbps", b.String())
+	require.Equal(t, time.Second, b.transmitTime(1500*1000/8))
+	for _, s := range []string{"", "100", "100MB", "-1Mbps", "0Gbps"} {
+		_, err := ParseBandwidth(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestParseRTTMatrix(t *testing.T) {
+	m, err := ParseRTTMatrix(strings.NewReader("region,a,b\na,2,80\nb,,150ms\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, m.Regions)
+	// Empty cell takes the reverse direction
+	rtt, exist := m.RTT("b", "a")
+	require.True(t, exist)
+	require.Equal(t, 80*time.Millisecond, rtt)
+	rtt, _ = m.RTT("b", "b")
+	require.Equal(t, 150*time.Millisecond, rtt)
+	_, exist = m.RTT("a", "c")
+	require.False(t, exist)
+
+	for _, data := range []string{
+		"region,a\n",
+		"region,a,b\na,2,80\n",
+		"region,a,b\na,2,\nb,,2\n",
+		"region,a,b\nb,2,80\na,80,2\n",
+		"region,a\na,-1\n",
+	} {
+		_, err := ParseRTTMatrix(strings.NewReader(data))
+		require.Error(t, err, data)
+	}
+}
+
+func TestSimulationRegions(t *testing.T) {
+	m, err := ParseRTTMatrix(strings.NewReader("region,near,far\nnear,0,200\nfar,,0\n"))
+	require.NoError(t, err)
+	sim := NewSimulation(clock.NewReal(), 0, DelaySpec{Distribution: Constant, Delay: 0})
+	sim.SetRTTMatrix(m, 0)
+	sim.SetRegion("a", "near")
+	sim.SetRegion("b", "far")
+	a := sim.Listen("a")
+	b := sim.Listen("b")
+
+	outbound := a.Connect("b")
+	inbound := b.Accept()
+	// One-way delay is half of the round-trip time
+	start := time.Now()
+	outbound.Write(1)
+	require.Equal(t, 1, inbound.Read())
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+}
@@ -1,43 +1,60 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"net"
 	"strconv"
+	"time"
 
 	mynet "github.com/hdac-io/simulator/net"
 )
 
+// dialTimeout limits time connecting to a peer
+const dialTimeout = 3 * time.Second
+
 // Network represents TCP network
 type Network struct {
 	address  *net.TCPAddr
 	listener net.Listener
+	// Connections are encrypted by TLS unless it is nil
+	tlsConfig *tls.Config
 }
 
-// New construct Network struct
-func New(address mynet.Address) Network {
+// New listens on port of the address, connections are encrypted with
+// tlsConfig unless it is nil
+func New(address mynet.Address, tlsConfig *tls.Config) (Network, error) {
 	addr, err := net.ResolveTCPAddr("tcp", address.(string))
 	if err != nil {
-		panic(err)
+		return Network{}, err
 	}
 	network := Network{
-		address: addr,
+		address:   addr,
+		tlsConfig: tlsConfig,
 	}
-	// FIXME: error handling
 	listener, err := net.Listen("tcp", ":"+strconv.Itoa(addr.Port))
 	if err != nil {
-		panic(err)
+		return Network{}, err
 	}
 	network.listener = listener
 
-	return network
+	return network, nil
 }
 
 // Accept waits connection request
 func (n Network) Accept() mynet.Connection {
-	// FIXME: error handling
 	conn, err := n.listener.Accept()
-	if err != nil {
-		panic(err)
+	for err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Temporary() {
+			// Listener is closed
+			return nil
+		}
+		// Retry after temporary error such as running out of file descriptors
+		time.Sleep(100 * time.Millisecond)
+		conn, err = n.listener.Accept()
+	}
+	if n.tlsConfig != nil {
+		// Handshake is done by the first read or write
+		conn = tls.Server(conn, n.tlsConfig)
 	}
 
 	remoteAddress := Network{
@@ -47,13 +64,27 @@ func (n Network) Accept() mynet.Connection {
 	return newConnection(remoteAddress, conn)
 }
 
-// Connect construct connection to destination
-func Connect(destination mynet.Address) mynet.Connection {
-	conn, err := net.Dial("tcp", destination.(string))
-	// FIXME: error handling
+// Connect construct connection to destination, it is encrypted with tlsConfig
+// unless it is nil
+func Connect(destination mynet.Address, tlsConfig *tls.Config) mynet.Connection {
+	conn, err := net.DialTimeout("tcp", destination.(string), dialTimeout)
 	if err != nil {
-		panic(err)
+		// Destination is not listening or unreachable
+		return nil
+	}
+	if tlsConfig != nil {
+		conn = tls.Client(conn, tlsConfig)
 	}
 
 	return newConnection(destination, conn)
 }
+
+// Connect construct connection to destination
+func (n Network) Connect(destination mynet.Address) mynet.Connection {
+	return Connect(destination, n.tlsConfig)
+}
+
+// Close stops listening
+func (n Network) Close() {
+	n.listener.Close()
+}
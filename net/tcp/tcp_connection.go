@@ -1,65 +1,146 @@
 package tcp
 
 import (
-	"encoding/gob"
-	"io"
+	"bufio"
+	"errors"
 	"net"
+	"sync"
+	"time"
 
 	mynet "github.com/hdac-io/simulator/net"
-	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/wire"
+)
+
+// Timing of connections, peer sending nothing for readTimeout is dead
+// since idle connection is pinged at pingInterval
+const (
+	pingInterval = 5 * time.Second
+	readTimeout  = 3 * pingInterval
+	writeTimeout = 10 * time.Second
+)
+
+// sendQueueSize limits frames waiting to be written to a peer
+const sendQueueSize = 4096
 
-	"github.com/hdac-io/simulator/block"
+// Errors closing connections
+var (
+	ErrSendQueueFull = errors.New("Send queue is full")
 )
 
 type connection struct {
 	address    mynet.Address
 	connection net.Conn
-	encoder    *gob.Encoder
-	decoder    *gob.Decoder
-}
+	reader     *bufio.Reader
 
-func newConnection(address mynet.Address, conn net.Conn) connection {
-	if conn == nil {
-		panic("Connection is nil !!")
-	}
+	// Frames waiting to be written
+	queue chan []byte
 
-	gob.Register(block.Block{})
-	gob.Register(signature.Signature{})
+	// Closed when the connection is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error
+}
 
-	return connection{
+func newConnection(address mynet.Address, conn net.Conn) *connection {
+	c := &connection{
 		address:    address,
 		connection: conn,
-		encoder:    gob.NewEncoder(conn),
-		decoder:    gob.NewDecoder(conn),
+		reader:     bufio.NewReader(conn),
+		queue:      make(chan []byte, sendQueueSize),
+		closed:     make(chan struct{}),
 	}
+	go c.writeLoop()
+
+	return c
 }
 
-type packet struct {
-	Load mynet.Load
+// Write queues load to be written to TCP network, connection of the peer
+// not reading its loads is closed when the queue is full
+func (c *connection) Write(l mynet.Load) {
+	frame, err := wire.Encode(l)
+	if err != nil {
+		// Load without wire encoding is not sent
+		return
+	}
+	select {
+	case <-c.closed:
+		// Load written to closed connection is dropped
+	case c.queue <- frame:
+	default:
+		c.close(ErrSendQueueFull)
+	}
 }
 
-// Write load to TCP network
-func (c connection) Write(l mynet.Load) {
-	go func() {
-		err := c.encoder.Encode(packet{Load: l})
-		if err != nil {
-			panic(err)
+// writeLoop writes queued frames in order and pings idle connection
+func (c *connection) writeLoop() {
+	ping, err := wire.Encode(wire.Ping{})
+	if err != nil {
+		panic(err)
+	}
+	timer := time.NewTimer(pingInterval)
+	defer timer.Stop()
+	for {
+		var frame []byte
+		select {
+		case <-c.closed:
+			return
+		case frame = <-c.queue:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+			frame = ping
+		}
+		c.connection.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := c.connection.Write(frame); err != nil {
+			c.close(err)
+			return
 		}
-	}()
+		timer.Reset(pingInterval)
+	}
 }
 
 // Read load from TCP network
-func (c connection) Read() mynet.Load {
-	p := packet{}
-	err := c.decoder.Decode(&p)
-	if err != nil && err != io.EOF {
-		panic(err)
+func (c *connection) Read() mynet.Load {
+	for {
+		c.connection.SetReadDeadline(time.Now().Add(readTimeout))
+		l, err := wire.ReadFrame(c.reader)
+		if err != nil {
+			// Closed by either side, dead peer or malformed frame
+			c.close(err)
+			return nil
+		}
+		if _, ok := l.(wire.Ping); !ok {
+			return l
+		}
 	}
-
-	return p.Load
 }
 
 // GetAddress retrieves network address
-func (c connection) GetAddress() mynet.Address {
+func (c *connection) GetAddress() mynet.Address {
 	return c.address
 }
+
+// Close closes TCP connection
+func (c *connection) Close() {
+	c.close(nil)
+}
+
+// Err returns the error closed the connection
+func (c *connection) Err() error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// close closes the connection by err once
+func (c *connection) close(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.connection.Close()
+	})
+}
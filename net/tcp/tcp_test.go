@@ -0,0 +1,41 @@
+package tcp
+
+import (
+	"io"
+	"testing"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnection(t *testing.T) {
+	network, err := New("127.0.0.1:0", nil)
+	require.NoError(t, err)
+	defer network.Close()
+
+	accepted := make(chan *connection, 1)
+	go func() {
+		accepted <- network.Accept().(*connection)
+	}()
+	client := Connect(network.listener.Addr().String(), nil)
+	require.NotNil(t, client)
+	server := <-accepted
+
+	// Writes are delivered in order
+	for i := 0; i < 100; i++ {
+		client.Write(block.Transaction{From: 1, Nonce: uint64(i)})
+	}
+	for i := 0; i < 100; i++ {
+		require.Equal(t, uint64(i), server.Read().(block.Transaction).Nonce)
+	}
+
+	// Closing side reports no error while the other side reads end of stream
+	client.Close()
+	require.Nil(t, server.Read())
+	require.NoError(t, client.Err())
+	require.Equal(t, io.EOF, server.Err())
+
+	// Load written to closed connection is dropped
+	server.Write(block.Transaction{})
+	require.Nil(t, server.Read())
+}
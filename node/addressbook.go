@@ -1,6 +1,12 @@
 package node
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/hdac-io/simulator/bls"
 	"github.com/hdac-io/simulator/net"
 	"github.com/hdac-io/simulator/types"
 )
@@ -86,3 +92,40 @@ func PrepareAddressbook() Addressbook {
 
 	return addressbook
 }
+
+// GenerateAddressbook generates addressbook of numValidators validators,
+// BLS keys are derived from the seed so that same seed gives same keys
+func GenerateAddressbook(numValidators int, seed int64) Addressbook {
+	addressbook := make(Addressbook)
+	for i := 1; i <= numValidators; i++ {
+		buf := make([]byte, 16)
+		binary.BigEndian.PutUint64(buf, uint64(seed))
+		binary.BigEndian.PutUint64(buf[8:], uint64(i))
+		hash := sha256.Sum256(buf)
+
+		var secret bls.SecretKey
+		if err := secret.SetLittleEndian(hash[:]); err != nil {
+			panic(err)
+		}
+		addressbook.Add(types.ID(i), fmt.Sprintf("127.0.0.1:%d", 7000+i),
+			secret.SerializeToHexStr(), secret.GetPublicKey().SerializeToHexStr())
+	}
+
+	return addressbook
+}
+
+// Add adds validator to addressbook
+func (a Addressbook) Add(id types.ID, netAddress net.Address, secret string, publicKey string) {
+	a[id] = address{ID: id, Address: netAddress, Secret: secret, PublicKey: publicKey}
+}
+
+// IDs returns validator IDs in ascending order
+func (a Addressbook) IDs() []types.ID {
+	ids := make([]types.ID, 0, len(a))
+	for id := range a {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
@@ -0,0 +1,278 @@
+package node
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
+)
+
+// Byzantine behaviors
+const (
+	// Equivocation sends two different blocks at one height to each half of peers
+	Equivocation = "equivocation"
+	// ConflictingVote sends votes for a different hash to half of peers. Node
+	// receiving only one of the votes cannot tell it from vote on other block
+	// of the round, so it is detected only by nodes receiving both votes.
+	ConflictingVote = "conflicting-vote"
+	// WithholdVote sends no votes
+	WithholdVote = "withhold-vote"
+	// ForgedVRF produces blocks with invalid VRF proof
+	ForgedVRF = "forged-vrf"
+	// GarbagePayload sends signatures having random payload
+	GarbagePayload = "garbage-payload"
+	// Silent sends neither blocks nor votes as if the validator is offline
+	Silent = "silent"
+)
+
+var faultKinds = []string{Equivocation, ConflictingVote, WithholdVote, ForgedVRF, GarbagePayload, Silent}
+
+// Errors of invalid blocks
+var (
+	errDuplicateBlock  = errors.New("Duplicate block")
+	errEquivocation    = errors.New("Different block at same height")
+	errConflictingVote = errors.New("Conflicting vote")
+	errInvalidHeight   = errors.New("Invalid block height")
+	errInvalidRound    = errors.New("Invalid block round")
+)
+
+// ParseFaults parses byzantine validators such as "3:equivocation,5:withhold-vote+garbage-payload"
+func ParseFaults(s string) (map[types.ID][]config.Fault, error) {
+	faults := make(map[types.ID][]config.Fault)
+	if s == "" {
+		return faults, nil
+	}
+	for _, validator := range strings.Split(s, ",") {
+		fields := strings.Split(validator, ":")
+		if len(fields) != 2 {
+			return nil, errors.New("Usage: <validator ID>:<fault>[+<fault>...]")
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, kind := range strings.Split(fields[1], "+") {
+			if !IsFaultKind(kind) {
+				return nil, errors.New("Unknown fault: " + kind)
+			}
+			faults[types.ID(id)] = append(faults[types.ID(id)], config.Fault{Kind: kind})
+		}
+	}
+
+	return faults, nil
+}
+
+// IsFaultKind reports whether kind is a known byzantine behavior
+func IsFaultKind(kind string) bool {
+	for _, k := range faultKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// byzantine injects faults into outgoing messages of a validator
+type byzantine struct {
+	sync.Mutex
+	faults []config.Fault
+	random *rand.Rand
+}
+
+func newByzantine(faults []config.Fault, seed int64) *byzantine {
+	return &byzantine{
+		faults: faults,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// has reports whether the behavior is active at the height
+func (b *byzantine) has(kind string, height int) bool {
+	if b == nil {
+		return false
+	}
+	for _, f := range b.faults {
+		if f.Kind == kind && f.Active(height) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *byzantine) garbage() []byte {
+	b.Lock()
+	defer b.Unlock()
+	buf := make([]byte, 32+b.random.Intn(64))
+	b.random.Read(buf)
+	return buf
+}
+
+// sendBlock sends produced block to peers
+func (n *Node) sendBlock(b block.Block) {
+	if n.byzantine.has(Silent, b.Header.Height) {
+		return
+	}
+	if n.byzantine.has(ForgedVRF, b.Header.Height) {
+		vrf := b.VRF
+		vrf.Proof = n.byzantine.garbage()
+		b = block.New(b.Header, vrf, b.Transactions)
+		n.logger.Warn("Forge VRF proof", "Height", b.Header.Height)
+	}
+
+	if !n.byzantine.has(Equivocation, b.Header.Height) {
+		n.channel.sendBlock(b)
+		return
+	}
+
+	header := b.Header
+	header.Timestamp++
+	conflict := block.New(header, b.VRF, b.Transactions)
+	n.logger.Warn("Equivocate block", "Height", b.Header.Height)
+	peers := n.channel.getPeers()
+	for i, peer := range peers {
+		if i < len(peers)/2 {
+			n.channel.write(peer, b)
+		} else {
+			n.channel.write(peer, conflict)
+		}
+	}
+}
+
+// sendVote sends vote on hash made by vote to peers
+func (n *Node) sendVote(hash []byte, vote func(hash []byte) signature.Signature) {
+	sign := vote(hash)
+	if n.byzantine.has(WithholdVote, sign.BlockHeight) || n.byzantine.has(Silent, sign.BlockHeight) {
+		return
+	}
+	if !n.byzantine.has(ConflictingVote, sign.BlockHeight) {
+		n.sendSignature(sign)
+		return
+	}
+
+	conflictHash := sha256.Sum256(hash)
+	conflict := vote(conflictHash[:])
+	n.logger.Warn("Send conflicting vote", "Kind", sign.Kind, "Height", sign.BlockHeight)
+	peers := n.channel.getPeers()
+	for i, peer := range peers {
+		if i < len(peers)/2 {
+			n.channel.write(peer, sign)
+		} else {
+			n.channel.write(peer, conflict)
+		}
+	}
+}
+
+// sendSignature sends signature to peers
+func (n *Node) sendSignature(sign signature.Signature) {
+	if n.byzantine.has(WithholdVote, sign.BlockHeight) || n.byzantine.has(Silent, sign.BlockHeight) {
+		return
+	}
+	if n.byzantine.has(GarbagePayload, sign.BlockHeight) {
+		sign.Payload = n.byzantine.garbage()
+	}
+	n.channel.sendSignature(sign)
+}
+
+// reportFault logs detected byzantine behavior of a validator
+func (n *Node) reportFault(id types.ID, height int, err error) {
+	n.logger.Warn("Byzantine behavior detected", "Suspect", id, "Height", height, "Reason", err.Error())
+}
+
+// checkKnownBlock detects block at the height already appended, block of
+// later round waits for the known block to be rolled back by view change
+func (n *Node) checkKnownBlock(b block.Block) error {
+	if b.Header.Height < 1 {
+		return errInvalidHeight
+	}
+	if b.Header.Round < 0 {
+		return errInvalidRound
+	}
+	if b.Header.Height > n.status.GetHeight() {
+		return nil
+	}
+	known, err := n.status.GetBlock(b.Header.Height)
+	if err != nil || known.Hash == b.Hash {
+		return errDuplicateBlock
+	}
+	if b.Header.Round == known.Header.Round {
+		return errEquivocation
+	}
+	if b.Header.Round < known.Header.Round || b.Header.Height <= n.status.GetFinalizedHeight() {
+		return errStaleRound
+	}
+	return nil
+}
+
+// validateVRF verifies VRF proof of the block against previous block
+func (n *Node) validateVRF(b block.Block) error {
+	if b.VRF.PreviousBlockHeight == 0 {
+		// Genesis block
+		return nil
+	}
+	if b.VRF.PreviousBlockHeight != b.Header.Height-1 || b.VRF.PreviousProposerID != b.Header.Producer {
+		return errors.New("Invalid VRF message")
+	}
+	previous, err := n.status.GetBlock(b.VRF.PreviousBlockHeight)
+	if err != nil {
+		return err
+	}
+	return b.VRF.Validate(previous.Hash)
+}
+
+// voteFilter returns signature filter accepting single valid vote of each
+// validator on hash of the block produced by proposer, verify checks payload
+// of the vote signed on a hash. Valid vote on other hash is vote on other
+// block of the round, it is dropped without report unless the proposer signs
+// it or the voter also votes on hash.
+func (n *Node) voteFilter(hash []byte, proposer types.ID, verify func(sign signature.Signature, payload []byte, hash []byte) error) func(signature.Signature) bool {
+	accepted := make(map[types.ID][]byte)
+	others := make(map[types.ID]bool)
+	return func(sign signature.Signature) bool {
+		payload := sign.Payload
+		if len(payload) == 0 {
+			n.reportFault(sign.ID, sign.BlockHeight, errors.New("Invalid payload"))
+			return false
+		}
+		if _, exist := n.addressbook[sign.ID]; !exist {
+			n.reportFault(sign.ID, sign.BlockHeight, errors.New("Unknown validator"))
+			return false
+		}
+		if err := verify(sign, payload, hash); err != nil {
+			if len(sign.Hash) == 0 || bytes.Equal(sign.Hash, hash) || verify(sign, payload, sign.Hash) != nil {
+				n.reportFault(sign.ID, sign.BlockHeight, err)
+				return false
+			}
+			if others[sign.ID] {
+				return false
+			}
+			others[sign.ID] = true
+			if sign.ID == proposer {
+				n.reportFault(sign.ID, sign.BlockHeight, errEquivocation)
+			} else if _, exist := accepted[sign.ID]; exist {
+				n.reportFault(sign.ID, sign.BlockHeight, errConflictingVote)
+			}
+			return false
+		}
+
+		// Accept only one vote of each validator
+		if previous, exist := accepted[sign.ID]; exist {
+			if string(previous) != string(payload) {
+				n.reportFault(sign.ID, sign.BlockHeight, errConflictingVote)
+			}
+			return false
+		}
+		if others[sign.ID] && sign.ID != proposer {
+			n.reportFault(sign.ID, sign.BlockHeight, errConflictingVote)
+		}
+		accepted[sign.ID] = payload
+		return true
+	}
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/node/status"
+	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFaults(t *testing.T) {
+	faults, err := ParseFaults("3:equivocation,5:withhold-vote+garbage-payload")
+	require.Nil(t, err)
+	require.Equal(t, map[types.ID][]config.Fault{
+		3: {{Kind: Equivocation}},
+		5: {{Kind: WithholdVote}, {Kind: GarbagePayload}},
+	}, faults)
+
+	_, err = ParseFaults("3:sleeping")
+	require.NotNil(t, err)
+	_, err = ParseFaults("equivocation")
+	require.NotNil(t, err)
+}
+
+func TestWaitAndRemoveValid(t *testing.T) {
+	pool := newSignaturePool(clock.NewReal())
+	pool.add(signature.Prepare, signature.NewVote(1, signature.Prepare, 1, 0, []byte{1}, []byte{1}))
+	pool.add(signature.Prepare, signature.NewVote(2, signature.Prepare, 1, 0, []byte{1}, []byte{0}))
+	pool.add(signature.Prepare, signature.NewVote(1, signature.Prepare, 1, 0, []byte{1}, []byte{1}))
+	pool.add(signature.Prepare, signature.NewVote(4, signature.Prepare, 1, 0, []byte{2}, []byte{2}))
+	pool.add(signature.Prepare, signature.NewVote(5, signature.Prepare, 1, 0, []byte{2}, []byte{2}))
+	pool.add(signature.Prepare, signature.NewVote(6, signature.Prepare, 1, 0, []byte{2}, []byte{2}))
+	pool.add(signature.Prepare, signature.NewVote(6, signature.Prepare, 1, 0, []byte{1}, []byte{1}))
+	pool.add(signature.Prepare, signature.NewVote(3, signature.Prepare, 1, 0, []byte{1}, []byte{1}))
+
+	suspects := make([]interface{}, 0)
+	logger := log.New()
+	logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		suspects = append(suspects, r.Ctx[1])
+		return nil
+	}))
+	n := &Node{
+		addressbook: Addressbook{1: {ID: 1}, 2: {ID: 2}, 3: {ID: 3}, 4: {ID: 4}, 5: {ID: 5}, 6: {ID: 6}},
+		logger:      logger,
+	}
+	filter := n.voteFilter([]byte{1}, 5, func(s signature.Signature, payload []byte, hash []byte) error {
+		if payload[0] != hash[0] {
+			return errors.New("Invalid signature")
+		}
+		return nil
+	})
+
+	// Invalid vote of 2 and duplicate vote of 1 are dropped, vote of 4 on
+	// other block is dropped without report but the proposer 5 equivocates.
+	// Conflicting votes of 6 are detected since both reach this node, one of
+	// them alone would be dropped like vote of 4.
+	signs := pool.waitAndRemoveValid(signature.Prepare, 1, 0, 3, filter)
+	require.Equal(t, 3, len(signs))
+	require.Equal(t, types.ID(1), signs[0].ID)
+	require.Equal(t, types.ID(6), signs[1].ID)
+	require.Equal(t, types.ID(3), signs[2].ID)
+	require.Equal(t, []interface{}{types.ID(2), types.ID(5), types.ID(6)}, suspects)
+}
+
+func TestInvalidBlockPosition(t *testing.T) {
+	clock := clock.NewReal()
+	n := &Node{
+		id:     1,
+		logger: log.New(),
+		clock:  clock,
+	}
+	n.logger.SetHandler(log.DiscardHandler())
+	n.status = status.New(1, 4, 0, persistent.New(), app.NewKVStore(), n.logger, clock, metrics.NewRegistry())
+	n.viewChange = newViewChange(n, time.Second)
+
+	// Block of height 0 or negative round is rejected before lookup of known blocks
+	b := block.New(block.BlockHeader{Height: 0, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+	require.Equal(t, errInvalidHeight, n.viewChange.waitRound(b))
+	b = block.New(block.BlockHeader{Height: -1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+	require.Equal(t, errInvalidHeight, n.viewChange.waitRound(b))
+	b = block.New(block.BlockHeader{Height: 1, Round: -1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+	require.Equal(t, errInvalidRound, n.viewChange.waitRound(b))
+
+	_, err := n.status.GetBlock(0)
+	require.NotNil(t, err)
+}
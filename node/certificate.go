@@ -0,0 +1,126 @@
+package node
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/types"
+)
+
+// certificateProofOID identifies certificate extension holding BLS signature
+// of the certificate public key, it is not a registered identifier
+var certificateProofOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+
+// TLSConfig returns TLS configuration of the validator. Its self-signed
+// certificate is bound to the validator identity by BLS signature of the
+// certificate key, certificates of peers are checked against addressbook.
+// BLS secret is read from keystore if it is configured.
+func TLSConfig(id types.ID, addressbook Addressbook, config *config.Config) (*tls.Config, error) {
+	certificate, err := newCertificate(id, addressbook, config)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAnyClientCert,
+		// Certificates are self-signed, verified by VerifyPeerCertificate
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: certificateVerifier(addressbook),
+		MinVersion:            tls.VersionTLS12,
+	}, nil
+}
+
+// newCertificate generates key of the validator and certificate signed by it
+func newCertificate(id types.ID, addressbook Addressbook, config *config.Config) (tls.Certificate, error) {
+	validator, err := validatorKey(id, addressbook, config)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var secret bls.SecretKey
+	if err := secret.DeserializeHexStr(validator.BLSSecret); err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	hash := sha256.Sum256(publicKey)
+	proof := secret.SignHash(hash[:])
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(id)),
+		Subject:      pkix.Name{CommonName: fmt.Sprintf("validator-%d", id)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{
+			{Id: certificateProofOID, Value: proof.Serialize()},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// certificateVerifier returns verifier accepting certificate whose key is
+// signed by BLS key of the validator in addressbook named by serial number
+func certificateVerifier(addressbook Addressbook) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("Missing certificate")
+		}
+		certificate, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if !certificate.SerialNumber.IsInt64() {
+			return errors.New("Unknown validator")
+		}
+		address, exist := addressbook[types.ID(certificate.SerialNumber.Int64())]
+		if !exist {
+			return errors.New("Unknown validator")
+		}
+
+		var proof []byte
+		for _, extension := range certificate.Extensions {
+			if extension.Id.Equal(certificateProofOID) {
+				proof = extension.Value
+			}
+		}
+		if proof == nil {
+			return errors.New("Missing certificate proof")
+		}
+		pubkey := bls.PublicKey{}
+		if err := pubkey.DeserializeHexStr(address.PublicKey); err != nil {
+			return err
+		}
+		sign := bls.Sign{}
+		if err := sign.Deserialize(proof); err != nil {
+			return err
+		}
+		hash := sha256.Sum256(certificate.RawSubjectPublicKeyInfo)
+		if !sign.VerifyHash(&pubkey, hash[:]) {
+			return errors.New("Invalid certificate proof")
+		}
+		return nil
+	}
+}
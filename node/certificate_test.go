@@ -0,0 +1,61 @@
+package node
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/hdac-io/simulator/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig(t *testing.T) {
+	addressbook := GenerateAddressbook(3, 1)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	handshake := func(client *tls.Config, server *tls.Config) (error, error) {
+		done := make(chan error, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				done <- err
+				return
+			}
+			defer conn.Close()
+			done <- tls.Server(conn, server).Handshake()
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return err, <-done
+		}
+		defer conn.Close()
+		return tls.Client(conn, client).Handshake(), <-done
+	}
+
+	first, err := TLSConfig(1, addressbook, config.GetDefault())
+	require.NoError(t, err)
+	second, err := TLSConfig(2, addressbook, config.GetDefault())
+	require.NoError(t, err)
+	clientErr, serverErr := handshake(first, second)
+	require.NoError(t, clientErr)
+	require.NoError(t, serverErr)
+
+	// Certificate of validator 2 signed by key of validator 3 is rejected
+	forged := Addressbook{}
+	for id, address := range addressbook {
+		forged[id] = address
+	}
+	impostor := forged[2]
+	impostor.Secret = addressbook[3].Secret
+	forged[2] = impostor
+	_, err = TLSConfig(2, forged, config.GetDefault())
+	require.Error(t, err)
+
+	impostor.PublicKey = addressbook[3].PublicKey
+	forged[2] = impostor
+	impostorConfig, err := TLSConfig(2, forged, config.GetDefault())
+	require.NoError(t, err)
+	_, serverErr = handshake(impostorConfig, first)
+	require.Error(t, serverErr)
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/node/status"
+	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+	log "github.com/inconshreveable/log15"
+)
+
+// newBlock constructs block of this validator at the round of the height on top of the previous block
+func (n *Node) newBlock(height int, round int, timestamp time.Time) block.Block {
+	header := block.BlockHeader{
+		Height:    height,
+		Round:     round,
+		Timestamp: timestamp.UnixNano(),
+		Producer:  n.id,
+		App:       n.status.GetAppState(),
+	}
+
+	// Make VRFMessage
+	var vrf vrfmessage.VRFMessage
+	if height > 1 {
+		// Make vrf by previous block
+		previous, _ := n.status.GetBlock(height - 1)
+		vrf = vrfmessage.New(n.privKey, n.pubKey, n.id, previous.Hash, height-1)
+		header.Parent = previous.Hash
+	} else {
+		// TODO::FIXME refectoring to initializeGenesisBlock
+		// for producing genesis block
+		vrf = vrfmessage.New(n.privKey, n.pubKey, n.id, [32]byte{0}, 0)
+	}
+
+	return block.New(header, vrf, n.reapTransactions())
+}
+
+// validateParent checks that the block is linked to the previous block
+func (n *Node) validateParent(b block.Block) error {
+	var parent [32]byte
+	if b.Header.Height > 1 {
+		previous, err := n.status.GetBlock(b.Header.Height - 1)
+		if err != nil {
+			return err
+		}
+		parent = previous.Hash
+	}
+	if b.Header.Parent != parent {
+		return errors.New("Invalid parent hash")
+	}
+	return nil
+}
+
+// ValidateStore validates chain in block store of the validator in configured storage
+func ValidateStore(id types.ID, addressbook Addressbook, config *config.Config) (int, error) {
+	if _, err := os.Stat(storePath(id, config)); err != nil {
+		return 0, err
+	}
+	p, err := persistent.Open(storePath(id, config))
+	if err != nil {
+		return 0, err
+	}
+	defer p.Close()
+	return ValidateChain(p, addressbook, config)
+}
+
+// ValidateChain walks blocks stored in p from the first block and verifies
+// hashes, parent linkage, producers, VRF proofs and finalization signatures
+// of validators in addressbook. It returns height of the last block.
+func ValidateChain(p persistent.Persistent, addressbook Addressbook, config *config.Config) (int, error) {
+	newConsensus, exist := engines[config.Consensus.Algorithm]
+	if !exist {
+		return 0, errors.New("Unknown consensus: " + config.Consensus.Algorithm)
+	}
+	application, err := app.New(config.App.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	// Node only reading the chain
+	n := &Node{
+		addressbook: addressbook,
+		parameter:   parameter{numValidators: len(addressbook), lenULB: config.Consensus.LenULB},
+		config:      config,
+		logger:      log.New("module", "chain"),
+		clock:       clock.NewReal(),
+	}
+	n.status = status.New(0, len(addressbook), config.Consensus.LenULB, p, application, n.logger, n.clock, metrics.NewRegistry())
+	n.viewChange = newViewChange(n, config.Consensus.RoundTimeout)
+	n.consensus = newConsensus(n)
+
+	height := n.status.GetFinalizedHeight()
+	for h := 1; h <= height; h++ {
+		b, err := n.status.GetBlock(h)
+		if err != nil {
+			return h - 1, err
+		}
+		signs, err := n.status.GetSignature(h)
+		if err != nil {
+			return h - 1, err
+		}
+		if b.Header.Height != h {
+			return h - 1, fmt.Errorf("Block %d: Invalid height", h)
+		}
+		if err := n.validateSynced(b); err != nil {
+			return h - 1, fmt.Errorf("Block %d: %v", h, err)
+		}
+		if err := n.consensus.verifyFinalization(b, signs); err != nil {
+			return h - 1, fmt.Errorf("Block %d: %v", h, err)
+		}
+	}
+
+	return height, nil
+}
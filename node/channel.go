@@ -1,30 +1,104 @@
 package node
 
 import (
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/blocksync"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/metrics"
 	"github.com/hdac-io/simulator/net"
 	"github.com/hdac-io/simulator/net/loopback"
-	"github.com/hdac-io/simulator/net/tcp"
 	"github.com/hdac-io/simulator/signature"
 	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/wire"
+	log "github.com/inconshreveable/log15"
+)
+
+// Lost outbound peer is redialed after reconnectInterval, the interval is
+// doubled on every attempt up to maxReconnectInterval. It is reset once
+// connection of the peer lasts maxReconnectInterval so that flapping peer
+// is not redialed at short interval.
+const (
+	reconnectInterval    = 100 * time.Millisecond
+	maxReconnectInterval = 10 * time.Second
 )
 
 // channel represents inbound and outbound channel
 type channel struct {
 	sync.Mutex
-	id    types.ID
-	tcp   tcp.Network
-	peers map[net.Address]*peer
+	id          types.ID
+	addressbook Addressbook
+	secretKey   bls.SecretKey
+	transport   net.Transport
+	clock       clock.Clock
+	logger      log.Logger
+	// Set when the channel is closed, lost peers are not redialed
+	stopped bool
+	// Next redial interval of peers
+	backoff map[types.ID]time.Duration
+	// Authenticated peers by ID
+	peers map[types.ID]*peer
+	// Signaled when handshake of a peer ends
+	handshaked clock.Cond
+	// Peers in connected order for deterministic broadcasting
+	peerList []*peer
+	// Validators linked to this node, every validator is linked if it is nil
+	neighbors map[types.ID]bool
+
+	// Gossip is disabled unless seen is set
+	fanout int
+	ttl    int
+	seen   *seenCache
+	random *rand.Rand
+
+	metrics *metrics.Registry
+
+	// Highest height of received blocks
+	highest int
+	// Next peer asked blocks
+	syncCursor int
 
 	// for inbound
-	block     chan block.Block
-	signature chan signature.Signature
+	block        chan block.Block
+	signature    chan signature.Signature
+	transaction  chan block.Transaction
+	syncRequest  chan syncRequest
+	syncResponse chan blocksync.Response
+
+	handlers map[wire.Type]handler
 }
 
+// handler processes load of a type read from the peer
+type handler func(p *peer, load net.Load)
+
 type peer struct {
 	connection net.Connection
+	loopback   bool
+	closed     bool
+	// Whether this side dialed the connection, destination is redialed when it is lost
+	outbound    bool
+	destination address
+	// When the peer is registered
+	since time.Time
+
+	// ID validated by handshake
+	id            types.ID
+	authenticated bool
+	// ID claimed by the peer before it is authenticated
+	claimed types.ID
+	// Challenges of this side and the peer
+	challenge     []byte
+	peerChallenge []byte
+}
+
+// syncRequest is block request with the peer to respond
+type syncRequest struct {
+	peer    *peer
+	request blocksync.Request
 }
 
 func newPeer(network net.Connection) *peer {
@@ -33,14 +107,36 @@ func newPeer(network net.Connection) *peer {
 	}
 }
 
-// newChannel construct channel
-func newChannel(myaddr address) *channel {
+// newChannel construct channel of the validator authenticating peers in addressbook
+func newChannel(id types.ID, addressbook Addressbook, secretKey bls.SecretKey, transport net.Transport, clock clock.Clock,
+	logger log.Logger, metrics *metrics.Registry) *channel {
 	c := channel{
-		id:        myaddr.ID,
-		tcp:       tcp.New(myaddr.Address),
-		peers:     make(map[net.Address]*peer),
-		block:     make(chan block.Block, 1024),
-		signature: make(chan signature.Signature, 1024),
+		id:           id,
+		addressbook:  addressbook,
+		secretKey:    secretKey,
+		transport:    transport,
+		clock:        clock,
+		logger:       logger,
+		peers:        make(map[types.ID]*peer),
+		backoff:      make(map[types.ID]time.Duration),
+		peerList:     make([]*peer, 0),
+		metrics:      metrics,
+		block:        make(chan block.Block, 1024),
+		signature:    make(chan signature.Signature, 1024),
+		transaction:  make(chan block.Transaction, 1024),
+		syncRequest:  make(chan syncRequest, 1024),
+		syncResponse: make(chan blocksync.Response, 1024),
+	}
+
+	c.handshaked = clock.NewCond(&c)
+	c.handlers = map[wire.Type]handler{
+		wire.TypeBlock:        c.handleBlock,
+		wire.TypeSignature:    c.handleSignature,
+		wire.TypeTransaction:  c.handleTransaction,
+		wire.TypeSyncRequest:  c.handleSyncRequest,
+		wire.TypeSyncResponse: c.handleSyncResponse,
+		wire.TypeHandshake:    c.handleHandshake,
+		wire.TypeGossip:       c.handleGossip,
 	}
 
 	// Start connection listener
@@ -49,100 +145,432 @@ func newChannel(myaddr address) *channel {
 	return &c
 }
 
-func (c *channel) addKnownPeers(addressbook Addressbook) {
+// addKnownPeers connects to peers in addressbook and waits their handshakes,
+// restarted node connects to every peer since the peers do not know it came back
+func (c *channel) addKnownPeers(addressbook Addressbook, restarted bool) {
 	// Add loopback
-	connection := loopback.Connect("loopback")
-	peer := newPeer(connection)
-	c.setPeer(peer)
+	connection := loopback.Connect("loopback", c.clock)
+	self := newPeer(connection)
+	self.loopback = true
+	self.id = c.id
+	self.authenticated = true
+	c.register(self)
+	c.startReader(self)
 
-	for id, address := range addressbook {
+	connected := make([]*peer, 0)
+	for _, id := range addressbook.IDs() {
+		address := addressbook[id]
 		if id != address.ID {
 			panic("Invalid address !")
 		}
-		c.addPeer(address)
+		if p := c.addPeer(address, restarted); p != nil {
+			connected = append(connected, p)
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for _, p := range connected {
+		for !p.authenticated && !p.closed {
+			c.handshaked.Wait()
+		}
 	}
 }
 
-func (c *channel) addPeer(peer address) {
-	// FIXME
+// addPeer connects to the peer if this node should, it returns the connected peer.
+// The peer not reachable yet is redialed in background.
+func (c *channel) addPeer(peer address, restarted bool) *peer {
+	if c.neighbors != nil && !c.neighbors[peer.ID] {
+		// Not linked to the peer
+		return nil
+	}
 	// Node has higher ID connect to nodes have lower ID
-	if peer.ID < c.id {
+	if peer.ID < c.id || (restarted && peer.ID != c.id) {
 		// Connect to the peer
-		c.connectToPeer(peer.Address)
+		p := c.connectToPeer(peer)
+		if p == nil {
+			c.reconnect(peer)
+		}
+		return p
 	} else if peer.ID == c.id {
 		// Loopback is aleady connected
 	} else {
 		// Just wait since the peer will connect to us
 	}
+	return nil
 }
 
-func (c *channel) connectToPeer(destination net.Address) {
+func (c *channel) connectToPeer(destination address) *peer {
 	// FIXME: very naive locking mechanism
 	c.Lock()
-	_, exist := c.peers[destination]
+	_, exist := c.peers[destination.ID]
 	if exist {
 		c.Unlock()
-		return
+		return nil
 	}
 	c.Unlock()
 
-	dest := tcp.Connect(destination)
-	peer := newPeer(dest)
-	c.setPeer(peer)
+	dest := c.transport.Connect(destination.Address)
+	if dest == nil {
+		// The peer is down
+		return nil
+	}
+	p := newPeer(dest)
+	p.outbound = true
+	p.destination = destination
+	c.startPeer(p)
+	return p
+}
+
+func (c *channel) getPeers() []*peer {
+	c.Lock()
+	defer c.Unlock()
+	return c.peerList
 }
 
 func (c *channel) sendSignature(sign signature.Signature) {
-	for _, peer := range c.peers {
-		peer.connection.Write(sign)
+	if c.gossiping() {
+		c.gossip(sign)
+		return
+	}
+	for _, peer := range c.getPeers() {
+		c.write(peer, sign)
 	}
 }
 
 func (c *channel) sendBlock(b block.Block) {
-	for _, peer := range c.peers {
-		peer.connection.Write(b)
+	if c.gossiping() {
+		c.gossip(b)
+		return
+	}
+	for _, peer := range c.getPeers() {
+		c.write(peer, b)
 	}
 }
 
+// sendTransaction relays transaction to peers but loopback
+func (c *channel) sendTransaction(tx block.Transaction) {
+	if c.gossiping() {
+		c.gossip(tx)
+		return
+	}
+	for _, peer := range c.getPeers() {
+		if !peer.loopback {
+			c.write(peer, tx)
+		}
+	}
+}
+
+// sendSyncRequest sends block request to at most count peers but loopback,
+// peers are taken in turn so that requests are spread over peers.
+// It returns number of the peers.
+func (c *channel) sendSyncRequest(r blocksync.Request, count int) int {
+	remotes := make([]*peer, 0)
+	for _, peer := range c.getPeers() {
+		if !peer.loopback {
+			remotes = append(remotes, peer)
+		}
+	}
+	if count > len(remotes) {
+		count = len(remotes)
+	}
+
+	c.Lock()
+	cursor := c.syncCursor
+	c.syncCursor += count
+	c.Unlock()
+
+	for i := 0; i < count; i++ {
+		c.write(remotes[(cursor+i)%len(remotes)], r)
+	}
+	return count
+}
+
+// highestBlock returns the highest height of received blocks
+func (c *channel) highestBlock() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.highest
+}
+
+// write sends load to the peer
+func (c *channel) write(p *peer, load net.Load) {
+	kind := LoadKind(load)
+	c.metrics.Add(metrics.WithKind(metrics.MessagesSent, kind), 1)
+	c.metrics.Add(metrics.WithKind(metrics.BytesSent, kind), int64(LoadSize(load)))
+	p.connection.Write(load)
+}
+
+// received records metrics of load read from peers
+func (c *channel) received(load net.Load) {
+	kind := LoadKind(load)
+	c.metrics.Add(metrics.WithKind(metrics.MessagesReceived, kind), 1)
+	c.metrics.Add(metrics.WithKind(metrics.BytesReceived, kind), int64(LoadSize(load)))
+	if b, ok := load.(block.Block); ok {
+		c.metrics.Observe(metrics.BlockPropagation, c.clock.Now().Sub(time.Unix(0, b.Header.Timestamp)))
+	}
+}
+
+// LoadKind returns kind of the load in metrics and injected faults,
+// signatures are told apart by their kind
+func LoadKind(load net.Load) string {
+	if sign, ok := load.(signature.Signature); ok {
+		return sign.Kind.String()
+	}
+	t, _ := wire.TypeOf(load)
+	return t.String()
+}
+
+// IsLoadKind reports whether the kind is returned by LoadKind
+func IsLoadKind(kind string) bool {
+	for k := signature.Kind(0); k < signature.NumKind; k++ {
+		if kind == k.String() {
+			return true
+		}
+	}
+	for _, name := range wire.Names() {
+		if kind == name && kind != wire.TypeSignature.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadSize returns size of the load encoded on the wire
+func LoadSize(load net.Load) int {
+	data, err := wire.Encode(load)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
 func (c *channel) readSignature() signature.Signature {
-	return <-c.signature
+	return c.clock.Receive(c.signature).(signature.Signature)
 }
 
 func (c *channel) readBlock() block.Block {
-	return <-c.block
+	return c.clock.Receive(c.block).(block.Block)
+}
+
+func (c *channel) readTransaction() block.Transaction {
+	return c.clock.Receive(c.transaction).(block.Transaction)
+}
+
+func (c *channel) readSyncRequest() syncRequest {
+	return c.clock.Receive(c.syncRequest).(syncRequest)
+}
+
+// readSyncResponse waits block response at most timeout
+func (c *channel) readSyncResponse(timeout time.Duration) (blocksync.Response, bool) {
+	v, ok := c.clock.ReceiveTimeout(c.syncResponse, timeout)
+	if !ok {
+		return blocksync.Response{}, false
+	}
+	return v.(blocksync.Response), true
 }
 
 func (c *channel) startConnectionListner() {
-	go func() {
+	c.clock.Go(func() {
 		for {
-			dest := c.tcp.Accept()
-			peer := newPeer(dest)
-			c.setPeer(peer)
+			dest := c.transport.Accept()
+			if dest == nil {
+				// Transport is closed
+				return
+			}
+			c.startPeer(newPeer(dest))
 		}
-	}()
+	})
 }
 
-func (c *channel) setPeer(p *peer) {
-	address := p.connection.GetAddress()
-	// FIXME: very naive locking mechanism
+// startPeer starts handshake and reading of the connected peer
+func (c *channel) startPeer(p *peer) {
+	c.startHandshake(p)
+	c.startReader(p)
+}
+
+// register adds authenticated peer and returns whether it is accepted.
+// Connection of the same ID is replaced since the peer has reconnected, but
+// when both sides dialed each other, both keep the connection dialed by the
+// higher ID and close the other.
+func (c *channel) register(p *peer) bool {
 	c.Lock()
 	defer c.Unlock()
-	_, exist := c.peers[address]
-	if !exist {
-		c.peers[address] = p
-
-		// Start reader
-		go func() {
-			for {
-				load := p.connection.Read()
-				switch v := load.(type) {
-				case block.Block:
-					c.block <- v
-				case signature.Signature:
-					c.signature <- v
+	if old, exist := c.peers[p.id]; exist && old != p {
+		if c.canonical(old) && !c.canonical(p) {
+			p.closed = true
+			p.connection.Close()
+			return false
+		}
+		old.connection.Close()
+		c.peerList = without(c.peerList, old)
+	}
+	p.since = c.clock.Now()
+	c.peers[p.id] = p
+	c.peerList = append(c.peerList, p)
+	return true
+}
+
+// canonical returns whether connection of the peer is dialed by the higher ID
+func (c *channel) canonical(p *peer) bool {
+	return p.outbound == (c.id > p.id)
+}
+
+// peerCount returns number of connected peers but loopback
+func (c *channel) peerCount() int {
+	c.Lock()
+	defer c.Unlock()
+	if _, exist := c.peers[c.id]; exist {
+		return len(c.peers) - 1
+	}
+	return len(c.peers)
+}
+
+// startReader reads loads of the peer, loads other than handshake are
+// dropped until the peer is authenticated
+func (c *channel) startReader(p *peer) {
+	c.clock.Go(func() {
+		for {
+			load := p.connection.Read()
+			if load == nil {
+				// Connection is closed
+				c.removePeer(p)
+				return
+			}
+			c.received(load)
+			t, _ := wire.TypeOf(load)
+			c.Lock()
+			authenticated, closed := p.authenticated, p.closed
+			c.Unlock()
+			if closed || (!authenticated && t != wire.TypeHandshake) {
+				continue
+			}
+			if h, exist := c.handlers[t]; exist {
+				h(p, load)
+			}
+		}
+	})
+}
+
+func (c *channel) handleBlock(p *peer, load net.Load) {
+	b := load.(block.Block)
+	c.Lock()
+	if b.Header.Height > c.highest {
+		c.highest = b.Header.Height
+	}
+	c.Unlock()
+	c.clock.Send(c.block, b)
+}
+
+func (c *channel) handleSignature(p *peer, load net.Load) {
+	sign := load.(signature.Signature)
+	if sign.ID != p.id {
+		// Signature of another validator is impersonation
+		return
+	}
+	c.clock.Send(c.signature, sign)
+}
+
+func (c *channel) handleTransaction(p *peer, load net.Load) {
+	c.clock.Send(c.transaction, load.(block.Transaction))
+}
+
+func (c *channel) handleSyncRequest(p *peer, load net.Load) {
+	c.clock.Send(c.syncRequest, syncRequest{peer: p, request: load.(blocksync.Request)})
+}
+
+func (c *channel) handleSyncResponse(p *peer, load net.Load) {
+	c.clock.Send(c.syncResponse, load.(blocksync.Response))
+}
+
+// removePeer forgets disconnected peer unless it is already replaced,
+// lost peer dialed by this side is redialed
+func (c *channel) removePeer(p *peer) {
+	c.Lock()
+	p.closed = true
+	c.handshaked.Broadcast()
+	lost := p.authenticated && c.peers[p.id] == p
+	if lost {
+		delete(c.peers, p.id)
+		if c.clock.Now().Sub(p.since) >= maxReconnectInterval {
+			delete(c.backoff, p.id)
+		}
+	}
+	c.peerList = without(c.peerList, p)
+	stopped := c.stopped
+	c.Unlock()
+
+	if !lost || stopped {
+		return
+	}
+	c.metrics.Add(metrics.PeerDisconnects, 1)
+	c.logger.Info("Peer disconnected", "Peer", p.id, "Reason", p.connection.Err())
+	if p.outbound {
+		c.reconnect(p.destination)
+	}
+}
+
+// reconnect redials the peer with exponential backoff until the peer is
+// connected by either side or the channel is closed
+func (c *channel) reconnect(destination address) {
+	c.clock.Go(func() {
+		for {
+			c.Lock()
+			interval, exist := c.backoff[destination.ID]
+			if !exist {
+				interval = reconnectInterval
+			}
+			next := interval * 2
+			if next > maxReconnectInterval {
+				next = maxReconnectInterval
+			}
+			c.backoff[destination.ID] = next
+			c.Unlock()
+
+			c.clock.Sleep(interval)
+			c.Lock()
+			_, connected := c.peers[destination.ID]
+			stopped := c.stopped
+			c.Unlock()
+			if connected || stopped {
+				return
+			}
+
+			if p := c.connectToPeer(destination); p != nil {
+				c.Lock()
+				for !p.authenticated && !p.closed {
+					c.handshaked.Wait()
+				}
+				authenticated := p.authenticated
+				c.Unlock()
+				if authenticated {
+					c.metrics.Add(metrics.Reconnects, 1)
+					return
 				}
 			}
-		}()
-	} else {
-		panic("Cannot enter here !")
+		}
+	})
+}
+
+// without returns copy of peers without p, the list is copied since
+// broadcasting iterates the old list without lock
+func without(peers []*peer, p *peer) []*peer {
+	list := make([]*peer, 0, len(peers))
+	for _, other := range peers {
+		if other != p {
+			list = append(list, other)
+		}
+	}
+	return list
+}
+
+// close stops listening and disconnects every peer
+func (c *channel) close() {
+	c.Lock()
+	c.stopped = true
+	c.Unlock()
+	c.transport.Close()
+	for _, p := range c.getPeers() {
+		p.connection.Close()
 	}
 }
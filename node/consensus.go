@@ -1,7 +1,46 @@
 package node
 
-import "time"
+import (
+	"sort"
+	"time"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/signature"
+)
 
 type consensus interface {
 	start(genesisTime time.Time)
+	// verifyFinalization checks finalization signatures of the block received by sync
+	verifyFinalization(b block.Block, signs []signature.Signature) error
+}
+
+// Consensus engines
+const (
+	// FridayVRF collects votes from all validators on every validator
+	FridayVRF = "friday-vrf"
+	// FridayFBFT collects votes on the producer which sends aggregated votes
+	FridayFBFT = "friday-fbft"
+)
+
+// engines contains constructors of consensus engines by name
+var engines = map[string]func(node *Node) consensus{
+	FridayVRF:  newFridayVRF,
+	FridayFBFT: newFridayFBFT,
+}
+
+// IsConsensus reports whether name is a known consensus engine
+func IsConsensus(name string) bool {
+	_, exist := engines[name]
+	return exist
+}
+
+// Consensuses returns names of consensus engines
+func Consensuses() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
 }
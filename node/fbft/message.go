@@ -1,55 +1,79 @@
 package fbft
 
 import (
-	"bytes"
 	"crypto/sha256"
-	"encoding/gob"
-	"encoding/json"
+	"math/bits"
 
 	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/codec"
 )
 
-func mashalSignAndPubkey(sign bls.Sign, key bls.PublicKey) []byte {
-	mashaledJSON, _ := json.Marshal([]string{sign.SerializeToHexStr(), key.SerializeToHexStr()})
-	return mashaledJSON
+func mashalSignAndSigners(sign bls.Sign, signers Signers) []byte {
+	return codec.EncodeList(codec.EncodeBytes(sign.Serialize()), codec.EncodeBytes(signers))
 }
-func unmashalSignAndPubkey(payload []byte) (sign bls.Sign, key bls.PublicKey, err error) {
-
-	var serializedHexStrs []string
-	err = json.Unmarshal(payload, &serializedHexStrs)
-	if err == nil {
-		err = sign.DeserializeHexStr(serializedHexStrs[0])
+func unmashalSignAndSigners(payload []byte) (sign bls.Sign, signers Signers, err error) {
+	item, err := codec.Decode(payload)
+	if err != nil {
+		return sign, signers, err
 	}
+	d := codec.NewDecoder(item, 2)
+	serializedSign, serializedSigners := d.Bytes(), d.Bytes()
+	err = d.Err()
 	if err == nil {
-		err = key.DeserializeHexStr(serializedHexStrs[1])
+		err = sign.Deserialize(serializedSign)
 	}
 
-	return sign, key, err
+	return sign, Signers(serializedSigners), err
+}
+
+// Signers is bitmap of validators signing a message, bit i is the validator
+// at index i of ascending IDs
+type Signers []byte
+
+// NewSigners returns bitmap of n validators without signer
+func NewSigners(n int) Signers {
+	return make(Signers, (n+7)/8)
+}
+
+// Set marks the validator at index as signer
+func (s Signers) Set(index int) {
+	s[index/8] |= 1 << uint(index%8)
 }
 
-// Message used for Prepare, Prepared, Commit, Commited
+// Has reports whether the validator at index is signer
+func (s Signers) Has(index int) bool {
+	return index/8 < len(s) && s[index/8]&(1<<uint(index%8)) != 0
+}
+
+// Count returns number of signers
+func (s Signers) Count() int {
+	count := 0
+	for _, b := range s {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// Message used for Prepare, Prepared, Commit, Commited. Sign is aggregated
+// signature of the signers.
 type Message struct {
-	Sign   bls.Sign
-	Pubkey bls.PublicKey
+	Sign    bls.Sign
+	Signers Signers
 }
 
 //Hash receiver method is message to sha256 hash
 func (message *Message) Hash() [32]byte {
-	var buf bytes.Buffer
-	encoder := gob.NewEncoder(&buf)
-	encoder.Encode(message.Serialize())
-
-	return sha256.Sum256(buf.Bytes())
+	return sha256.Sum256(message.Serialize())
 }
 
-// Serialize return mashared json message
+// Serialize return canonical encoding of sign and signers
 func (message *Message) Serialize() []byte {
-	return mashalSignAndPubkey(message.Sign, message.Pubkey)
+	return mashalSignAndSigners(message.Sign, message.Signers)
 }
 
-// Deserialize return unmashared json message
+// Deserialize decodes canonical encoding of sign and signers
 func (message *Message) Deserialize(payload []byte) error {
 	var err error
-	message.Sign, message.Pubkey, err = unmashalSignAndPubkey(payload)
+	message.Sign, message.Signers, err = unmashalSignAndSigners(payload)
 	return err
 }
@@ -6,8 +6,10 @@ import (
 	"time"
 
 	"github.com/hdac-io/simulator/block"
-	fridaytypes "github.com/hdac-io/simulator/types"
-	"github.com/hdac-io/simulator/vrfmessage"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/node/fbft"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
 )
 
 type fridayFBFT struct {
@@ -28,16 +30,19 @@ func (f *fridayFBFT) start(genesisTime time.Time) {
 	}
 
 	// Start producing loop
-	go f.produceLoop(genesisTime)
+	f.node.clock.Go(func() { f.produceLoop(genesisTime) })
 
 	// Start validating loop
-	go f.validationLoop()
+	f.node.clock.Go(f.validationLoop)
 }
 
 func (f *fridayFBFT) produceLoop(genesisTime time.Time) {
-	nextBlockTime := genesisTime
+	nextBlockTime := f.node.nextBlockTime(genesisTime)
 	for {
-		time.Sleep(nextBlockTime.Sub(time.Now()))
+		f.node.clock.Sleep(nextBlockTime.Sub(f.node.clock.Now()))
+		if f.node.isStopped() {
+			return
+		}
 		nextBlockTime = f.produce(nextBlockTime)
 	}
 }
@@ -46,88 +51,39 @@ func (f *fridayFBFT) validationLoop() {
 	if f.node.parameter.lenULB == 0 {
 		for {
 			block := f.node.channel.readBlock()
+			if f.node.isStopped() {
+				return
+			}
 			f.validateBlock(block)
 		}
 	} else {
 		for {
 			block := f.node.channel.readBlock()
-			go f.validateBlock(block)
-		}
-	}
-}
-
-func (f *fridayFBFT) getVRFMessage(blockHeight int) vrfmessage.VRFMessage {
-	var vrfMessage vrfmessage.VRFMessage
-
-	//getting VRFMessage by previous block body
-	block, err := f.node.status.GetBlock(blockHeight)
-	if err == nil {
-		vrfMessage = block.VRF
-	} else {
-		panic("out-of-index block height")
-	}
-	return vrfMessage
-}
-
-func (f *fridayFBFT) getBlockProducerIDByHeight(height int) fridaytypes.ID {
-	var chosenNumber fridaytypes.ID
-	if height != 0 {
-		//getting VRFMessage by previous block body
-		vrfMessage := f.getVRFMessage(height)
-
-		//validate VRFMessage
-		//bypass validate when produced genesis block
-		if vrfMessage.PreviousBlockHeight != 0 {
-			targetBlock, _ := f.node.status.GetBlock(vrfMessage.PreviousBlockHeight)
-			vrfErr := vrfMessage.Validate(targetBlock.Hash)
-			if vrfErr != nil {
-				f.node.logger.Crit(vrfErr.Error())
-				//TODO::replace to decide next action when invalid VRF situation
-				panic(vrfErr)
+			if f.node.isStopped() {
+				return
 			}
+			f.node.clock.Go(func() { f.validateBlock(block) })
 		}
-
-		//calculate BP ID by VRF
-		chosenNumber = vrfMessage.CalculateBPID(f.node.parameter.numValidators)
-	} else {
-		//TODO::FIXME refectoring to initializeGenesisBlock
-		//when firstly producing genesis block, cannot have previous block status
-		chosenNumber = fridaytypes.ID(1)
 	}
-
-	return chosenNumber
 }
 
 func (f *fridayFBFT) produce(nextBlockTime time.Time) time.Time {
+	height := f.node.status.GetHeight() + 1
+	round, propose := f.node.viewChange.tick(height)
 
-	chosenNumber := f.getBlockProducerIDByHeight(f.node.status.GetHeight())
-
-	// next := 0 if there is no completed block
-	f.node.next = fridaytypes.ID(chosenNumber)
-
-	if f.node.next != f.node.id {
+	if !propose {
 		// Not my turn
 	} else {
 		// My turn
 
-		//Make VRFMessage
-		var vrf vrfmessage.VRFMessage
-		if f.node.status.GetHeight() != 0 {
-			//make vrf by previous block
-			vrf = vrfmessage.New(f.node.privKey, f.node.pubKey, f.node.id, f.node.status.GetRecentBlock().Hash, f.node.status.GetHeight())
-		} else {
-			//TODO::FIXME refectoring to initializeGenesisBlock
-			//for producing genesis block
-			vrf = vrfmessage.New(f.node.privKey, f.node.pubKey, f.node.id, [32]byte{0}, 0)
-		}
-
 		// Produce new block
-		newBlock := block.New(f.node.status.GetHeight()+1, nextBlockTime.UnixNano(), f.node.id, vrf)
+		newBlock := f.node.newBlock(height, round, nextBlockTime)
 
 		// Pre-prepare / send new block
-		f.node.channel.sendBlock(newBlock)
-		f.node.logger.Info("Block produced", "Height", newBlock.Header.Height, "Producer", newBlock.Header.Producer,
-			"Timestmp", time.Unix(0, newBlock.Header.Timestamp), "Hash", hex.EncodeToString(newBlock.Hash[:]))
+		f.node.sendBlock(newBlock)
+		f.node.logger.Info("Block produced", "Height", newBlock.Header.Height, "Round", newBlock.Header.Round,
+			"Producer", newBlock.Header.Producer, "Timestmp", time.Unix(0, newBlock.Header.Timestamp),
+			"Transactions", len(newBlock.Transactions), "Hash", hex.EncodeToString(newBlock.Hash[:]))
 
 	}
 
@@ -137,50 +93,47 @@ func (f *fridayFBFT) produce(nextBlockTime time.Time) time.Time {
 func (f *fridayFBFT) validateBlock(b block.Block) {
 	f.node.logger.Info("Block received", "Blockheight", b.Header.Height)
 
-	var isLeader bool
-	//Check Current Leader or Validator
-	currentLeaderID := f.getBlockProducerIDByHeight(f.node.status.GetHeight())
-	if f.node.id == currentLeaderID {
-		isLeader = true
-	} else {
-		isLeader = false
-	}
-
 	// Validation
 	if err := f.validate(b); err != nil {
-		f.node.logger.Crit(err.Error())
-		panic("There shoud be no byzitine nodes !")
-		//return
+		if err != errDuplicateBlock && err != errStaleRound && err != errStopped {
+			f.node.reportFault(b.Header.Producer, b.Header.Height, err)
+		}
+		return
 	}
 
-	f.node.status.AppendBlock(b)
+	f.node.appendBlock(b)
 
-	if isLeader {
+	//Check Current Leader or Validator
+	if b.Header.Producer == f.node.id {
 		f.fbftLeaderPhase(b)
 	} else {
 		f.fbftValidatorPhase(b)
 	}
 }
 
-// FIXME: We assume that there is no byzantine nodes
 func (f *fridayFBFT) validate(b block.Block) error {
-	// FIXME: we should wait next validator calculation
-	for f.node.next == 0 {
-		time.Sleep(10 * time.Millisecond)
-
+	// Wait for the round of the block and validate producer
+	if err := f.node.viewChange.waitRound(b); err != nil {
+		return err
 	}
-	// Validate producer
-	if f.node.next != b.Header.Producer {
-		return errors.New("cannot matched between f.node.next to block.Header.Producer")
+
+	// Validate block hash and body
+	if err := block.Validate(b); err != nil {
+		return err
 	}
 
-	// Validate block hash
-	if b.Hash != block.CalculateHashFromBlock(b) {
-		return errors.New("cannot invalid block hash")
+	// Validate transactions and parent
+	if err := f.node.validateTransactions(b); err != nil {
+		return err
+	}
+	if err := f.node.validateParent(b); err != nil {
+		return err
 	}
 
-	// FIXME: we should wait next validator calculation
-	f.node.next = 0
+	// Validate VRF proof
+	if err := f.node.validateVRF(b); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -189,7 +142,8 @@ func (f *fridayFBFT) fbftLeaderPhase(b block.Block) {
 	//Collecting prepare messages, Send 'PreparedMessagep
 	preparedMessage, prepareErr := f.prepareLeaderPhase(b)
 	if prepareErr != nil {
-		panic(prepareErr.Error())
+		f.node.logger.Error(prepareErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 	f.node.logger.Info("Block prepared", "Blockheight", b.Header.Height)
 
@@ -197,10 +151,11 @@ func (f *fridayFBFT) fbftLeaderPhase(b block.Block) {
 	finalizedSign, finalizedErr := f.finalizeLeaderPhase(b, preparedMessage)
 	if finalizedErr != nil {
 		// TODO::when failed finalization
-		panic(finalizedErr.Error())
+		f.node.logger.Error(finalizedErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 
-	f.node.status.Finalize(b, finalizedSign)
+	f.node.finalizeBlock(b, f.certificate(b, preparedMessage, finalizedSign))
 	f.node.logger.Info("Block finalized", "Blockheight", b.Header.Height)
 
 }
@@ -209,29 +164,110 @@ func (f *fridayFBFT) fbftValidatorPhase(b block.Block) {
 	//Send 'PrepareMessage'
 	prepareErr := f.prepareValidatorPhase(b)
 	if prepareErr != nil {
-		panic(prepareErr.Error())
+		f.node.logger.Error(prepareErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 
 	//Handling to receive 'PreparedMessage'
 	receivedPreparedMessage, preparedErr := f.onPreparedValidatorPhase(b)
 	if preparedErr != nil {
-		panic(prepareErr.Error())
+		f.node.logger.Error(preparedErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 	f.node.logger.Info("Block prepared", "Blockheight", b.Header.Height)
 
 	//Send 'CommitMessage'
 	finalizeErr := f.finalizeValidatorPhase(b, receivedPreparedMessage)
 	if finalizeErr != nil {
-		panic(finalizeErr.Error())
+		f.node.logger.Error(finalizeErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 
 	//Handling to receive 'CommitedMessage'
 	finalizedSign, finalizedErr := f.onFinalizedValidatorPhase(b, receivedPreparedMessage)
 	if finalizedErr != nil {
-		panic(finalizedErr.Error())
-
+		f.node.logger.Error(finalizedErr.Error(), "Blockheight", b.Header.Height)
+		return
 	}
 
-	f.node.status.Finalize(b, finalizedSign)
+	f.node.finalizeBlock(b, f.certificate(b, receivedPreparedMessage, finalizedSign))
 	f.node.logger.Info("Block finalized", "Blockheight", b.Header.Height)
 }
+
+// certificate returns finalization signatures of the block, prepared message
+// is kept since commited message is signed on its hash
+func (f *fridayFBFT) certificate(b block.Block, prepared fbft.Message, commited []signature.Signature) []signature.Signature {
+	preparedTx := signature.New(b.Header.Producer, signature.Prepared, b.Header.Height, b.Header.Round, prepared.Serialize())
+	return append([]signature.Signature{preparedTx}, commited...)
+}
+
+// verifyFinalization checks prepared and commited messages of the leader
+// are signed by quorum
+func (f *fridayFBFT) verifyFinalization(b block.Block, signs []signature.Signature) error {
+	if len(signs) != 2 || signs[0].Kind != signature.Prepared || signs[1].Kind != signature.Commited {
+		return errors.New("Invalid finalization signature")
+	}
+
+	var messages [2]fbft.Message
+	for i, s := range signs {
+		if s.ID != b.Header.Producer || s.BlockHeight != b.Header.Height || s.Round != b.Header.Round {
+			return errors.New("Invalid finalization signature")
+		}
+		if err := messages[i].Deserialize(s.Payload); err != nil {
+			return err
+		}
+	}
+
+	if err := f.verifyQuorum(messages[0], b.Hash[:]); err != nil {
+		return errors.New("Invalid aggregated-bls on prepared message")
+	}
+	preparedHash := messages[0].Hash()
+	if err := f.verifyQuorum(messages[1], preparedHash[:]); err != nil {
+		return errors.New("Invalid aggregated-bls on commited message")
+	}
+	return nil
+}
+
+// signers returns bitmap of the single validator
+func (f *fridayFBFT) signers(id types.ID) fbft.Signers {
+	ids := f.node.addressbook.IDs()
+	signers := fbft.NewSigners(len(ids))
+	for i := range ids {
+		if ids[i] == id {
+			signers.Set(i)
+		}
+	}
+	return signers
+}
+
+// verifyQuorum checks that aggregated signature of the message is signed on
+// hash by quorum of signers, their public keys are taken from the addressbook
+func (f *fridayFBFT) verifyQuorum(message fbft.Message, hash []byte) error {
+	ids := f.node.addressbook.IDs()
+	if len(message.Signers) != len(fbft.NewSigners(len(ids))) {
+		return errors.New("Invalid signers")
+	}
+	var aggregatedKey bls.PublicKey
+	count := 0
+	for i, id := range ids {
+		if !message.Signers.Has(i) {
+			continue
+		}
+		pubkey := bls.PublicKey{}
+		if err := pubkey.DeserializeHexStr(f.node.addressbook[id].PublicKey); err != nil {
+			return err
+		}
+		aggregatedKey.Add(&pubkey)
+		count++
+	}
+	if count != message.Signers.Count() {
+		return errors.New("Invalid signers")
+	}
+	if count < f.quorum() {
+		return errors.New("Signers less than quorum")
+	}
+	if !message.Sign.VerifyHash(&aggregatedKey, hash) {
+		return errors.New("Invalid aggregated signature")
+	}
+	return nil
+}
@@ -1,53 +1,78 @@
 package node
 
 import (
+	"bytes"
 	"errors"
 	"time"
 
 	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/metrics"
 	"github.com/hdac-io/simulator/node/fbft"
 	"github.com/hdac-io/simulator/signature"
 )
 
+// validatorMessageFilter accepts messages signed on hash by the sending validator
+func (f *fridayFBFT) validatorMessageFilter(b block.Block, hash []byte) func(signature.Signature) bool {
+	return f.node.voteFilter(hash, b.Header.Producer, func(s signature.Signature, payload []byte, hash []byte) error {
+		var deserializedMessage fbft.Message
+		if err := deserializedMessage.Deserialize(payload); err != nil {
+			return err
+		}
+
+		// Signer should be the sending validator
+		if !bytes.Equal(deserializedMessage.Signers, f.signers(s.ID)) {
+			return errors.New("Signers mismatch")
+		}
+		pubkey := bls.PublicKey{}
+		if err := pubkey.DeserializeHexStr(f.node.addressbook[s.ID].PublicKey); err != nil {
+			return err
+		}
+
+		if !deserializedMessage.Sign.VerifyHash(&pubkey, hash) {
+			return errors.New("Invalid validator message")
+		}
+		return nil
+	})
+}
+
 func (f *fridayFBFT) prepareLeaderPhase(b block.Block) (fbft.Message, error) {
 	f.node.logger.Debug("Enter prepareLeaderPhase", "blockHeight", b.Header.Height)
 
 	//Prepare Phase
-	toSendMessage := fbft.Message{}
+	toSendMessage := fbft.Message{Signers: fbft.NewSigners(len(f.node.addressbook))}
 
-	collectStartTime := time.Now()
+	collectStartTime := f.node.clock.Now()
 	// TODO::handling when timeout situation
-	receivedSignTxs := f.node.pool.waitAndRemove(signature.Prepare, b.Header.Height, f.quorum())
-	elpasedReceiveTime := time.Since(collectStartTime)
+	receivedSignTxs := f.node.pool.waitAndRemoveValid(signature.Prepare, b.Header.Height, b.Header.Round, f.quorum(), f.validatorMessageFilter(b, b.Hash[:]))
+	elpasedReceiveTime := f.node.clock.Now().Sub(collectStartTime)
 	if len(receivedSignTxs) < f.quorum() {
 		return fbft.Message{}, errors.New("Cannot receive prepare messages more than quorum")
 	}
+	if !f.node.viewChange.lock(b) {
+		return fbft.Message{}, errors.New("Round of the block is skipped")
+	}
+	f.node.observeQuorumWait(signature.Prepare, elpasedReceiveTime)
 
 	f.node.logger.Debug("Received prepare Txs over than quorum", "blockHeight", b.Header.Height, "elpasedReceiveTime", elpasedReceiveTime.String())
 
 	aggregationStartTime := time.Now()
 	for _, signTx := range receivedSignTxs {
-		if signTx.Kind != signature.Prepare {
-			return fbft.Message{}, errors.New("Cannot matched Tx kind")
-		}
-
+		// Messages are verified by filter
 		var deserializedMessage fbft.Message
-		err := deserializedMessage.Deserialize(signTx.Payload.([]byte))
-		if err != nil {
-			return fbft.Message{}, err
-		}
-		if !deserializedMessage.Sign.VerifyHash(&deserializedMessage.Pubkey, b.Hash[:]) {
-			// TODO::handling when received invalidate prepare message
-			return fbft.Message{}, errors.New("Cannot verified validator prepare message")
-		}
+		deserializedMessage.Deserialize(signTx.Payload)
 
 		toSendMessage.Sign.Add(&deserializedMessage.Sign)
-		toSendMessage.Pubkey.Add(&deserializedMessage.Pubkey)
+		for i := range toSendMessage.Signers {
+			toSendMessage.Signers[i] |= deserializedMessage.Signers[i]
+		}
 	}
+	// Wall time measures CPU cost which virtual clock does not count
 	elapsedAggregationTime := time.Since(aggregationStartTime)
+	f.node.metrics.Observe(metrics.BLSAggregation, elapsedAggregationTime)
 
-	preparedLeaderTx := signature.New(f.node.id, signature.Prepared, b.Header.Height, toSendMessage.Serialize())
-	f.node.channel.sendSignature(preparedLeaderTx)
+	preparedLeaderTx := signature.NewVote(f.node.id, signature.Prepared, b.Header.Height, b.Header.Round, b.Hash[:], toSendMessage.Serialize())
+	f.node.sendSignature(preparedLeaderTx)
 	f.node.logger.Debug("Success BLS-Aggregation of Prepare Messages", "blockHeight", b.Header.Height, "elapsedAggregationTime", elapsedAggregationTime.String())
 	return toSendMessage, nil
 }
@@ -55,43 +80,37 @@ func (f *fridayFBFT) prepareLeaderPhase(b block.Block) (fbft.Message, error) {
 func (f *fridayFBFT) finalizeLeaderPhase(b block.Block, preparedMessage fbft.Message) ([]signature.Signature, error) {
 	f.node.logger.Debug("Enter finalizeLeaderPhase", "blockHeight", b.Header.Height)
 	//Commit Phase
-	toSendMessage := fbft.Message{}
+	toSendMessage := fbft.Message{Signers: fbft.NewSigners(len(f.node.addressbook))}
 
-	collectStartTime := time.Now()
+	collectStartTime := f.node.clock.Now()
 	// TODO::handling when timeout situation
-	receivedSignTxs := f.node.pool.waitAndRemove(signature.Commit, b.Header.Height, f.quorum())
-	elpasedReceiveTime := time.Since(collectStartTime)
+	compareHash := preparedMessage.Hash()
+	receivedSignTxs := f.node.pool.waitAndRemoveValid(signature.Commit, b.Header.Height, b.Header.Round, f.quorum(), f.validatorMessageFilter(b, compareHash[:]))
+	elpasedReceiveTime := f.node.clock.Now().Sub(collectStartTime)
 	if len(receivedSignTxs) < f.quorum() {
 		return []signature.Signature{}, errors.New("Cannot receive prepare messages more than quorum")
 	}
+	f.node.observeQuorumWait(signature.Commit, elpasedReceiveTime)
 
 	f.node.logger.Debug("Received commit Txs over then quorum", "blockHeight", b.Header.Height, "elpasedReceiveTime", elpasedReceiveTime.String())
 
 	aggregationStartTime := time.Now()
 	for _, signTx := range receivedSignTxs {
-		if signTx.Kind != signature.Commit {
-			return []signature.Signature{}, errors.New("Cannot matched Tx kind")
-		}
-
+		// Messages are verified by filter
 		var deserializedMessage fbft.Message
-		err := deserializedMessage.Deserialize(signTx.Payload.([]byte))
-		if err != nil {
-			return []signature.Signature{}, err
-		}
-
-		compareHash := preparedMessage.Hash()
-		if !deserializedMessage.Sign.VerifyHash(&deserializedMessage.Pubkey, compareHash[:]) {
-			// TODO::handling when received invalid commit message
-			return []signature.Signature{}, errors.New("Invalid validator commit message")
-		}
+		deserializedMessage.Deserialize(signTx.Payload)
 
 		toSendMessage.Sign.Add(&deserializedMessage.Sign)
-		toSendMessage.Pubkey.Add(&deserializedMessage.Pubkey)
+		for i := range toSendMessage.Signers {
+			toSendMessage.Signers[i] |= deserializedMessage.Signers[i]
+		}
 	}
+	// Wall time measures CPU cost which virtual clock does not count
 	elapsedAggregationTime := time.Since(aggregationStartTime)
+	f.node.metrics.Observe(metrics.BLSAggregation, elapsedAggregationTime)
 
-	commitedLeaderTx := signature.New(f.node.id, signature.Commited, b.Header.Height, toSendMessage.Serialize())
-	f.node.channel.sendSignature(commitedLeaderTx)
+	commitedLeaderTx := signature.NewVote(f.node.id, signature.Commited, b.Header.Height, b.Header.Round, compareHash[:], toSendMessage.Serialize())
+	f.node.sendSignature(commitedLeaderTx)
 	f.node.logger.Debug("Success BLS-Aggregation of commit messages", "blockHeight", b.Header.Height, "elapsedAggregationTime", elapsedAggregationTime.String())
 
 	return []signature.Signature{commitedLeaderTx}, nil
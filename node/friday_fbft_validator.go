@@ -8,21 +8,38 @@ import (
 	"github.com/hdac-io/simulator/signature"
 )
 
-func (f *fridayFBFT) prepareValidatorPhase(b block.Block) error {
-	//Prepare Phase - validate received block(announce), send prepare message
-	blockSign := f.node.blsSecretKey.SignHash(b.Hash[:])
-	if blockSign == nil {
-		return errors.New("Failed block bls signing")
+// validatorMessage returns vote function signing hash with validator's BLS key
+func (f *fridayFBFT) validatorMessage(kind signature.Kind, b block.Block) func(hash []byte) signature.Signature {
+	return func(hash []byte) signature.Signature {
+		toSendMessage := fbft.Message{
+			Sign:    *f.node.blsSecretKey.SignHash(hash),
+			Signers: f.signers(f.node.id),
+		}
+		return signature.NewVote(f.node.id, kind, b.Header.Height, b.Header.Round, hash, toSendMessage.Serialize())
 	}
+}
 
-	toSendPrepareMessage := fbft.Message{
-		Sign:   *blockSign,
-		Pubkey: *f.node.blsSecretKey.GetPublicKey(),
-	}
-	serializedMessage := toSendPrepareMessage.Serialize()
+// leaderMessageFilter accepts message aggregated on hash by quorum from the leader
+func (f *fridayFBFT) leaderMessageFilter(b block.Block, hash []byte) func(signature.Signature) bool {
+	return f.node.voteFilter(hash, b.Header.Producer, func(s signature.Signature, payload []byte, hash []byte) error {
+		if s.ID != b.Header.Producer {
+			return errors.New("Message from non-leader")
+		}
+
+		var deserializedMessage fbft.Message
+		if err := deserializedMessage.Deserialize(payload); err != nil {
+			return err
+		}
+		if err := f.verifyQuorum(deserializedMessage, hash); err != nil {
+			return errors.New("Invalid aggregated-bls on leader message")
+		}
+		return nil
+	})
+}
 
-	prepareTx := signature.New(f.node.id, signature.Prepare, b.Header.Height, serializedMessage)
-	f.node.channel.sendSignature(prepareTx)
+func (f *fridayFBFT) prepareValidatorPhase(b block.Block) error {
+	//Prepare Phase - validate received block(announce), send prepare message
+	f.node.sendVote(b.Hash[:], f.validatorMessage(signature.Prepare, b))
 	f.node.logger.Info("Send prepare messsage", "blockheight", b.Header.Height)
 
 	return nil
@@ -30,23 +47,21 @@ func (f *fridayFBFT) prepareValidatorPhase(b block.Block) error {
 
 func (f *fridayFBFT) onPreparedValidatorPhase(b block.Block) (fbft.Message, error) {
 	//OnPrepared Phase - wait leader bls-aggregated message
-	receivedTx := f.node.pool.waitAndRemove(signature.Prepared, b.Header.Height, 1)
+	collectStartTime := f.node.clock.Now()
+	receivedTx := f.node.pool.waitAndRemoveValid(signature.Prepared, b.Header.Height, b.Header.Round, 1, f.leaderMessageFilter(b, b.Hash[:]))
 	if len(receivedTx) != 1 {
 		return fbft.Message{}, errors.New("Cannot received leader prepared message")
 	}
+	f.node.observeQuorumWait(signature.Prepared, f.node.clock.Now().Sub(collectStartTime))
+	if !f.node.viewChange.lock(b) {
+		return fbft.Message{}, errors.New("Round of the block is skipped")
+	}
 
-	// TODO:: Add more leader message validate condition
-	// - check between known leader public key to received leader public key
-	// - check match between received public keys to known validator public keys
 	var deserializedMessage fbft.Message
-	err := deserializedMessage.Deserialize(receivedTx[0].Payload.([]byte))
+	err := deserializedMessage.Deserialize(receivedTx[0].Payload)
 	if err != nil {
 		return fbft.Message{}, err
 	}
-
-	if !deserializedMessage.Sign.VerifyHash(&deserializedMessage.Pubkey, b.Hash[:]) {
-		return fbft.Message{}, errors.New("Invalid Leader prepared message")
-	}
 	f.node.logger.Info("Received prepared leader message", "blockheight", b.Header.Height)
 	return deserializedMessage, nil
 }
@@ -54,17 +69,7 @@ func (f *fridayFBFT) onPreparedValidatorPhase(b block.Block) (fbft.Message, erro
 func (f *fridayFBFT) finalizeValidatorPhase(b block.Block, preparedMessage fbft.Message) error {
 	//Commit Phase - send commit message
 	messageHash := preparedMessage.Hash()
-	messageSign := f.node.blsSecretKey.SignHash(messageHash[:])
-	if messageSign == nil {
-		return errors.New("failed message bls signing")
-	}
-
-	toSendMessage := fbft.Message{
-		Sign:   *messageSign,
-		Pubkey: *f.node.blsSecretKey.GetPublicKey(),
-	}
-	commitTx := signature.New(f.node.id, signature.Commit, b.Header.Height, toSendMessage.Serialize())
-	f.node.channel.sendSignature(commitTx)
+	f.node.sendVote(messageHash[:], f.validatorMessage(signature.Commit, b))
 	f.node.logger.Info("send commit messsage", "blockheight", b.Header.Height)
 
 	return nil
@@ -72,24 +77,14 @@ func (f *fridayFBFT) finalizeValidatorPhase(b block.Block, preparedMessage fbft.
 
 func (f *fridayFBFT) onFinalizedValidatorPhase(b block.Block, preparedMessage fbft.Message) ([]signature.Signature, error) {
 	//OnCommited Phase -  Wait leader bls-aggregated message
-	receivedTx := f.node.pool.waitAndRemove(signature.Commited, b.Header.Height, 1)
+	messageHash := preparedMessage.Hash()
+	collectStartTime := f.node.clock.Now()
+	receivedTx := f.node.pool.waitAndRemoveValid(signature.Commited, b.Header.Height, b.Header.Round, 1, f.leaderMessageFilter(b, messageHash[:]))
 	if len(receivedTx) != 1 {
 		return []signature.Signature{}, errors.New("Cannot received leader commited message")
 	}
+	f.node.observeQuorumWait(signature.Commited, f.node.clock.Now().Sub(collectStartTime))
 
-	// TODO:: Add more leader message validate condition
-	// - check between known leader public key to received leader public key
-	// - check match between received public keys to known validator public keys
-	messageHash := preparedMessage.Hash()
-	var deserializedMessage fbft.Message
-	err := deserializedMessage.Deserialize(receivedTx[0].Payload.([]byte))
-	if err != nil {
-		return []signature.Signature{}, err
-	}
-
-	if !deserializedMessage.Sign.VerifyHash(&deserializedMessage.Pubkey, messageHash[:]) {
-		return []signature.Signature{}, errors.New("Invalid aggregated-bls on leader commited message")
-	}
 	f.node.logger.Info("Received commited leader message", "blockheight", b.Header.Height)
 	return receivedTx, nil
 }
@@ -1,15 +1,16 @@
 package node
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"time"
 
 	"github.com/hdac-io/simulator/block"
 	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/codec"
 	"github.com/hdac-io/simulator/signature"
 	"github.com/hdac-io/simulator/types"
-	"github.com/hdac-io/simulator/vrfmessage"
 )
 
 type fridayVRF struct {
@@ -22,16 +23,19 @@ func newFridayVRF(node *Node) consensus {
 
 func (f *fridayVRF) start(genesisTime time.Time) {
 	// Start producing loop
-	go f.produceLoop(genesisTime)
+	f.node.clock.Go(func() { f.produceLoop(genesisTime) })
 
 	// Start validating loop
-	go f.validationLoop()
+	f.node.clock.Go(f.validationLoop)
 }
 
 func (f *fridayVRF) produceLoop(genesisTime time.Time) {
-	nextBlockTime := genesisTime
+	nextBlockTime := f.node.nextBlockTime(genesisTime)
 	for {
-		time.Sleep(nextBlockTime.Sub(time.Now()))
+		f.node.clock.Sleep(nextBlockTime.Sub(f.node.clock.Now()))
+		if f.node.isStopped() {
+			return
+		}
 		nextBlockTime = f.produce(nextBlockTime)
 	}
 }
@@ -40,81 +44,39 @@ func (f *fridayVRF) validationLoop() {
 	if f.node.parameter.lenULB == 0 {
 		for {
 			block := f.node.channel.readBlock()
+			if f.node.isStopped() {
+				return
+			}
 			f.validateBlock(block)
 		}
 	} else {
 		for {
 			block := f.node.channel.readBlock()
-			go f.validateBlock(block)
+			if f.node.isStopped() {
+				return
+			}
+			f.node.clock.Go(func() { f.validateBlock(block) })
 		}
 	}
 }
 
-func (f *fridayVRF) getVRFMessage(blockHeight int) vrfmessage.VRFMessage {
-	var vrfMessage vrfmessage.VRFMessage
-
-	// Getting VRFMessage by previous block body
-	block, err := f.node.status.GetBlock(blockHeight)
-	if err == nil {
-		vrfMessage = block.VRF
-	} else {
-		panic("Block height index out of bound !")
-	}
-	return vrfMessage
-}
-
 func (f *fridayVRF) produce(nextBlockTime time.Time) time.Time {
-	var chosenNumber types.ID
-	if f.node.status.GetHeight() != 0 {
-		// Getting VRFMessage by previous block body
-		vrfMessage := f.getVRFMessage(f.node.status.GetHeight())
-
-		// Validate VRFMessage
-		// Bypass validate when produced genesis block
-		if vrfMessage.PreviousBlockHeight != 0 {
-			targetBlock, _ := f.node.status.GetBlock(vrfMessage.PreviousBlockHeight)
-			vrfErr := vrfMessage.Validate(targetBlock.Hash)
-			if vrfErr != nil {
-				f.node.logger.Crit(vrfErr.Error())
-				// TODO::replace to decide next action when invalid VRF situation
-				panic(vrfErr)
-			}
-		}
-
-		// Calculate BP ID by VRF
-		chosenNumber = vrfMessage.CalculateBPID(f.node.parameter.numValidators)
-	} else {
-		// TODO::FIXME refectoring to initializeGenesisBlock
-		// When firstly producing genesis block, cannot have previous block status
-		chosenNumber = types.ID(1)
-	}
+	height := f.node.status.GetHeight() + 1
+	round, propose := f.node.viewChange.tick(height)
 
-	// next := 0 if there is no completed block
-	f.node.next = chosenNumber
-
-	if f.node.next != f.node.id {
+	if !propose {
 		// Not my turn
 	} else {
 		// My turn
 
-		// Make VRFMessage
-		var vrf vrfmessage.VRFMessage
-		if f.node.status.GetHeight() != 0 {
-			// Make vrf by previous block
-			vrf = vrfmessage.New(f.node.privKey, f.node.pubKey, f.node.id, f.node.status.GetRecentBlock().Hash, f.node.status.GetHeight())
-		} else {
-			// TODO::FIXME refectoring to initializeGenesisBlock
-			// for producing genesis block
-			vrf = vrfmessage.New(f.node.privKey, f.node.pubKey, f.node.id, [32]byte{0}, 0)
-		}
-
 		// Produce new block
-		newBlock := block.New(f.node.status.GetHeight()+1, nextBlockTime.UnixNano(), f.node.id, vrf)
+		newBlock := f.node.newBlock(height, round, nextBlockTime)
 
 		// Pre-prepare / send new block
-		f.node.channel.sendBlock(newBlock)
-		f.node.logger.Info("Block produced", "Height", newBlock.Header.Height, "Producer", newBlock.Header.Producer,
-			"Timestmp", time.Unix(0, newBlock.Header.Timestamp), "Hash", hex.EncodeToString(newBlock.Hash[:]))
+		f.node.sendBlock(newBlock)
+		f.node.logger.Info("Block produced", "Height", newBlock.Header.Height, "Round", newBlock.Header.Round,
+			"Producer", newBlock.Header.Producer, "Timestmp", time.Unix(0, newBlock.Header.Timestamp),
+			"Transactions", len(newBlock.Transactions), "Hash", hex.EncodeToString(newBlock.Hash[:]))
 
 	}
 
@@ -124,87 +86,167 @@ func (f *fridayVRF) produce(nextBlockTime time.Time) time.Time {
 func (f *fridayVRF) validateBlock(b block.Block) {
 	// Validation
 	if err := f.validate(b); err != nil {
-		f.node.logger.Crit(err.Error())
-		panic("There shoud be no Byzantine nodes !")
-		//return
+		if err != errDuplicateBlock && err != errStaleRound && err != errStopped {
+			f.node.reportFault(b.Header.Producer, b.Header.Height, err)
+		}
+		return
 	}
 
 	f.node.logger.Info("Block received", "Height", b.Header.Height)
-	f.node.status.AppendBlock(b)
+	f.node.appendBlock(b)
 
 	// Prepare
-	f.prepare(b)
+	if !f.prepare(b) {
+		f.node.logger.Info("Block abandoned", "Height", b.Header.Height, "Round", b.Header.Round)
+		return
+	}
 	f.node.logger.Info("Block prepared", "Height", b.Header.Height)
 
 	// Commit / finalize
-	f.finalize(b)
+	if !f.finalize(b) {
+		f.node.logger.Info("Block abandoned", "Height", b.Header.Height, "Round", b.Header.Round)
+		return
+	}
 	f.node.logger.Info("Block finalized", "Height", b.Header.Height)
 }
 
-// FIXME: We assume that there is no byzantine nodes
 func (f *fridayVRF) validate(b block.Block) error {
-	// FIXME: we should wait next validator calculation
-	for f.node.next == 0 {
-		time.Sleep(10 * time.Millisecond)
+	// Wait for the round of the block and validate producer
+	if err := f.node.viewChange.waitRound(b); err != nil {
+		return err
 	}
 
-	// Validate producer
-	if f.node.next != b.Header.Producer {
-		return errors.New("Invalid producer")
+	// Validate block hash and body
+	if err := block.Validate(b); err != nil {
+		return err
 	}
 
-	// Validate block hash
-	if b.Hash != block.CalculateHashFromBlock(b) {
-		return errors.New("Invalid block hash")
+	// Validate transactions and parent
+	if err := f.node.validateTransactions(b); err != nil {
+		return err
+	}
+	if err := f.node.validateParent(b); err != nil {
+		return err
 	}
 
-	// FIXME: we should wait next validator calculation
-	f.node.next = 0
+	// Validate VRF proof
+	if err := f.node.validateVRF(b); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (f *fridayVRF) prepare(b block.Block) {
-	blsSign := f.node.blsSecretKey.SignHash(b.Hash[:])
-	sign := signature.New(f.node.id, signature.Prepare, b.Header.Height, blsSign.Serialize())
+func (f *fridayVRF) quorum() int {
+	return f.node.parameter.numValidators*2/3 + 1
+}
+
+func (f *fridayVRF) vote(kind signature.Kind, b block.Block) func(hash []byte) signature.Signature {
+	return func(hash []byte) signature.Signature {
+		digest := voteHash(kind, b.Header.Height, b.Header.Round, hash)
+		blsSign := f.node.blsSecretKey.SignHash(digest[:])
+		return signature.NewVote(f.node.id, kind, b.Header.Height, b.Header.Round, hash, blsSign.Serialize())
+	}
+}
 
+// voteHash returns digest signed by vote of the kind on block hash, so that
+// vote of a kind or a round cannot be taken as another
+func voteHash(kind signature.Kind, height int, round int, hash []byte) [32]byte {
+	return sha256.Sum256(codec.EncodeList(codec.EncodeString("vote"), codec.EncodeInt(int64(kind)), codec.EncodeInt(int64(height)), codec.EncodeInt(int64(round)), codec.EncodeBytes(hash)))
+}
+
+// prepare returns false if the round of the block is skipped before prepared
+func (f *fridayVRF) prepare(b block.Block) bool {
 	// Send piece to others
-	f.node.channel.sendSignature(sign)
+	f.node.sendVote(b.Hash[:], f.vote(signature.Prepare, b))
 
 	// Collect signatures
-	f.collectSignatures(signature.Prepare, b)
+	if len(f.collectSignatures(signature.Prepare, b)) < f.quorum() {
+		return false
+	}
+	return f.node.viewChange.lock(b)
 }
 
-func (f *fridayVRF) finalize(b block.Block) {
-	// Generate random signature
-	blsSign := f.node.blsSecretKey.SignHash(b.Hash[:])
-	sign := signature.New(f.node.id, signature.Commit, b.Header.Height, blsSign.Serialize())
-
+// finalize returns false if the block is finalized by sync or the validator is stopped
+func (f *fridayVRF) finalize(b block.Block) bool {
 	// Send piece to others
-	f.node.channel.sendSignature(sign)
+	f.node.sendVote(b.Hash[:], f.vote(signature.Commit, b))
 
 	// Collect signatures
 	signs := f.collectSignatures(signature.Commit, b)
+	if len(signs) < f.quorum() {
+		return false
+	}
 
 	// Finalize
-	f.node.status.Finalize(b, signs)
+	f.node.finalizeBlock(b, signs)
+	return true
 }
 
+// collectSignatures waits for valid signatures from quorum of validators,
+// fewer signatures are returned if the round is skipped
 func (f *fridayVRF) collectSignatures(kind signature.Kind, b block.Block) []signature.Signature {
-	signs := f.node.pool.waitAndRemove(kind, b.Header.Height, f.node.parameter.numValidators)
-	for _, s := range signs {
-		id := s.ID
+	filter := f.node.voteFilter(b.Hash[:], b.Header.Producer, func(s signature.Signature, payload []byte, hash []byte) error {
 		// FIXME
 		pubkey := bls.PublicKey{}
-		pubkey.DeserializeHexStr(f.node.addressbook[id].PublicKey)
+		if err := pubkey.DeserializeHexStr(f.node.addressbook[s.ID].PublicKey); err != nil {
+			return err
+		}
 
 		blsSign := bls.Sign{}
-		payload := s.Payload.([]byte)
-		blsSign.Deserialize(payload)
-		if !blsSign.VerifyHash(&pubkey, b.Hash[:]) {
-			panic("There should be no Byzantine nodes !")
+		if err := blsSign.Deserialize(payload); err != nil {
+			return err
+		}
+		digest := voteHash(kind, b.Header.Height, b.Header.Round, hash)
+		if !blsSign.VerifyHash(&pubkey, digest[:]) {
+			return errors.New("Invalid signature")
 		}
+		return nil
+	})
+
+	collectStartTime := f.node.clock.Now()
+	signs := f.node.pool.waitAndRemoveValid(kind, b.Header.Height, b.Header.Round, f.quorum(), filter)
+	if len(signs) >= f.quorum() {
+		f.node.observeQuorumWait(kind, f.node.clock.Now().Sub(collectStartTime))
 	}
 
 	return signs
 }
+
+// verifyFinalization checks that signs are commit votes of quorum on the block
+// by verifying their aggregated BLS signature
+func (f *fridayVRF) verifyFinalization(b block.Block, signs []signature.Signature) error {
+	signers := make(map[types.ID]bool)
+	var aggregatedSign bls.Sign
+	var aggregatedKey bls.PublicKey
+	for _, s := range signs {
+		if s.Kind != signature.Commit || s.BlockHeight != b.Header.Height || s.Round != b.Header.Round || signers[s.ID] {
+			return errors.New("Invalid finalization signature")
+		}
+		address, exist := f.node.addressbook[s.ID]
+		if !exist {
+			return errors.New("Unknown validator")
+		}
+
+		pubkey := bls.PublicKey{}
+		if err := pubkey.DeserializeHexStr(address.PublicKey); err != nil {
+			return err
+		}
+		blsSign := bls.Sign{}
+		if err := blsSign.Deserialize(s.Payload); err != nil {
+			return err
+		}
+		aggregatedSign.Add(&blsSign)
+		aggregatedKey.Add(&pubkey)
+		signers[s.ID] = true
+	}
+
+	if len(signers) < f.quorum() {
+		return errors.New("Finalization signatures less than quorum")
+	}
+	digest := voteHash(signature.Commit, b.Header.Height, b.Header.Round, b.Hash[:])
+	if !aggregatedSign.VerifyHash(&aggregatedKey, digest[:]) {
+		return errors.New("Invalid aggregated signature")
+	}
+	return nil
+}
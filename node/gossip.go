@@ -0,0 +1,221 @@
+package node
+
+import (
+	"crypto/sha256"
+	"math/rand"
+	"time"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/wire"
+)
+
+// gossip carries a message relayed from peer to peer. The message is known
+// by hash of its frame so that each node handles and relays it once.
+type gossip struct {
+	// Remaining hops of the message
+	TTL int
+	// Wire frame of the message
+	Frame []byte
+}
+
+func init() {
+	wire.Register(wire.TypeGossip, "gossip", gossip{}, func(item codec.Item) (net.Load, error) {
+		return decodeGossip(item)
+	})
+}
+
+// Encode returns canonical encoding of the gossip
+func (g gossip) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeInt(int64(g.TTL)),
+		codec.EncodeBytes(g.Frame),
+	)
+}
+
+func decodeGossip(item codec.Item) (gossip, error) {
+	d := codec.NewDecoder(item, 2)
+	g := gossip{
+		TTL:   int(d.Int()),
+		Frame: d.Bytes(),
+	}
+	return g, d.Err()
+}
+
+// seenCache remembers hashes of recent messages, the oldest is forgotten
+// when it is full
+type seenCache struct {
+	hashes map[[32]byte]struct{}
+	order  [][32]byte
+	next   int
+}
+
+func newSeenCache(size int) *seenCache {
+	return &seenCache{
+		hashes: make(map[[32]byte]struct{}, size),
+		order:  make([][32]byte, 0, size),
+	}
+}
+
+// add remembers the hash and returns false if it is already seen
+func (s *seenCache) add(hash [32]byte) bool {
+	if _, exist := s.hashes[hash]; exist {
+		return false
+	}
+	if len(s.order) < cap(s.order) {
+		s.order = append(s.order, hash)
+	} else {
+		delete(s.hashes, s.order[s.next])
+		s.order[s.next] = hash
+		s.next = (s.next + 1) % len(s.order)
+	}
+	s.hashes[hash] = struct{}{}
+	return true
+}
+
+// enableGossip disseminates blocks, signatures and transactions by relaying
+// them to fanout random peers at most ttl hops instead of sending to every peer
+func (c *channel) enableGossip(fanout int, ttl int, cacheSize int, seed int64) {
+	if cacheSize <= 0 {
+		panic("Invalid gossip cache size !")
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.fanout = fanout
+	c.ttl = ttl
+	c.seen = newSeenCache(cacheSize)
+	c.random = rand.New(rand.NewSource(seed + int64(c.id)))
+}
+
+// gossiping reports whether gossip is enabled
+func (c *channel) gossiping() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.seen != nil
+}
+
+// gossip starts dissemination of the load, it is handled by this node
+// through loopback unless it is a transaction
+func (c *channel) gossip(load net.Load) {
+	frame, err := wire.Encode(load)
+	if err != nil {
+		panic(err)
+	}
+	c.Lock()
+	c.seen.add(sha256.Sum256(frame))
+	ttl := c.ttl
+	c.Unlock()
+
+	if _, ok := load.(block.Transaction); !ok {
+		for _, p := range c.getPeers() {
+			if p.loopback {
+				c.write(p, load)
+			}
+		}
+	}
+	c.relay(nil, gossip{TTL: ttl, Frame: frame})
+}
+
+// relay sends gossip to fanout random peers other than loopback and sender
+func (c *channel) relay(sender *peer, g gossip) {
+	remotes := make([]*peer, 0)
+	for _, p := range c.getPeers() {
+		if !p.loopback && p != sender {
+			remotes = append(remotes, p)
+		}
+	}
+
+	c.Lock()
+	c.random.Shuffle(len(remotes), func(i, j int) { remotes[i], remotes[j] = remotes[j], remotes[i] })
+	if len(remotes) > c.fanout {
+		remotes = remotes[:c.fanout]
+	}
+	c.Unlock()
+
+	for _, p := range remotes {
+		c.write(p, g)
+	}
+}
+
+// handleGossip handles message relayed by the peer for the first time and
+// relays it further while its TTL lasts
+func (c *channel) handleGossip(p *peer, load net.Load) {
+	g := load.(gossip)
+	hash := sha256.Sum256(g.Frame)
+	c.Lock()
+	enabled := c.seen != nil
+	fresh := enabled && c.seen.add(hash)
+	c.Unlock()
+	if !enabled {
+		return
+	}
+	if !fresh {
+		c.metrics.Add(metrics.GossipDuplicates, 1)
+		return
+	}
+
+	message, err := wire.Decode(g.Frame)
+	if err != nil {
+		return
+	}
+	if g.TTL > 1 {
+		c.relay(p, gossip{TTL: g.TTL - 1, Frame: g.Frame})
+	}
+	switch m := message.(type) {
+	case block.Block:
+		c.metrics.Observe(metrics.BlockPropagation, c.clock.Now().Sub(time.Unix(0, m.Header.Timestamp)))
+		c.handleBlock(p, m)
+	case signature.Signature:
+		// Relayed signature is not from the peer, its origin is verified by its BLS signature
+		c.clock.Send(c.signature, m)
+	case block.Transaction:
+		c.handleTransaction(p, m)
+	}
+}
+
+// neighbors returns validators linked to the validator in network where
+// validators have degree peers on average. A ring in ID order keeps the
+// network connected and the other links are drawn from the seed.
+func (a Addressbook) neighbors(id types.ID, degree int, seed int64) map[types.ID]bool {
+	ids := a.IDs()
+	maxLinks := len(ids) * (len(ids) - 1) / 2
+	numLinks := len(ids) * degree / 2
+	if numLinks > maxLinks {
+		numLinks = maxLinks
+	}
+
+	type link struct{ low, high types.ID }
+	links := make(map[link]bool)
+	add := func(x types.ID, y types.ID) {
+		if x > y {
+			x, y = y, x
+		}
+		links[link{x, y}] = true
+	}
+	for i := 0; i < len(ids) && len(links) < numLinks; i++ {
+		if ids[i] != ids[(i+1)%len(ids)] {
+			add(ids[i], ids[(i+1)%len(ids)])
+		}
+	}
+	random := rand.New(rand.NewSource(seed))
+	for len(links) < numLinks {
+		x, y := ids[random.Intn(len(ids))], ids[random.Intn(len(ids))]
+		if x != y {
+			add(x, y)
+		}
+	}
+
+	neighbors := make(map[types.ID]bool)
+	for l := range links {
+		if l.low == id {
+			neighbors[l.high] = true
+		} else if l.high == id {
+			neighbors[l.low] = true
+		}
+	}
+	return neighbors
+}
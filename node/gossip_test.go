@@ -0,0 +1,45 @@
+package node
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/hdac-io/simulator/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeighbors(t *testing.T) {
+	addressbook := GenerateAddressbook(50, 1)
+	links := 0
+	reached := map[types.ID]bool{1: true}
+	queue := []types.ID{1}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		neighbors := addressbook.neighbors(id, 6, 1)
+		links += len(neighbors)
+		for neighbor := range neighbors {
+			// Links are symmetric
+			require.True(t, addressbook.neighbors(neighbor, 6, 1)[id])
+			if !reached[neighbor] {
+				reached[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	// Every validator is reachable with degree peers on average
+	require.Equal(t, 50, len(reached))
+	require.Equal(t, 50*6, links)
+}
+
+func TestSeenCache(t *testing.T) {
+	hash := func(i byte) [32]byte { return sha256.Sum256([]byte{i}) }
+	s := newSeenCache(2)
+	require.True(t, s.add(hash(1)))
+	require.False(t, s.add(hash(1)))
+	require.True(t, s.add(hash(2)))
+	// The oldest is forgotten
+	require.True(t, s.add(hash(3)))
+	require.True(t, s.add(hash(1)))
+	require.False(t, s.add(hash(3)))
+}
@@ -0,0 +1,201 @@
+package node
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"time"
+
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/wire"
+)
+
+// Handshake is resent at the interval until the peer is authenticated,
+// connection is closed after the attempts
+const (
+	handshakeInterval = time.Second
+	handshakeAttempts = 10
+)
+
+// handshake authenticates the peer of a connection. Both sides send their ID,
+// protocol version and a random challenge, then prove possession of BLS key
+// of the ID in addressbook by signing the challenge of the other side.
+type handshake struct {
+	Version   int
+	ID        types.ID
+	Challenge []byte
+	// BLS signature of the challenge of the receiver, empty until it is received
+	Proof []byte
+	// Whether the sender has authenticated the receiver
+	Authenticated bool
+}
+
+func init() {
+	wire.Register(wire.TypeHandshake, "handshake", handshake{}, func(item codec.Item) (net.Load, error) {
+		return decodeHandshake(item)
+	})
+}
+
+// Encode returns canonical encoding of the handshake
+func (h handshake) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeInt(int64(h.Version)),
+		codec.EncodeInt(int64(h.ID)),
+		codec.EncodeBytes(h.Challenge),
+		codec.EncodeBytes(h.Proof),
+		codec.EncodeBool(h.Authenticated),
+	)
+}
+
+func decodeHandshake(item codec.Item) (handshake, error) {
+	d := codec.NewDecoder(item, 5)
+	h := handshake{
+		Version:       int(d.Int()),
+		ID:            types.ID(d.Int()),
+		Challenge:     d.Bytes(),
+		Proof:         d.Bytes(),
+		Authenticated: d.Bool(),
+	}
+	return h, d.Err()
+}
+
+// handshakeHash returns hash of the challenge signed by signer for receiver
+func handshakeHash(challenge []byte, signer types.ID, receiver types.ID) [32]byte {
+	return sha256.Sum256(codec.EncodeList(
+		codec.EncodeString("handshake"),
+		codec.EncodeBytes(challenge),
+		codec.EncodeInt(int64(signer)),
+		codec.EncodeInt(int64(receiver)),
+	))
+}
+
+// startHandshake sends handshake to the peer until the peer is authenticated
+func (c *channel) startHandshake(p *peer) {
+	p.challenge = make([]byte, 32)
+	if _, err := rand.Read(p.challenge); err != nil {
+		panic(err)
+	}
+
+	c.clock.Go(func() {
+		for i := 0; i < handshakeAttempts; i++ {
+			c.Lock()
+			done := p.authenticated || p.closed
+			c.Unlock()
+			if done {
+				return
+			}
+			c.sendHandshake(p)
+			c.clock.Sleep(handshakeInterval)
+		}
+
+		c.Lock()
+		authenticated := p.authenticated
+		c.Unlock()
+		if !authenticated {
+			c.reject(p)
+		}
+	})
+}
+
+// reject closes connection of the peer failed handshake, loads still
+// delivered by the connection are dropped
+func (c *channel) reject(p *peer) {
+	c.Lock()
+	rejected := !p.closed
+	p.closed = true
+	c.handshaked.Broadcast()
+	c.Unlock()
+
+	if rejected {
+		c.metrics.Add(metrics.HandshakeFailures, 1)
+		p.connection.Close()
+	}
+}
+
+// sendHandshake sends handshake with proof of the challenge of the peer if it is known
+func (c *channel) sendHandshake(p *peer) {
+	c.Lock()
+	h := handshake{
+		Version:       wire.Version,
+		ID:            c.id,
+		Challenge:     p.challenge,
+		Authenticated: p.authenticated,
+	}
+	if p.peerChallenge != nil {
+		hash := handshakeHash(p.peerChallenge, c.id, p.claimed)
+		sign := c.secretKey.SignHash(hash[:])
+		h.Proof = sign.Serialize()
+	}
+	c.Unlock()
+
+	c.write(p, h)
+}
+
+// handleHandshake authenticates the peer by its handshake, connection of
+// unknown or impersonating peer is closed
+func (c *channel) handleHandshake(p *peer, load net.Load) {
+	h := load.(handshake)
+	authenticated, err := c.verifyHandshake(p, h)
+	if err != nil {
+		c.reject(p)
+		return
+	}
+	if authenticated {
+		if !c.register(p) {
+			// Duplicate connection is closed
+			return
+		}
+		c.logger.Debug("Peer connected", "Peer", p.id, "Outbound", p.outbound)
+	}
+	if !h.Authenticated {
+		// The peer waits proof of this side
+		c.sendHandshake(p)
+	}
+}
+
+// verifyHandshake checks handshake of the peer and returns whether the peer
+// is authenticated by the handshake for the first time
+func (c *channel) verifyHandshake(p *peer, h handshake) (bool, error) {
+	if h.Version != wire.Version {
+		return false, wire.ErrVersion
+	}
+	address, exist := c.addressbook[h.ID]
+	if !exist || h.ID == c.id {
+		return false, errors.New("Unknown validator")
+	}
+	if len(h.Challenge) == 0 {
+		return false, errors.New("Empty challenge")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if p.claimed != 0 && p.claimed != h.ID {
+		return false, errors.New("Changed peer ID")
+	}
+	p.claimed = h.ID
+	p.peerChallenge = h.Challenge
+	if len(h.Proof) == 0 || p.authenticated {
+		return false, nil
+	}
+
+	pubkey := bls.PublicKey{}
+	if err := pubkey.DeserializeHexStr(address.PublicKey); err != nil {
+		return false, err
+	}
+	sign := bls.Sign{}
+	if err := sign.Deserialize(h.Proof); err != nil {
+		return false, err
+	}
+	hash := handshakeHash(p.challenge, h.ID, c.id)
+	if !sign.VerifyHash(&pubkey, hash[:]) {
+		return false, errors.New("Invalid handshake proof")
+	}
+	p.id = h.ID
+	p.authenticated = true
+	c.handshaked.Broadcast()
+	return true, nil
+}
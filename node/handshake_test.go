@@ -0,0 +1,49 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/types"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandshake(t *testing.T) {
+	c := clock.NewVirtual(time.Unix(0, 0))
+	addressbook := GenerateAddressbook(3, 1)
+	simulation := net.NewSimulation(c, 1, net.DelaySpec{Distribution: net.Constant, Delay: 10 * time.Millisecond})
+	newTestChannel := func(id types.ID, keyOf types.ID, address net.Address) *channel {
+		var secret bls.SecretKey
+		secret.DeserializeHexStr(addressbook[keyOf].Secret)
+		return newChannel(id, addressbook, secret, simulation.Listen(address), c, log.New(), metrics.NewRegistry())
+	}
+	first := newTestChannel(1, 1, addressbook[1].Address)
+	second := newTestChannel(2, 2, addressbook[2].Address)
+	// Validator 3 claims ID of validator 2
+	impostor := newTestChannel(2, 3, addressbook[3].Address)
+
+	c.Go(func() {
+		p := impostor.connectToPeer(addressbook[1])
+		impostor.Lock()
+		for !p.closed {
+			impostor.handshaked.Wait()
+		}
+		impostor.Unlock()
+		// The impostor gives up instead of redialing
+		impostor.close()
+	})
+	c.Go(func() { second.connectToPeer(addressbook[1]) })
+	c.Run(5 * time.Second)
+
+	// Peers are keyed by validated ID and the impostor is rejected
+	require.Equal(t, 1, len(first.getPeers()))
+	require.Equal(t, types.ID(2), first.getPeers()[0].id)
+	require.Equal(t, second.id, first.peers[2].id)
+	require.Equal(t, types.ID(1), second.getPeers()[0].id)
+	require.Equal(t, int64(1), first.metrics.Snapshot().Counters[metrics.HandshakeFailures])
+}
@@ -0,0 +1,73 @@
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/google/keytransparency/core/crypto/vrf"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/keystore"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+)
+
+// LoadAddressbook reads public addressbook of keystore directory, secrets
+// are read by each validator from its keystore file
+func LoadAddressbook(dir string) (Addressbook, error) {
+	entries, err := keystore.LoadAddressbook(dir)
+	if err != nil {
+		return nil, err
+	}
+	addressbook := make(Addressbook)
+	for _, entry := range entries {
+		if _, exist := addressbook[entry.ID]; exist || entry.ID < 1 {
+			return nil, errors.New("Invalid validator ID in addressbook")
+		}
+		addressbook.Add(entry.ID, entry.Address, "", entry.BLSPublicKey)
+	}
+	for i := range addressbook.IDs() {
+		if _, exist := addressbook[types.ID(i+1)]; !exist {
+			return nil, errors.New("Validator IDs of addressbook must be from 1 without gaps")
+		}
+	}
+	return addressbook, nil
+}
+
+// validatorKey returns key of the validator read from keystore if it is
+// configured, otherwise BLS secret of addressbook without VRF secret. Public
+// key derived from the BLS secret must be the key of addressbook.
+func validatorKey(id types.ID, addressbook Addressbook, config *config.Config) (keystore.Key, error) {
+	address, exist := addressbook[id]
+	if !exist {
+		return keystore.Key{}, errors.New("Unknown validator")
+	}
+	key := keystore.Key{ID: id, BLSSecret: address.Secret, BLSPublicKey: address.PublicKey}
+	if config.Keystore.Dir != "" {
+		var err error
+		if key, err = keystore.Load(config.Keystore.Dir, id, config.Keystore.Passphrase); err != nil {
+			return keystore.Key{}, err
+		}
+	}
+
+	var secret bls.SecretKey
+	if err := secret.DeserializeHexStr(key.BLSSecret); err != nil {
+		return keystore.Key{}, err
+	}
+	var publicKey bls.PublicKey
+	if err := publicKey.DeserializeHexStr(address.PublicKey); err != nil {
+		return keystore.Key{}, err
+	}
+	if !secret.GetPublicKey().IsEqual(&publicKey) {
+		return keystore.Key{}, errors.New("BLS secret does not match addressbook")
+	}
+	return key, nil
+}
+
+func decodeVRFKey(secret string) (vrf.PrivateKey, vrf.PublicKey, error) {
+	data, err := hex.DecodeString(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vrfmessage.DecodePrivateKey(data)
+}
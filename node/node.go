@@ -1,15 +1,30 @@
 package node
 
 import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/google/keytransparency/core/crypto/vrf"
 	"github.com/google/keytransparency/core/crypto/vrf/p256"
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
 	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/load"
+	"github.com/hdac-io/simulator/mempool"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/net"
 	"github.com/hdac-io/simulator/node/status"
 	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/signature"
 	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
 	log "github.com/inconshreveable/log15"
 )
 
@@ -24,7 +39,9 @@ type Node struct {
 	// Validator data
 	id        types.ID
 	validator bool
-	next      types.ID
+
+	// Round of the height being decided
+	viewChange *viewChange
 
 	// Address book
 	addressbook Addressbook
@@ -35,21 +52,46 @@ type Node struct {
 	// Status
 	status *status.Status
 
-	// Transaction pool
+	// Vote pool
 	pool *signaturepool
 
+	// Pending transactions
+	mempool *mempool.Mempool
+
+	// Synthetic load of the validator, nil without load
+	generator *load.Generator
+
 	// Persistent
 	persistent persistent.Persistent
 
 	// Logger
 	logger log.Logger
 
-	// VRF Key Pair
-	privKey vrf.PrivateKey
-	pubKey  vrf.PublicKey
+	// Clock
+	clock clock.Clock
+
+	// Metrics
+	metrics *metrics.Registry
+
+	// VRF Key Pair, the secret is given by keystore
+	privKey   vrf.PrivateKey
+	pubKey    vrf.PublicKey
+	vrfSecret string
 
 	// BLS secret
 	blsSecretKey bls.SecretKey
+
+	// Injected byzantine behavior, nil for honest node
+	byzantine *byzantine
+
+	config *config.Config
+
+	// Closed when the validator is stopped
+	quit chan struct{}
+	stop sync.Once
+
+	// Highest block appended by sync without finalization, accessed by sync only
+	syncedHeight int
 }
 
 type parameter struct {
@@ -58,79 +100,294 @@ type parameter struct {
 	blockTime     time.Duration
 }
 
-// New constructs node
-func New(id types.ID, addressbook Addressbook, lenULB int) *Node {
+// New constructs node communicating through transport and driven by clock
+func New(id types.ID, addressbook Addressbook, config *config.Config, transport net.Transport, clock clock.Clock) *Node {
+	return newNode(id, addressbook, config, transport, clock, metrics.NewRegistry(), openPersistent(id, config))
+}
+
+func newNode(id types.ID, addressbook Addressbook, config *config.Config, transport net.Transport, clock clock.Clock,
+	registry *metrics.Registry, persistent persistent.Persistent) *Node {
 	parameter := parameter{
 		numValidators: len(addressbook),
-		lenULB:        lenULB,
+		lenULB:        config.Consensus.LenULB,
 	}
 
 	n := &Node{
 		id:          id,
 		addressbook: addressbook,
-		channel:     newChannel(addressbook[id]),
 		parameter:   parameter,
-		persistent:  persistent.New(),
-		pool:        newSignaturePool(),
+		persistent:  persistent,
+		pool:        newSignaturePool(clock),
+		mempool:     mempool.New(config.Mempool.Size),
 		logger:      log.New("Validator", id),
+		clock:       clock,
+		metrics:     registry,
+		config:      config,
+		quit:        make(chan struct{}),
+	}
+
+	// Initialize BLS secret, the key also authenticates the node to peers
+	n.logger.Info("Initialize BLS key", "Keystore", config.Keystore.Dir)
+	key, err := validatorKey(id, addressbook, config)
+	if err != nil {
+		panic(err)
+	}
+	if err := n.blsSecretKey.DeserializeHexStr(key.BLSSecret); err != nil {
+		panic(err)
+	}
+	n.vrfSecret = key.VRFSecret
+	n.channel = newChannel(id, addressbook, n.blsSecretKey, transport, clock, n.logger, registry)
+	if config.Network.Degree > 0 {
+		n.channel.neighbors = addressbook.neighbors(id, config.Network.Degree, config.Simulation.Seed)
+	}
+	if config.Gossip.Fanout > 0 {
+		n.channel.enableGossip(config.Gossip.Fanout, config.Gossip.TTL, config.Gossip.CacheSize, config.Simulation.Seed)
+	}
+
+	application, err := app.New(config.App.Name)
+	if err != nil {
+		panic("Unknown application !")
+	}
+	n.status = status.New(int64(id), len(addressbook), config.Consensus.LenULB, n.persistent, application, n.logger, clock, registry)
+	registry.Gauge(metrics.Height, func() float64 { return float64(n.status.GetHeight()) })
+	registry.Gauge(metrics.FinalizedHeight, func() float64 { return float64(n.status.GetFinalizedHeight()) })
+	registry.Gauge(metrics.ConfirmedHeight, func() float64 { return float64(n.status.GetConfirmedHeight()) })
+	registry.Gauge(metrics.MempoolSize, func() float64 { return float64(n.mempool.Size()) })
+	registry.Gauge(metrics.Peers, func() float64 { return float64(n.channel.peerCount()) })
+	n.viewChange = newViewChange(n, config.Consensus.RoundTimeout)
+	newConsensus, exist := engines[config.Consensus.Algorithm]
+	if !exist {
+		panic("Unknown consensus engine !")
 	}
-	n.status = status.New(int64(id), len(addressbook), n.logger)
-	// FIXME: configurable
-	n.consensus = newFridayVRF(n)
+	n.consensus = newConsensus(n)
 
 	return n
 }
 
+// openPersistent opens block store of the validator configured by config
+func openPersistent(id types.ID, config *config.Config) persistent.Persistent {
+	if config.Storage.Path == "" {
+		return persistent.New()
+	}
+	if err := os.MkdirAll(config.Storage.Path, 0755); err != nil {
+		panic(err)
+	}
+	p, err := persistent.Open(storePath(id, config))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// storePath returns path of block store of the validator
+func storePath(id types.ID, config *config.Config) string {
+	return filepath.Join(config.Storage.Path, fmt.Sprintf("validator-%d.db", id))
+}
+
 // NewValidator constructs validator node
-func NewValidator(id types.ID, addressbook Addressbook, lenULB int, blockTime time.Duration) *Node {
-	n := New(id, addressbook, lenULB)
+func NewValidator(id types.ID, addressbook Addressbook, config *config.Config, transport net.Transport, clock clock.Clock) *Node {
+	n := New(id, addressbook, config, transport, clock)
 	n.validator = true
-	n.parameter.blockTime = blockTime
+	n.parameter.blockTime = config.Consensus.BlockTime
 
 	// Initailze VRF key pair
 	n.logger.Info("Initialize VRF key")
-	n.privKey, n.pubKey = p256.GenerateKey()
+	if n.vrfSecret != "" {
+		var err error
+		if n.privKey, n.pubKey, err = decodeVRFKey(n.vrfSecret); err != nil {
+			panic(err)
+		}
+	} else if config.Simulation.Virtual {
+		// Deterministic key for reproducible run
+		n.privKey, n.pubKey = vrfmessage.GenerateKey(rand.New(rand.NewSource(config.Simulation.Seed + int64(id))))
+	} else {
+		n.privKey, n.pubKey = p256.GenerateKey()
+	}
+
+	// Initialize synthetic load
+	if config.Mempool.Load.Rate > 0 {
+		n.generator = config.Mempool.Load.New(id, rand.New(rand.NewSource(config.Simulation.Seed+int64(id))))
+	}
 
-	// Initialize BLS secret
-	n.logger.Info("Initialize BLS key")
-	n.blsSecretKey.DeserializeHexStr(addressbook[id].Secret)
+	// Inject byzantine behavior
+	if faults, exist := config.Byzantine.Faults[id]; exist {
+		n.logger.Warn("Byzantine validator", "Faults", faults)
+		n.byzantine = newByzantine(faults, config.Simulation.Seed+int64(id))
+	}
 
 	return n
 }
 
-func (n *Node) prepare() bool {
+// ID returns validator ID of the node
+func (n *Node) ID() types.ID {
+	return n.id
+}
+
+// Metrics returns metrics registry of the node
+func (n *Node) Metrics() *metrics.Registry {
+	return n.metrics
+}
+
+// FinalizedHeight returns height of the last finalized block
+func (n *Node) FinalizedHeight() int {
+	return n.status.GetFinalizedHeight()
+}
+
+// FinalizedBlock returns finalized block at the height
+func (n *Node) FinalizedBlock(height int) (block.Block, error) {
+	if height < 1 || height > n.status.GetFinalizedHeight() {
+		return block.Block{}, errors.New("Block is not finalized")
+	}
+	return n.status.GetBlock(height)
+}
+
+// observeQuorumWait records time waiting for quorum of votes
+func (n *Node) observeQuorumWait(kind signature.Kind, elapsed time.Duration) {
+	switch kind {
+	case signature.Prepare, signature.Prepared:
+		n.metrics.Observe(metrics.PrepareQuorumWait, elapsed)
+	case signature.Commit, signature.Commited:
+		n.metrics.Observe(metrics.CommitQuorumWait, elapsed)
+	}
+}
+
+func (n *Node) prepare(late bool) bool {
 	// Add known peers
-	n.channel.addKnownPeers(n.addressbook)
+	n.channel.addKnownPeers(n.addressbook, late)
 
 	return true
 }
 
-// Start starts validator with genesis time
+// Start starts validator with genesis time, validator started after genesis
+// by restart or late join starts consensus after catching up on blocks
 func (n *Node) Start(genesisTime time.Time, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	// Prepare peer-to-peer network, 4 seconds before genesis time
-	time.Sleep(genesisTime.Add(-4 * time.Second).Sub(time.Now()))
-	if !n.prepare() {
-		panic("Initialization failed !")
-	}
+	// Answer block requests of peers
+	n.clock.Go(n.serveSyncLoop)
+
+	// Collect transactions of peers
+	n.clock.Go(n.transactionLoop)
 
-	// Wait for genesis time
-	time.Sleep(genesisTime.Sub(time.Now()))
+	if n.clock.Now().After(genesisTime) {
+		n.rejoin(genesisTime)
+	} else {
+		// Prepare peer-to-peer network, 4 seconds before genesis time
+		n.clock.Sleep(genesisTime.Add(-4 * time.Second).Sub(n.clock.Now()))
+		if !n.prepare(false) {
+			panic("Initialization failed !")
+		}
 
-	n.consensus.start(genesisTime)
+		// Wait for genesis time
+		n.clock.Sleep(genesisTime.Sub(n.clock.Now()))
+
+		n.consensus.start(genesisTime)
+		n.clock.Go(n.syncLoop)
+		n.startLoad()
+	}
 
 	// Start receiving loop
 	n.receiveLoop()
 }
 
+// rejoin connects validator started after genesis and starts consensus in
+// background after catching up, votes are received meanwhile
+func (n *Node) rejoin(genesisTime time.Time) {
+	startTime := n.clock.Now()
+	if !n.prepare(true) {
+		panic("Initialization failed !")
+	}
+
+	n.clock.Go(func() {
+		n.catchUp()
+		if n.isStopped() {
+			return
+		}
+
+		recoveryTime := n.clock.Now().Sub(startTime)
+		n.metrics.Observe(metrics.RecoveryTime, recoveryTime)
+		n.logger.Info("Validator rejoined", "Height", n.status.GetHeight(), "Recovery time", recoveryTime)
+		n.consensus.start(genesisTime)
+		n.startLoad()
+
+		// Blocks not finalized when caught up are finalized by sync
+		n.syncLoop()
+	})
+}
+
+// nextBlockTime returns the first block time from now
+func (n *Node) nextBlockTime(genesisTime time.Time) time.Time {
+	next := genesisTime
+	if now := n.clock.Now(); next.Before(now) {
+		elapsed := now.Sub(next)
+		next = next.Add((elapsed + n.parameter.blockTime - 1) / n.parameter.blockTime * n.parameter.blockTime)
+	}
+	return next
+}
+
 func (n *Node) receiveLoop() {
 	for {
-		signature := n.channel.readSignature()
-		n.pool.add(signature.Kind, signature)
+		sign := n.channel.readSignature()
+		if n.isStopped() {
+			return
+		}
+		if sign.Kind < 0 || sign.Kind >= signature.NumKind {
+			n.reportFault(sign.ID, sign.BlockHeight, errors.New("Invalid signature kind"))
+			continue
+		}
+		if sign.Kind == signature.ViewChange {
+			n.viewChange.add(sign)
+			continue
+		}
+		n.pool.add(sign.Kind, sign)
 	}
 }
 
-func (n *Node) stop() {
-	// Clean validator up
+// Stop stops the validator like a crash, blocks not finalized and collected
+// votes are lost while finalized blocks remain in persistent
+func (n *Node) Stop() {
+	n.stop.Do(func() {
+		n.logger.Warn("Validator stopped", "Height", n.status.GetHeight(), "Finalized height", n.status.GetFinalizedHeight())
+		close(n.quit)
+		n.channel.close()
+		n.pool.close()
+		n.status.Close()
+	})
+}
+
+// Stopped reports whether the validator is stopped
+func (n *Node) Stopped() bool {
+	return n.isStopped()
+}
+
+func (n *Node) isStopped() bool {
+	select {
+	case <-n.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Restart constructs validator of the stopped node listening on transport,
+// it reloads finalized blocks from persistent and keeps keys and metrics
+func (n *Node) Restart(transport net.Transport) *Node {
+	if !n.isStopped() {
+		panic("Validator is not stopped !")
+	}
+	p := n.persistent
+	if n.config.Storage.Path != "" {
+		// Stored blocks are reloaded from disk
+		p = openPersistent(n.id, n.config)
+	}
+
+	r := newNode(n.id, n.addressbook, n.config, transport, n.clock, n.metrics, p)
+	r.validator = n.validator
+	r.parameter = n.parameter
+	r.privKey, r.pubKey = n.privKey, n.pubKey
+	r.byzantine = n.byzantine
+	r.generator = n.generator
+	r.logger.Info("Validator restarted", "Finalized height", r.status.GetFinalizedHeight())
+
+	return r
 }
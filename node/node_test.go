@@ -0,0 +1,223 @@
+package node
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/node/fbft"
+	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/vrfmessage"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	bls.Init(bls.CurveFp254BNb)
+	os.Exit(m.Run())
+}
+
+func TestRestart(t *testing.T) {
+	log.Root().SetHandler(log.DiscardHandler())
+	defer log.Root().SetHandler(log.StdoutHandler)
+
+	genesisTime := time.Unix(5, 0)
+	c := clock.NewVirtual(time.Unix(0, 0))
+	addressbook := GenerateAddressbook(4, 1)
+	cfg := config.GetDefault()
+	cfg.Simulation.Virtual = true
+	simulation := net.NewSimulation(c, 1, net.DelaySpec{Distribution: net.Constant, Delay: 10 * time.Millisecond})
+
+	nodes := make([]*Node, 0)
+	var wg sync.WaitGroup
+	for _, id := range addressbook.IDs() {
+		n := NewValidator(id, addressbook, cfg, simulation.Listen(addressbook[id].Address), c)
+		nodes = append(nodes, n)
+		wg.Add(1)
+		c.Go(func() { n.Start(genesisTime, &wg) })
+	}
+
+	// Validator 4 is down from 5s to 15s after genesis
+	var restarted *Node
+	var restartHeight int
+	c.Go(func() {
+		c.Sleep(genesisTime.Add(5 * time.Second).Sub(c.Now()))
+		nodes[3].Stop()
+		c.Sleep(10 * time.Second)
+		restartHeight = nodes[0].status.GetFinalizedHeight()
+		restarted = nodes[3].Restart(simulation.Listen(addressbook[4].Address))
+		wg.Add(1)
+		restarted.Start(genesisTime, &wg)
+	})
+	c.Run(30 * time.Second)
+
+	// Restarted validator caught up and finalizes blocks with others
+	require.NotNil(t, restarted)
+	require.True(t, restarted.status.GetFinalizedHeight() > restartHeight)
+	require.True(t, restarted.status.GetFinalizedHeight() >= nodes[0].status.GetFinalizedHeight()-1)
+	require.Equal(t, int64(1), restarted.Metrics().Snapshot().Histograms[metrics.RecoveryTime].Count())
+}
+
+func TestConcurrentStop(t *testing.T) {
+	log.Root().SetHandler(log.DiscardHandler())
+	defer log.Root().SetHandler(log.StdoutHandler)
+
+	addressbook := GenerateAddressbook(4, 1)
+	simulation := net.NewSimulation(clock.NewReal(), 1, net.DelaySpec{})
+	n := NewValidator(1, addressbook, config.GetDefault(), simulation.Listen(addressbook[1].Address), clock.NewReal())
+
+	// Scheduled churn and shutdown may stop the validator at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.Stop()
+		}()
+	}
+	wg.Wait()
+	require.True(t, n.Stopped())
+}
+
+func TestValidateChain(t *testing.T) {
+	log.Root().SetHandler(log.DiscardHandler())
+	defer log.Root().SetHandler(log.StdoutHandler)
+
+	genesisTime := time.Unix(5, 0)
+	c := clock.NewVirtual(time.Unix(0, 0))
+	addressbook := GenerateAddressbook(4, 1)
+	cfg := config.GetDefault()
+	cfg.Simulation.Virtual = true
+	simulation := net.NewSimulation(c, 1, net.DelaySpec{Distribution: net.Constant, Delay: 10 * time.Millisecond})
+
+	nodes := make([]*Node, 0)
+	var wg sync.WaitGroup
+	for _, id := range addressbook.IDs() {
+		n := NewValidator(id, addressbook, cfg, simulation.Listen(addressbook[id].Address), c)
+		nodes = append(nodes, n)
+		wg.Add(1)
+		c.Go(func() { n.Start(genesisTime, &wg) })
+	}
+	c.Run(15 * time.Second)
+
+	height, err := ValidateChain(nodes[0].persistent, addressbook, cfg)
+	require.NoError(t, err)
+	require.True(t, height >= 8)
+
+	// Copy of the chain with a tampered block
+	tampered := func(modify func(b block.Block) block.Block) persistent.Persistent {
+		p := persistent.New()
+		for h := 1; h <= height; h++ {
+			b, err := nodes[0].persistent.GetBlock(h)
+			require.NoError(t, err)
+			if h == 3 {
+				b = modify(b)
+			}
+			signs, err := nodes[0].persistent.GetSignature(h)
+			require.NoError(t, err)
+			p.AddBlock(b, signs)
+		}
+		return p
+	}
+	_, err = ValidateChain(tampered(func(b block.Block) block.Block {
+		b.Header.Timestamp++
+		return b
+	}), addressbook, cfg)
+	require.EqualError(t, err, "Block 3: Invalid block hash")
+
+	// Rehashed block is not signed by validators
+	last, err := ValidateChain(tampered(func(b block.Block) block.Block {
+		b.Header.Timestamp++
+		return block.New(b.Header, b.VRF, b.Transactions)
+	}), addressbook, cfg)
+	require.EqualError(t, err, "Block 3: Invalid aggregated signature")
+	require.Equal(t, 2, last)
+}
+
+func TestVRFFinalizationKind(t *testing.T) {
+	addressbook := PrepareAddressbook()
+	nodes := viewChangeNodes(addressbook)
+	f := &fridayVRF{node: nodes[1]}
+	b := block.New(block.BlockHeader{Height: 1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+
+	commits := make([]signature.Signature, 0)
+	prepares := make([]signature.Signature, 0)
+	for _, id := range addressbook.IDs()[:f.quorum()] {
+		voter := &fridayVRF{node: nodes[id]}
+		commits = append(commits, voter.vote(signature.Commit, b)(b.Hash[:]))
+		prepare := voter.vote(signature.Prepare, b)(b.Hash[:])
+		prepare.Kind = signature.Commit
+		prepares = append(prepares, prepare)
+	}
+	require.NoError(t, f.verifyFinalization(b, commits))
+
+	// Prepare quorum relabeled as commit is not finalization
+	require.NotNil(t, f.verifyFinalization(b, prepares))
+}
+
+func TestFBFTFinalizationQuorum(t *testing.T) {
+	addressbook := PrepareAddressbook()
+	nodes := viewChangeNodes(addressbook)
+	f := &fridayFBFT{node: nodes[1]}
+	b := block.New(block.BlockHeader{Height: 1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+
+	// message aggregates signatures on hash of the first number of validators
+	message := func(hash []byte, number int) fbft.Message {
+		m := fbft.Message{Signers: fbft.NewSigners(len(addressbook))}
+		for i, id := range addressbook.IDs()[:number] {
+			m.Sign.Add(nodes[id].blsSecretKey.SignHash(hash))
+			m.Signers.Set(i)
+		}
+		return m
+	}
+	certificate := func(prepared fbft.Message, commited fbft.Message) []signature.Signature {
+		preparedHash := prepared.Hash()
+		return append(f.certificate(b, prepared, nil),
+			signature.NewVote(1, signature.Commited, 1, 0, preparedHash[:], commited.Serialize()))
+	}
+
+	prepared := message(b.Hash[:], f.quorum())
+	preparedHash := prepared.Hash()
+	require.NoError(t, f.verifyFinalization(b, certificate(prepared, message(preparedHash[:], f.quorum()))))
+
+	// Signers less than quorum
+	few := message(b.Hash[:], f.quorum()-1)
+	fewHash := few.Hash()
+	require.NotNil(t, f.verifyFinalization(b, certificate(few, message(fewHash[:], f.quorum()))))
+
+	// Single key claiming every validator as signer
+	forged := fbft.Message{Sign: *nodes[1].blsSecretKey.SignHash(b.Hash[:]), Signers: fbft.NewSigners(len(addressbook))}
+	for i := range addressbook.IDs() {
+		forged.Signers.Set(i)
+	}
+	forgedHash := forged.Hash()
+	require.NotNil(t, f.verifyFinalization(b, certificate(forged, message(forgedHash[:], f.quorum()))))
+}
+
+func TestValidatorKeyMismatch(t *testing.T) {
+	addressbook := PrepareAddressbook()
+	config := config.GetDefault()
+	_, err := validatorKey(1, addressbook, config)
+	require.NoError(t, err)
+
+	// Secret of another validator
+	mismatch := addressbook[1]
+	mismatch.Secret = addressbook[2].Secret
+	addressbook[1] = mismatch
+	_, err = validatorKey(1, addressbook, config)
+	require.NotNil(t, err)
+
+	// Corrupted secret
+	mismatch.Secret = "corrupted"
+	addressbook[1] = mismatch
+	_, err = validatorKey(1, addressbook, config)
+	require.NotNil(t, err)
+}
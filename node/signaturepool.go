@@ -1,45 +1,73 @@
 package node
 
 import (
+	"sort"
 	"sync"
 
+	"github.com/hdac-io/simulator/clock"
 	"github.com/hdac-io/simulator/signature"
 )
 
 type notifiableSignature struct {
 	sync.Mutex
-	cond       *sync.Cond
-	target     int
+	cond       clock.Cond
+	waiters    int
+	cancelled  bool
 	signatures []signature.Signature
 }
 
-type signatureMap map[int]*notifiableSignature
+// roundKey identifies votes on the block of the round at the height
+type roundKey struct {
+	height int
+	round  int
+}
+
+type signatureMap map[roundKey]*notifiableSignature
 
 type signaturepool struct {
 	sync.RWMutex
+	clock      clock.Clock
 	signatures [signature.NumKind]signatureMap
+
+	// Votes of skipped rounds, finalized heights and closed pool are not collected
+	rounds    map[int]int
+	finalized int
+	closed    bool
 }
 
-func newSignaturePool() *signaturepool {
-	return &signaturepool{
-		signatures: [signature.NumKind]signatureMap{make(signatureMap), make(signatureMap), make(signatureMap), make(signatureMap)},
+func newSignaturePool(clock clock.Clock) *signaturepool {
+	s := &signaturepool{
+		clock:  clock,
+		rounds: make(map[int]int),
 	}
+	for kind := range s.signatures {
+		s.signatures[kind] = make(signatureMap)
+	}
+
+	return s
 }
 
-func (s *signaturepool) get(kind signature.Kind, height int) *notifiableSignature {
+// stale reports whether votes of the round are not collected anymore
+func (s *signaturepool) stale(height int, round int) bool {
 	s.RLock()
-	sig, exists := s.signatures[kind][height]
+	defer s.RUnlock()
+	return s.closed || height <= s.finalized || round < s.rounds[height]
+}
+
+func (s *signaturepool) get(kind signature.Kind, height int, round int) *notifiableSignature {
+	key := roundKey{height: height, round: round}
+	s.RLock()
+	sig, exists := s.signatures[kind][key]
 	s.RUnlock()
 	if !exists {
 		s.Lock()
 		// Check again under locked condition
-		sig, exists = s.signatures[kind][height]
+		sig, exists = s.signatures[kind][key]
 		if !exists {
 			sig = &notifiableSignature{}
-			sig.target = -1
-			sig.cond = sync.NewCond(sig)
+			sig.cond = s.clock.NewCond(sig)
 
-			s.signatures[kind][height] = sig
+			s.signatures[kind][key] = sig
 		}
 		s.Unlock()
 	}
@@ -47,31 +75,128 @@ func (s *signaturepool) get(kind signature.Kind, height int) *notifiableSignatur
 	return sig
 }
 
-func (s *signaturepool) waitAndRemove(kind signature.Kind, height int, number int) []signature.Signature {
-	sig := s.get(kind, height)
-	sig.Lock()
-	if sig.target != -1 {
-		panic("Must not enter here !")
+// waitAndRemoveValid waits until number of signatures of the round accepted
+// by filter are collected, rejected signatures are left to other waiters of
+// the round. It returns fewer signatures when the round is skipped, the
+// height is finalized or the pool is closed.
+func (s *signaturepool) waitAndRemoveValid(kind signature.Kind, height int, round int, number int, filter func(signature.Signature) bool) []signature.Signature {
+	if s.stale(height, round) {
+		return nil
 	}
-	sig.target = number
-	for sig.target > 0 && sig.target > len(sig.signatures) {
+	sig := s.get(kind, height, round)
+	sig.Lock()
+	sig.waiters++
+	accepted := make([]signature.Signature, 0, number)
+	checked := 0
+	for {
+		for ; checked < len(sig.signatures); checked++ {
+			if filter == nil || filter(sig.signatures[checked]) {
+				accepted = append(accepted, sig.signatures[checked])
+			}
+		}
+		if len(accepted) >= number || sig.cancelled || s.stale(height, round) {
+			break
+		}
 		sig.cond.Wait()
 	}
-	s.Lock()
-	delete(s.signatures[kind], height)
-	s.Unlock()
+	sig.waiters--
+	if sig.waiters == 0 {
+		s.Lock()
+		if s.signatures[kind][roundKey{height: height, round: round}] == sig {
+			delete(s.signatures[kind], roundKey{height: height, round: round})
+		}
+		s.Unlock()
+	}
 	sig.Unlock()
 
-	return sig.signatures
+	return accepted
 }
 
 func (s *signaturepool) add(kind signature.Kind, newSign signature.Signature) {
-	sign := s.get(kind, newSign.BlockHeight)
+	if s.stale(newSign.BlockHeight, newSign.Round) {
+		return
+	}
+	sign := s.get(kind, newSign.BlockHeight, newSign.Round)
 	sign.Lock()
 	sign.signatures = append(sign.signatures, newSign)
-
-	if sign.target > 0 && sign.target <= len(sign.signatures) {
-		sign.cond.Signal()
+	if sign.waiters > 0 {
+		sign.cond.Broadcast()
 	}
 	sign.Unlock()
 }
+
+// skip abandons votes of rounds before the round at the height and votes of
+// following heights whose blocks are rolled back
+func (s *signaturepool) skip(height int, round int) {
+	s.Lock()
+	if round > s.rounds[height] {
+		s.rounds[height] = round
+	}
+	cancelled := s.remove(func(key roundKey) bool {
+		return key.height > height || (key.height == height && key.round < round)
+	})
+	s.Unlock()
+	cancel(cancelled)
+}
+
+// prune abandons votes up to the finalized height
+func (s *signaturepool) prune(height int) {
+	s.Lock()
+	if height > s.finalized {
+		s.finalized = height
+	}
+	for h := range s.rounds {
+		if h <= height {
+			delete(s.rounds, h)
+		}
+	}
+	cancelled := s.remove(func(key roundKey) bool {
+		return key.height <= height
+	})
+	s.Unlock()
+	cancel(cancelled)
+}
+
+// close abandons every vote of stopped validator
+func (s *signaturepool) close() {
+	s.Lock()
+	s.closed = true
+	cancelled := s.remove(func(key roundKey) bool {
+		return true
+	})
+	s.Unlock()
+	cancel(cancelled)
+}
+
+// remove removes signatures of the keys matching in order of kind, height
+// and round, it must be called with the pool locked
+func (s *signaturepool) remove(match func(key roundKey) bool) []*notifiableSignature {
+	removed := make([]*notifiableSignature, 0)
+	for kind := range s.signatures {
+		keys := make([]roundKey, 0)
+		for key := range s.signatures[kind] {
+			if match(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].height < keys[j].height || (keys[i].height == keys[j].height && keys[i].round < keys[j].round)
+		})
+		for _, key := range keys {
+			removed = append(removed, s.signatures[kind][key])
+			delete(s.signatures[kind], key)
+		}
+	}
+	return removed
+}
+
+// cancel wakes waiters of removed signatures, signatures are locked after
+// the pool is unlocked since waiters lock the pool while holding signatures
+func cancel(removed []*notifiableSignature) {
+	for _, sig := range removed {
+		sig.Lock()
+		sig.cancelled = true
+		sig.cond.Broadcast()
+		sig.Unlock()
+	}
+}
@@ -1,15 +1,19 @@
 package status
 
 import (
+	"encoding/hex"
 	"errors"
 	"math/rand"
 	"sync"
 	"time"
 
+	"github.com/hdac-io/simulator/app"
 	"github.com/hdac-io/simulator/block"
-	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/metrics"
 	"github.com/hdac-io/simulator/persistent"
 	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
 	log "github.com/inconshreveable/log15"
 )
 
@@ -17,20 +21,33 @@ import (
 type Status struct {
 	// Sync
 	sync.RWMutex
-	cond         *sync.Cond
+	cond         clock.Cond
 	waitFinalize bool
+	closed       bool
 
 	// Status
+	lenULB          int
 	finalizedHeight int
 	confirmedHeight int
 	height          int
 	blocks          []block.Block
+	skippedRounds   []SkippedRound
 
 	// Persistent
 	persistent persistent.Persistent
 
+	// Application executing finalized blocks and its app hashes by height
+	app       app.Application
+	appHashes [][32]byte
+
 	// logger
 	logger log.Logger
+
+	// clock
+	clock clock.Clock
+
+	// metrics
+	metrics *metrics.Registry
 }
 
 func randomSignature(max int) func() int {
@@ -41,13 +58,30 @@ func randomSignature(max int) func() int {
 	}
 }
 
-// New contstructs status
-func New(id int64, max int, logger log.Logger) *Status {
+// New contstructs status on blocks stored in p, stored blocks are executed
+// again on the application
+func New(id int64, max int, lenULB int, p persistent.Persistent, application app.Application, logger log.Logger, clock clock.Clock, metrics *metrics.Registry) *Status {
 	s := &Status{
-		persistent: persistent.New(),
+		lenULB:     lenULB,
+		persistent: p,
+		app:        application,
+		appHashes:  [][32]byte{{}},
 		logger:     logger,
+		clock:      clock,
+		metrics:    metrics,
+	}
+	// Reopen stored blocks
+	s.finalizedHeight = p.GetFinalizedHeight()
+	s.confirmedHeight = p.GetConfirmedHeight()
+	s.height = s.finalizedHeight
+	s.cond = clock.NewCond(s)
+	for height := 1; height <= s.finalizedHeight; height++ {
+		b, err := p.GetBlock(height)
+		if err != nil {
+			panic(err)
+		}
+		s.execute(b)
 	}
-	s.cond = sync.NewCond(s)
 
 	return s
 }
@@ -61,40 +95,73 @@ func (s *Status) AppendBlock(b block.Block) {
 		panic("Block height mismatch !")
 	}
 	// Negative number and 0 mean there is no confirmed block
-	s.confirmedHeight = s.height - (config.GetDefault().Consensus.LenULB + 1)
+	s.confirmedHeight = s.height - (s.lenULB + 1)
 	// Append block
 	s.blocks = append(s.blocks, b)
 	s.Unlock()
 }
 
-// Analysis represents data for analysis
-var Analysis struct {
-	sync.Mutex
-	Enabled bool
-	// LaziestFinalizedTime contains laziest finalized time
-	LaziestFinalizedTime time.Duration
-	// FastestFinalizedTime contains average finalized time
-	FastestFinalizedTime time.Duration
-	// AverageFinalizedTime contains average finalized time
-	AverageFinalizedTime time.Duration
+// Rollback removes blocks appended from the height which are not finalized
+// and returns them
+func (s *Status) Rollback(height int) []block.Block {
+	s.Lock()
+	defer s.Unlock()
+	if height <= s.finalizedHeight || height > s.height {
+		return nil
+	}
+	index := height - s.finalizedHeight - 1
+	removed := append([]block.Block(nil), s.blocks[index:]...)
+	s.blocks = s.blocks[:index]
+	s.height = height - 1
+	s.confirmedHeight = s.height - (s.lenULB + 1)
+	return removed
+}
+
+// SkippedRound represents round of the height skipped by view change
+type SkippedRound struct {
+	Height   int
+	Round    int
+	Proposer types.ID
+}
+
+// SkipRound records round skipped because the proposer was silent
+func (s *Status) SkipRound(height int, round int, proposer types.ID) {
+	s.Lock()
+	s.skippedRounds = append(s.skippedRounds, SkippedRound{Height: height, Round: round, Proposer: proposer})
+	s.Unlock()
+}
+
+// GetSkippedRounds returns skipped rounds
+func (s *Status) GetSkippedRounds() []SkippedRound {
+	s.RLock()
+	defer s.RUnlock()
+	return append([]SkippedRound(nil), s.skippedRounds...)
 }
 
 // Finalize finalizing specified block
 func (s *Status) Finalize(b block.Block, signs []signature.Signature) {
 	s.Lock()
-	for b.Header.Height > s.finalizedHeight+1 {
+	for !s.closed && b.Header.Height > s.finalizedHeight+1 {
 		s.logger.Warn("Previous block is not finalized yet !", "Current Finalizing height", b.Header.Height, "Previous finalized height", s.finalizedHeight)
 		s.waitFinalize = true
 		s.cond.Wait()
 	}
+	if s.closed || b.Header.Height <= s.finalizedHeight {
+		// Validator is stopped or the block is finalized by sync
+		s.Unlock()
+		return
+	}
 
 	s.finalizedHeight = b.Header.Height
 	s.blocks = s.blocks[1:]
 
-	// Store finalized block
-	s.persistent.AddBlock(b)
-	// Store finalized signature
-	s.persistent.AddSignature(signs)
+	// Execute block
+	diverged := !s.checkAppState(b)
+	failed := s.execute(b)
+
+	// Store finalized block with its signature
+	s.persistent.AddBlock(b, signs)
+	s.persistent.SetConfirmedHeight(s.confirmedHeight)
 
 	if s.waitFinalize {
 		s.cond.Broadcast()
@@ -103,31 +170,96 @@ func (s *Status) Finalize(b block.Block, signs []signature.Signature) {
 	s.Unlock()
 
 	// For analysis
-	if Analysis.Enabled {
-		blockTime := time.Unix(0, b.Header.Timestamp)
-		finalizedTime := time.Now().Sub(blockTime)
-		Analysis.Lock()
-		if Analysis.FastestFinalizedTime > finalizedTime {
-			Analysis.FastestFinalizedTime = finalizedTime
-		}
-		if Analysis.LaziestFinalizedTime < finalizedTime {
-			Analysis.LaziestFinalizedTime = finalizedTime
+	now := s.clock.Now()
+	s.metrics.Observe(metrics.FinalizationLatency, now.Sub(time.Unix(0, b.Header.Timestamp)))
+	s.metrics.Add(metrics.TransactionsFinalized, int64(len(b.Transactions)))
+	s.metrics.Add(metrics.TransactionsFailed, int64(failed))
+	if diverged {
+		s.metrics.Add(metrics.StateDivergences, 1)
+	}
+	for _, tx := range b.Transactions {
+		s.metrics.Observe(metrics.TransactionLatency, now.Sub(time.Unix(0, tx.Timestamp)))
+	}
+}
+
+// checkAppState compares app state of the block producer with this node and
+// reports whether they match, divergence is counted by the caller after
+// releasing the lock of status
+func (s *Status) checkAppState(b block.Block) bool {
+	state := b.Header.App
+	if state.Height < 0 || state.Height >= len(s.appHashes) {
+		s.logger.Error("Invalid app state height", "Height", b.Header.Height, "App height", state.Height)
+		return false
+	} else if state.Hash != s.appHashes[state.Height] {
+		s.logger.Error("Divergent app state", "Height", b.Header.Height, "Producer", b.Header.Producer,
+			"App height", state.Height, "App hash", hex.EncodeToString(state.Hash[:]),
+			"Expected", hex.EncodeToString(s.appHashes[state.Height][:]))
+		return false
+	}
+	return true
+}
+
+// execute executes block on the application and returns number of failed transactions
+func (s *Status) execute(b block.Block) int {
+	failed := 0
+	s.app.BeginBlock(b.Header)
+	for _, tx := range b.Transactions {
+		if err := s.app.DeliverTx(tx); err != nil {
+			failed++
 		}
-		Analysis.AverageFinalizedTime =
-			time.Duration((Analysis.AverageFinalizedTime.Nanoseconds()*int64(b.Header.Height-1)+finalizedTime.Nanoseconds())/int64(b.Header.Height)) * time.Nanosecond
-		Analysis.Unlock()
 	}
+	s.app.EndBlock(b.Header.Height)
+	s.appHashes = append(s.appHashes, s.app.Commit())
+	return failed
+}
+
+// GetAppState returns app state after executing the last finalized block
+func (s *Status) GetAppState() block.AppState {
+	s.RLock()
+	defer s.RUnlock()
+	return block.AppState{Height: s.finalizedHeight, Hash: s.appHashes[s.finalizedHeight]}
 }
 
 // GetHeight returns current block height
 func (s *Status) GetHeight() int {
+	s.RLock()
+	defer s.RUnlock()
 	return s.height
 }
 
+// GetFinalizedHeight returns finalized block height
+func (s *Status) GetFinalizedHeight() int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.finalizedHeight
+}
+
+// GetConfirmedHeight returns confirmed block height, negative number and 0 mean there is no confirmed block
+func (s *Status) GetConfirmedHeight() int {
+	s.RLock()
+	defer s.RUnlock()
+	return s.confirmedHeight
+}
+
+// Close closes persistent, blocks are not finalized anymore
+func (s *Status) Close() {
+	s.Lock()
+	s.closed = true
+	s.persistent.Close()
+	s.cond.Broadcast()
+	s.Unlock()
+}
+
 // GetBlock returns target block height
 func (s *Status) GetBlock(height int) (block.Block, error) {
-	if height <= s.finalizedHeight {
-		return s.persistent.GetBlock(height), nil
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed {
+		return block.Block{}, errors.New("closed status")
+	} else if height < 1 {
+		return block.Block{}, errors.New("out-of-index height")
+	} else if height <= s.finalizedHeight {
+		return s.persistent.GetBlock(height)
 	} else if height <= s.height {
 		return s.blocks[height-s.finalizedHeight-1], nil
 	} else {
@@ -135,25 +267,35 @@ func (s *Status) GetBlock(height int) (block.Block, error) {
 	}
 }
 
+// GetSignature returns finalization signature of finalized block
+func (s *Status) GetSignature(height int) ([]signature.Signature, error) {
+	s.RLock()
+	defer s.RUnlock()
+	if s.closed || height < 1 || height > s.finalizedHeight {
+		return nil, errors.New("out-of-index height")
+	}
+	return s.persistent.GetSignature(height)
+}
+
 // GetRecentBlock returns recent block
-func (s *Status) GetRecentBlock() block.Block {
+func (s *Status) GetRecentBlock() (block.Block, error) {
 	if len(s.blocks) > 0 {
-		return s.blocks[len(s.blocks)-1]
+		return s.blocks[len(s.blocks)-1], nil
 	}
 	return s.GetRecentFinalizedBlock()
 }
 
 // GetRecentFinalizedBlock returns recent finalized block
-func (s *Status) GetRecentFinalizedBlock() block.Block {
+func (s *Status) GetRecentFinalizedBlock() (block.Block, error) {
 	return s.persistent.GetBlock(s.finalizedHeight)
 }
 
 // GetRecentConfirmedBlock returns recent finalized block
-func (s *Status) GetRecentConfirmedBlock() block.Block {
+func (s *Status) GetRecentConfirmedBlock() (block.Block, error) {
 	return s.persistent.GetBlock(s.confirmedHeight)
 }
 
 // GetRecentConfirmedSignature returns recent finalized block
-func (s *Status) GetRecentConfirmedSignature() []signature.Signature {
+func (s *Status) GetRecentConfirmedSignature() ([]signature.Signature, error) {
 	return s.persistent.GetSignature(s.confirmedHeight)
 }
@@ -0,0 +1,57 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/vrfmessage"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecute(t *testing.T) {
+	logger := log.New()
+	logger.SetHandler(log.DiscardHandler())
+	p := persistent.New()
+	registry := metrics.NewRegistry()
+	s := New(1, 4, 0, p, app.NewKVStore(), logger, clock.NewVirtual(time.Unix(0, 0)), registry)
+
+	finalize := func(height int, state block.AppState, payloads ...[]byte) {
+		txs := make([]block.Transaction, 0)
+		for i, payload := range payloads {
+			txs = append(txs, block.Transaction{From: 1, Nonce: uint64(i), Payload: payload})
+		}
+		b := block.New(block.BlockHeader{Height: height, Timestamp: int64(height), Producer: 1, App: state}, vrfmessage.VRFMessage{}, txs)
+		s.AppendBlock(b)
+		s.Finalize(b, []signature.Signature{signature.New(1, signature.Commit, height, 0, nil)})
+	}
+
+	genesis := s.GetAppState()
+	require.Equal(t, block.AppState{}, genesis)
+	finalize(1, genesis, app.EncodeSet([]byte("key"), []byte("value")), []byte{0})
+	first := s.GetAppState()
+	require.Equal(t, 1, first.Height)
+	require.NotEqual(t, genesis.Hash, first.Hash)
+
+	// State of earlier height is accepted
+	finalize(2, genesis, app.EncodeTransfer(2, 10))
+	finalize(3, block.AppState{Height: 1, Hash: first.Hash})
+	snapshot := registry.Snapshot()
+	require.Equal(t, int64(0), snapshot.Counters[metrics.StateDivergences])
+	require.Equal(t, int64(1), snapshot.Counters[metrics.TransactionsFailed])
+
+	// Divergent and future states are detected
+	finalize(4, block.AppState{Height: 1, Hash: genesis.Hash})
+	finalize(5, block.AppState{Height: 5})
+	require.Equal(t, int64(2), registry.Snapshot().Counters[metrics.StateDivergences])
+
+	// Stored blocks are executed again on restart
+	restarted := New(1, 4, 0, p, app.NewKVStore(), logger, clock.NewVirtual(time.Unix(0, 0)), metrics.NewRegistry())
+	require.Equal(t, s.GetAppState(), restarted.GetAppState())
+}
@@ -0,0 +1,216 @@
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/blocksync"
+)
+
+// syncPeers is number of peers asked the same blocks, blocks are verified
+// so that an honest peer among them is enough
+const syncPeers = 3
+
+// serveSyncLoop answers block requests of peers
+func (n *Node) serveSyncLoop() {
+	for {
+		r := n.channel.readSyncRequest()
+		if n.isStopped() {
+			return
+		}
+		n.channel.write(r.peer, n.syncResponse(r.request))
+	}
+}
+
+// syncResponse collects blocks of heights requested by r
+func (n *Node) syncResponse(r blocksync.Request) blocksync.Response {
+	response := blocksync.Response{
+		Height:  n.status.GetHeight(),
+		Blocks:  make([]block.Block, 0),
+		Pending: make([]block.Block, 0),
+	}
+	from := r.From
+	if from < 1 {
+		from = 1
+	}
+	to := r.To
+	if to > response.Height {
+		to = response.Height
+	}
+	if to >= from+blocksync.MaxBlocks {
+		to = from + blocksync.MaxBlocks - 1
+	}
+
+	finalizedHeight := n.status.GetFinalizedHeight()
+	for height := from; height <= to; height++ {
+		b, err := n.status.GetBlock(height)
+		if err != nil {
+			break
+		}
+		if height > finalizedHeight {
+			response.Pending = append(response.Pending, b)
+			continue
+		}
+		signs, err := n.status.GetSignature(height)
+		if err != nil {
+			break
+		}
+		response.Blocks = append(response.Blocks, b)
+		response.Signatures = append(response.Signatures, signs)
+	}
+
+	return response
+}
+
+// requestBlocks asks peers blocks of heights from..to and applies responses
+// until the peers answer or timeout. It returns the highest height of the
+// peers, -1 if nobody answers, and number of the asked peers.
+func (n *Node) requestBlocks(from int, to int) (int, int) {
+	peers := n.channel.sendSyncRequest(blocksync.Request{From: from, To: to}, syncPeers)
+	highest := -1
+	for i := 0; i < peers; i++ {
+		response, ok := n.channel.readSyncResponse(n.parameter.blockTime)
+		if !ok {
+			break
+		}
+		n.applySync(response)
+		if response.Height > highest {
+			highest = response.Height
+		}
+	}
+	return highest, peers
+}
+
+// catchUp requests blocks from peers until this node reaches their height
+func (n *Node) catchUp() {
+	for !n.isStopped() {
+		height := n.status.GetHeight()
+		highest, peers := n.requestBlocks(height+1, height+blocksync.MaxBlocks)
+		if peers == 0 {
+			// Every peer is down, nothing to catch up
+			return
+		}
+		if highest >= 0 && n.status.GetHeight() >= highest {
+			return
+		}
+	}
+}
+
+// syncLoop requests blocks every block time while this node is behind
+func (n *Node) syncLoop() {
+	for {
+		n.clock.Sleep(n.parameter.blockTime)
+		if n.isStopped() {
+			return
+		}
+		if from, to, behind := n.behind(); behind {
+			n.logger.Info("Synchronize blocks", "From", from, "To", to)
+			n.requestBlocks(from, to)
+		}
+	}
+}
+
+// behind returns heights to synchronize if blocks after the next height are
+// received, or blocks are not finalized while following blocks are appended
+func (n *Node) behind() (int, int, bool) {
+	finalizedHeight := n.status.GetFinalizedHeight()
+	height := n.status.GetHeight()
+	to := height
+	if highest := n.channel.highestBlock(); highest > height+1 {
+		to = highest - 1
+	}
+	if to > height || height-finalizedHeight > n.parameter.lenULB+1 || finalizedHeight < n.syncedHeight {
+		return finalizedHeight + 1, to, true
+	}
+	return 0, 0, false
+}
+
+// applySync appends and finalizes verified blocks of the response continuing this node
+func (n *Node) applySync(r blocksync.Response) {
+	if len(r.Signatures) != len(r.Blocks) {
+		n.logger.Warn("Invalid sync response", "Blocks", len(r.Blocks), "Signatures", len(r.Signatures))
+		return
+	}
+	for i, b := range r.Blocks {
+		finalizedHeight := n.status.GetFinalizedHeight()
+		height := n.status.GetHeight()
+		replace := false
+		switch {
+		case b.Header.Height <= finalizedHeight:
+			// Already finalized
+			continue
+		case b.Header.Height == finalizedHeight+1 && b.Header.Height <= height:
+			// Appended but not finalized, block of skipped round is replaced
+			known, err := n.status.GetBlock(b.Header.Height)
+			if err != nil {
+				return
+			}
+			if known.Hash != b.Hash {
+				if err := n.validateSynced(b); err != nil {
+					n.logger.Warn("Invalid synchronized block", "Height", b.Header.Height, "Reason", err.Error())
+					return
+				}
+				replace = true
+			}
+		case b.Header.Height == height+1 && height == finalizedHeight:
+			if err := n.validateSynced(b); err != nil {
+				n.logger.Warn("Invalid synchronized block", "Height", b.Header.Height, "Reason", err.Error())
+				return
+			}
+		default:
+			// Not continuing
+			return
+		}
+
+		if err := n.consensus.verifyFinalization(b, r.Signatures[i]); err != nil {
+			n.logger.Warn("Invalid finalization signature", "Height", b.Header.Height, "Reason", err.Error())
+			return
+		}
+		if replace {
+			n.viewChange.rewind(b)
+			height = b.Header.Height - 1
+		}
+		if b.Header.Height > height {
+			n.appendBlock(b)
+		}
+		n.finalizeBlock(b, r.Signatures[i])
+		n.logger.Info("Block synchronized", "Height", b.Header.Height, "Hash", hex.EncodeToString(b.Hash[:]))
+	}
+
+	// Blocks not finalized are appended without voting, they are finalized
+	// by later synchronization
+	for _, b := range r.Pending {
+		if b.Header.Height <= n.status.GetHeight() {
+			continue
+		}
+		if b.Header.Height != n.status.GetHeight()+1 {
+			return
+		}
+		if err := n.validateSynced(b); err != nil {
+			n.logger.Warn("Invalid synchronized block", "Height", b.Header.Height, "Reason", err.Error())
+			return
+		}
+		n.appendBlock(b)
+		n.viewChange.lock(b)
+		n.syncedHeight = b.Header.Height
+		n.logger.Info("Block synchronized", "Height", b.Header.Height, "Hash", hex.EncodeToString(b.Hash[:]))
+	}
+}
+
+// validateSynced checks block received by sync or stored in the chain against the previous block
+func (n *Node) validateSynced(b block.Block) error {
+	if err := block.Validate(b); err != nil {
+		return err
+	}
+	if err := n.validateTransactions(b); err != nil {
+		return err
+	}
+	if err := n.validateParent(b); err != nil {
+		return err
+	}
+	if b.Header.Producer != n.viewChange.proposer(b.Header.Height, b.Header.Round) {
+		return errors.New("Invalid producer")
+	}
+	return n.validateVRF(b)
+}
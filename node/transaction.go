@@ -0,0 +1,92 @@
+package node
+
+import (
+	"errors"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/signature"
+)
+
+// startLoad starts submitting synthetic transactions if the load is configured
+func (n *Node) startLoad() {
+	if n.generator != nil {
+		n.clock.Go(n.loadLoop)
+	}
+}
+
+// loadLoop submits transactions generated by the load generator
+func (n *Node) loadLoop() {
+	for {
+		n.clock.Sleep(n.generator.Interval())
+		if n.isStopped() {
+			return
+		}
+		tx := n.generator.Next(n.clock.Now())
+		if n.mempool.Add(tx) {
+			n.channel.sendTransaction(tx)
+		}
+	}
+}
+
+// transactionLoop adds transactions relayed by peers to the mempool
+func (n *Node) transactionLoop() {
+	for {
+		tx := n.channel.readTransaction()
+		if n.isStopped() {
+			return
+		}
+		n.mempool.Add(tx)
+	}
+}
+
+// reapTransactions returns transactions of a new block
+func (n *Node) reapTransactions() []block.Transaction {
+	return n.mempool.Reap(n.config.Block.MaxTransactions, n.config.Block.MaxBytes)
+}
+
+// appendBlock appends block and removes its transactions from the mempool
+func (n *Node) appendBlock(b block.Block) {
+	n.status.AppendBlock(b)
+	n.mempool.Update(b.Header.Height, b.Transactions)
+}
+
+// finalizeBlock finalizes block and prunes its transactions and votes
+func (n *Node) finalizeBlock(b block.Block, signs []signature.Signature) {
+	n.status.Finalize(b, signs)
+	n.mempool.Prune(b.Header.Height)
+	n.pool.prune(b.Header.Height)
+	n.viewChange.prune(b.Header.Height)
+}
+
+// rollback removes blocks appended from the height when the round advances,
+// their transactions return to the mempool and votes on them are abandoned
+func (n *Node) rollback(height int, round int) {
+	blocks := n.status.Rollback(height)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		n.mempool.Rollback(blocks[i].Header.Height, blocks[i].Transactions)
+	}
+	n.pool.skip(height, round)
+	if len(blocks) > 0 {
+		n.logger.Warn("Blocks rolled back", "Height", height, "Round", blocks[0].Header.Round, "Blocks", len(blocks))
+	}
+}
+
+// validateTransactions checks transactions of the block against block limits
+// and duplicates
+func (n *Node) validateTransactions(b block.Block) error {
+	if len(b.Transactions) > n.config.Block.MaxTransactions {
+		return errors.New("Too many transactions")
+	}
+	if b.Size() > n.config.Block.MaxBytes {
+		return errors.New("Too large block")
+	}
+	hashes := make(map[[32]byte]bool, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		hash := tx.Hash()
+		if hashes[hash] {
+			return errors.New("Duplicate transaction")
+		}
+		hashes[hash] = true
+	}
+	return nil
+}
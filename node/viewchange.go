@@ -0,0 +1,323 @@
+package node
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+)
+
+// roundWindow is number of rounds ahead of the round being decided whose
+// view change votes are kept
+const roundWindow = 8
+
+var (
+	errStaleRound = errors.New("Block of skipped round")
+	errStopped    = errors.New("Validator is stopped")
+)
+
+// viewChange tracks round of the height being decided, the round advances
+// when quorum of validators vote to skip the proposer of the round. The height
+// being decided is the lowest height whose block is not prepared by this node,
+// so that appended block waiting for votes can be skipped and rolled back.
+type viewChange struct {
+	sync.Mutex
+	node    *Node
+	timeout time.Duration
+
+	height   int
+	round    int
+	voted    int       // Highest round voted to move to
+	produced bool      // Block of the round is produced
+	start    time.Time // Start time of the round
+
+	// Voters of view change by height and round
+	votes map[int]map[int]map[types.ID]bool
+}
+
+func newViewChange(node *Node, timeout time.Duration) *viewChange {
+	return &viewChange{
+		node:    node,
+		timeout: timeout,
+		votes:   make(map[int]map[int]map[types.ID]bool),
+	}
+}
+
+func (v *viewChange) quorum() int {
+	return v.node.parameter.numValidators*2/3 + 1
+}
+
+// proposer returns proposer of the round derived from VRF of the previous block
+func (v *viewChange) proposer(height int, round int) types.ID {
+	var vrfMessage vrfmessage.VRFMessage
+	if height > 1 {
+		previous, err := v.node.status.GetBlock(height - 1)
+		if err != nil {
+			panic("Block height index out of bound !")
+		}
+		vrfMessage = previous.VRF
+	} else if round == 0 {
+		// TODO::FIXME refectoring to initializeGenesisBlock
+		// When firstly producing genesis block, cannot have previous block status
+		return types.ID(1)
+	}
+
+	return vrfMessage.CalculateFallbackBPID(v.node.parameter.numValidators, round)
+}
+
+// tick is called every block time with the height to produce, it votes to skip
+// the round of the height being decided after timeout and returns round to
+// propose if this node should propose. Timeout grows with the round so that
+// block too large to be delivered in time is eventually decided.
+func (v *viewChange) tick(height int) (int, bool) {
+	v.Lock()
+	v.enter(v.node.status.GetFinalizedHeight() + 1)
+	var vote *signature.Signature
+	timeout := v.timeout * time.Duration(v.round+1)
+	if v.node.clock.Now().Sub(v.start) >= timeout && v.voted <= v.round {
+		v.voted = v.round + 1
+		sign := v.vote(v.height, v.voted)
+		vote = &sign
+		v.node.logger.Warn("Round timeout", "Height", v.height, "Round", v.round,
+			"Proposer", v.proposer(v.height, v.round))
+	}
+	round := v.round
+	propose := height == v.height && !v.produced && v.voted <= round && v.proposer(height, round) == v.node.id
+	if propose {
+		v.produced = true
+	}
+	v.Unlock()
+
+	// Sending may block, so it is done after unlock
+	if vote != nil {
+		v.node.sendSignature(*vote)
+	}
+
+	return round, propose
+}
+
+// state returns current round and highest voted round of the height, false
+// if the height is not being decided
+func (v *viewChange) state(height int) (int, int, bool) {
+	v.Lock()
+	defer v.Unlock()
+	v.enter(v.node.status.GetFinalizedHeight() + 1)
+	return v.round, v.voted, height == v.height
+}
+
+// lock moves to the next height once the block is prepared, votes to skip
+// the round and commit of its block exclude each other. It returns false if
+// this node voted to skip the round of the block.
+func (v *viewChange) lock(b block.Block) bool {
+	v.Lock()
+	defer v.Unlock()
+	if b.Header.Height < v.height {
+		return true
+	}
+	if b.Header.Height == v.height && (b.Header.Round < v.round || b.Header.Round < v.voted) {
+		return false
+	}
+	v.enter(b.Header.Height + 1)
+	return true
+}
+
+// rewind rolls back blocks appended from the height of the block finalized
+// by others, so that the block replaces them
+func (v *viewChange) rewind(b block.Block) {
+	v.Lock()
+	defer v.Unlock()
+	v.node.rollback(b.Header.Height, b.Header.Round)
+	if b.Header.Height < v.height {
+		v.height = b.Header.Height
+		v.round = b.Header.Round
+		v.voted = b.Header.Round
+		v.produced = true
+	}
+}
+
+// enter starts round 0 of the new height
+func (v *viewChange) enter(height int) {
+	if height <= v.height {
+		return
+	}
+	v.height = height
+	v.round = 0
+	v.voted = 0
+	v.produced = false
+	v.start = v.node.clock.Now()
+	v.advance()
+}
+
+// reopen decides again the prepared height if quorum votes to skip the round
+// of its block
+func (v *viewChange) reopen(height int) {
+	b, err := v.node.status.GetBlock(height)
+	if err != nil || height <= v.node.status.GetFinalizedHeight() {
+		return
+	}
+	for round, voters := range v.votes[height] {
+		if round > b.Header.Round && len(voters) >= v.quorum() {
+			v.height = height
+			v.round = b.Header.Round
+			v.voted = b.Header.Round
+			v.produced = true
+			v.advance()
+			return
+		}
+	}
+}
+
+// maxRound returns the highest round whose votes are kept at the height
+func (v *viewChange) maxRound(height int) int {
+	base := 0
+	if height == v.height {
+		base = v.round
+	} else if height < v.height {
+		if b, err := v.node.status.GetBlock(height); err == nil {
+			base = b.Header.Round
+		}
+	}
+	return base + roundWindow
+}
+
+// prune forgets votes up to the finalized height
+func (v *viewChange) prune(height int) {
+	v.Lock()
+	defer v.Unlock()
+	for h := range v.votes {
+		if h <= height {
+			delete(v.votes, h)
+		}
+	}
+}
+
+// advance moves to the highest round having quorum of votes, blocks appended
+// at the height are rolled back
+func (v *viewChange) advance() {
+	next := v.round
+	for round, voters := range v.votes[v.height] {
+		if round > next && len(voters) >= v.quorum() {
+			next = round
+		}
+	}
+	if next == v.round {
+		return
+	}
+
+	for round := v.round; round < next; round++ {
+		proposer := v.proposer(v.height, round)
+		v.node.status.SkipRound(v.height, round, proposer)
+		v.node.logger.Warn("Round skipped", "Height", v.height, "Round", round, "Proposer", proposer)
+	}
+	v.node.rollback(v.height, next)
+	v.round = next
+	v.produced = false
+	v.start = v.node.clock.Now()
+}
+
+// add accepts view change vote
+func (v *viewChange) add(sign signature.Signature) {
+	round, err := v.verify(sign)
+	if err != nil {
+		v.node.reportFault(sign.ID, sign.BlockHeight, err)
+		return
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	v.enter(v.node.status.GetFinalizedHeight() + 1)
+	// Votes far ahead are dropped so that they are bounded
+	if sign.BlockHeight <= v.node.status.GetFinalizedHeight() || sign.BlockHeight > v.height+1 || round > v.maxRound(sign.BlockHeight) {
+		return
+	}
+	rounds, exist := v.votes[sign.BlockHeight]
+	if !exist {
+		rounds = make(map[int]map[types.ID]bool)
+		v.votes[sign.BlockHeight] = rounds
+	}
+	if rounds[round] == nil {
+		rounds[round] = make(map[types.ID]bool)
+	}
+	rounds[round][sign.ID] = true
+
+	if sign.BlockHeight == v.height {
+		v.advance()
+	} else if sign.BlockHeight < v.height {
+		v.reopen(sign.BlockHeight)
+	}
+}
+
+// vote makes view change vote to move to the round
+func (v *viewChange) vote(height int, round int) signature.Signature {
+	hash := viewChangeHash(height, round)
+	blsSign := v.node.blsSecretKey.SignHash(hash[:])
+
+	return signature.New(v.node.id, signature.ViewChange, height, round, blsSign.Serialize())
+}
+
+// verify checks view change vote and returns the round voted to move to
+func (v *viewChange) verify(sign signature.Signature) (int, error) {
+	round := sign.Round
+	if round <= 0 {
+		return 0, errors.New("Invalid round")
+	}
+	address, exist := v.node.addressbook[sign.ID]
+	if !exist {
+		return 0, errors.New("Unknown validator")
+	}
+
+	pubkey := bls.PublicKey{}
+	if err := pubkey.DeserializeHexStr(address.PublicKey); err != nil {
+		return 0, err
+	}
+	blsSign := bls.Sign{}
+	if err := blsSign.Deserialize(sign.Payload); err != nil {
+		return 0, err
+	}
+	hash := viewChangeHash(sign.BlockHeight, round)
+	if !blsSign.VerifyHash(&pubkey, hash[:]) {
+		return 0, errors.New("Invalid signature")
+	}
+
+	return round, nil
+}
+
+func viewChangeHash(height int, round int) [32]byte {
+	return sha256.Sum256(codec.EncodeList(codec.EncodeString("view-change"), codec.EncodeInt(int64(height)), codec.EncodeInt(int64(round))))
+}
+
+// waitRound waits until the block belongs to the round being decided and
+// checks that the block is produced by the proposer of the round
+func (v *viewChange) waitRound(b block.Block) error {
+	for {
+		if v.node.isStopped() {
+			return errStopped
+		}
+		if err := v.node.checkKnownBlock(b); err != nil {
+			return err
+		}
+		if b.Header.Height == v.node.status.GetHeight()+1 {
+			// Block waits until the previous block is prepared
+			round, voted, deciding := v.state(b.Header.Height)
+			if deciding && (b.Header.Round < round || b.Header.Round < voted) {
+				return errStaleRound
+			}
+			if deciding && b.Header.Round == round {
+				break
+			}
+		}
+		v.node.clock.Sleep(10 * time.Millisecond)
+	}
+
+	if b.Header.Producer != v.proposer(b.Header.Height, b.Header.Round) {
+		return errors.New("Invalid producer")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/mempool"
+	"github.com/hdac-io/simulator/metrics"
+	"github.com/hdac-io/simulator/node/status"
+	"github.com/hdac-io/simulator/persistent"
+	"github.com/hdac-io/simulator/types"
+	"github.com/hdac-io/simulator/vrfmessage"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+// viewChangeNodes returns validators of the addressbook deciding rounds
+func viewChangeNodes(addressbook Addressbook) map[types.ID]*Node {
+	clock := clock.NewReal()
+	nodes := make(map[types.ID]*Node)
+	for _, id := range addressbook.IDs() {
+		n := &Node{
+			id:          id,
+			addressbook: addressbook,
+			parameter:   parameter{numValidators: len(addressbook)},
+			pool:        newSignaturePool(clock),
+			mempool:     mempool.New(10),
+			logger:      log.New(),
+			clock:       clock,
+		}
+		n.logger.SetHandler(log.DiscardHandler())
+		n.blsSecretKey.DeserializeHexStr(addressbook[id].Secret)
+		n.status = status.New(int64(id), len(addressbook), 0, persistent.New(), app.NewKVStore(), n.logger, clock, metrics.NewRegistry())
+		n.viewChange = newViewChange(n, time.Second)
+		nodes[id] = n
+	}
+	return nodes
+}
+
+func TestViewChangeAdvance(t *testing.T) {
+	addressbook := PrepareAddressbook()
+	nodes := viewChangeNodes(addressbook)
+	n := nodes[1]
+	round, _, _ := n.viewChange.state(1)
+	require.Equal(t, 0, round)
+
+	// Forged vote is rejected
+	forged := nodes[2].viewChange.vote(1, 1)
+	forged.ID = 3
+	_, err := n.viewChange.verify(forged)
+	require.NotNil(t, err)
+
+	// Round advances by quorum of votes
+	for i, id := range addressbook.IDs() {
+		round, _, _ = n.viewChange.state(1)
+		require.Equal(t, 0, round)
+		n.viewChange.add(nodes[id].viewChange.vote(1, 1))
+		if i+1 == n.viewChange.quorum() {
+			break
+		}
+	}
+	round, _, _ = n.viewChange.state(1)
+	require.Equal(t, 1, round)
+	require.Equal(t, []status.SkippedRound{{Height: 1, Round: 0, Proposer: 1}}, n.status.GetSkippedRounds())
+
+	// Block of the skipped round is stale
+	stale := block.New(block.BlockHeader{Height: 1, Producer: 1}, vrfmessage.VRFMessage{}, nil)
+	require.Equal(t, errStaleRound, n.viewChange.waitRound(stale))
+}
+
+func TestViewChangeBoundedVotes(t *testing.T) {
+	nodes := viewChangeNodes(PrepareAddressbook())
+	n := nodes[1]
+
+	// Votes beyond the next height or the round window are dropped
+	n.viewChange.add(nodes[2].viewChange.vote(3, 1))
+	n.viewChange.add(nodes[2].viewChange.vote(1, roundWindow+1))
+	require.Equal(t, 0, len(n.viewChange.votes))
+	n.viewChange.add(nodes[2].viewChange.vote(2, 1))
+	n.viewChange.add(nodes[2].viewChange.vote(1, roundWindow))
+	require.Equal(t, 2, len(n.viewChange.votes))
+
+	// Votes of finalized heights are pruned
+	n.viewChange.prune(1)
+	require.Equal(t, 1, len(n.viewChange.votes))
+	require.Equal(t, 1, len(n.viewChange.votes[2]))
+}
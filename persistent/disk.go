@@ -0,0 +1,154 @@
+package persistent
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/persistent/kv"
+	"github.com/hdac-io/simulator/signature"
+)
+
+// Keys of the store
+var (
+	finalizedHeightKey = []byte("height/finalized")
+	confirmedHeightKey = []byte("height/confirmed")
+)
+
+func blockKey(height int) []byte {
+	return append([]byte("block/"), heightBytes(height)...)
+}
+
+func signatureKey(height int) []byte {
+	return append([]byte("signature/"), heightBytes(height)...)
+}
+
+func heightBytes(height int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(height))
+	return buf
+}
+
+// disk keeps blocks in key-value store on disk
+type disk struct {
+	sync.RWMutex
+	db              *kv.DB
+	finalizedHeight int
+	confirmedHeight int
+}
+
+// Open opens Persistent stored at path, stored blocks and heights are reloaded
+func Open(path string) (Persistent, error) {
+	db, err := kv.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &disk{db: db}
+	if p.finalizedHeight, err = p.getHeight(finalizedHeightKey); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if p.confirmedHeight, err = p.getHeight(confirmedHeightKey); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *disk) getHeight(key []byte) (int, error) {
+	value, exist, err := p.db.Get(key)
+	if err != nil || !exist {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint64(value)), nil
+}
+
+// get returns decoded item stored at key
+func (p *disk) get(key []byte) codec.Item {
+	value, exist, err := p.db.Get(key)
+	if err != nil {
+		panic(err)
+	}
+	if !exist {
+		panic("Not stored height !")
+	}
+	item, err := codec.Decode(value)
+	if err != nil {
+		panic(err)
+	}
+	return item
+}
+
+// AddBlock stores block and signature
+func (p *disk) AddBlock(block block.Block, sign []signature.Signature) {
+	p.Lock()
+	defer p.Unlock()
+	if p.finalizedHeight != block.Header.Height-1 || len(sign) == 0 || sign[0].BlockHeight != block.Header.Height {
+		panic("Wrong block height !")
+	}
+
+	// Height is written after the block and signature so that torn write
+	// leaves previous height
+	var batch kv.Batch
+	batch.Put(blockKey(block.Header.Height), block.Encode())
+	batch.Put(signatureKey(block.Header.Height), signature.EncodeList(sign))
+	batch.Put(finalizedHeightKey, heightBytes(block.Header.Height))
+	if err := p.db.Write(&batch); err != nil {
+		panic(err)
+	}
+	p.finalizedHeight = block.Header.Height
+}
+
+// GetBlock retrieves block
+func (p *disk) GetBlock(height int) (block.Block, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if height < 1 || height > p.finalizedHeight {
+		return block.Block{}, ErrHeight
+	}
+	return block.Decode(p.get(blockKey(height)))
+}
+
+// GetSignature retrieves signature
+func (p *disk) GetSignature(height int) ([]signature.Signature, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if height < 1 || height > p.finalizedHeight {
+		return nil, ErrHeight
+	}
+	return signature.DecodeList(p.get(signatureKey(height)))
+}
+
+// SetConfirmedHeight stores confirmed height
+func (p *disk) SetConfirmedHeight(height int) {
+	p.Lock()
+	defer p.Unlock()
+	if height == p.confirmedHeight {
+		return
+	}
+	if err := p.db.Put(confirmedHeightKey, heightBytes(height)); err != nil {
+		panic(err)
+	}
+	p.confirmedHeight = height
+}
+
+// GetFinalizedHeight returns height of the last stored block
+func (p *disk) GetFinalizedHeight() int {
+	p.RLock()
+	defer p.RUnlock()
+	return p.finalizedHeight
+}
+
+// GetConfirmedHeight returns stored confirmed height
+func (p *disk) GetConfirmedHeight() int {
+	p.RLock()
+	defer p.RUnlock()
+	return p.confirmedHeight
+}
+
+// Close closes the store
+func (p *disk) Close() error {
+	return p.db.Close()
+}
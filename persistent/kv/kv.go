@@ -0,0 +1,172 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Record layout: crc32(4) | key length(4) | value length(4) | key | value,
+// crc32 covers lengths, key and value
+const headerSize = 12
+
+type entry struct {
+	offset int64 // Offset of the value
+	length int
+}
+
+// DB represents embedded key-value store on an append-only file.
+// Index of the keys is kept in memory, values are read from the file.
+type DB struct {
+	sync.RWMutex
+	file  *os.File
+	size  int64
+	index map[string]entry
+}
+
+// Batch collects writes applied together
+type Batch struct {
+	records []byte
+	keys    []string
+	lengths []int
+}
+
+// Put adds key and value to the batch
+func (b *Batch) Put(key []byte, value []byte) {
+	record := make([]byte, headerSize+len(key)+len(value))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(key)))
+	binary.BigEndian.PutUint32(record[8:], uint32(len(value)))
+	copy(record[headerSize:], key)
+	copy(record[headerSize+len(key):], value)
+	binary.BigEndian.PutUint32(record, crc32.ChecksumIEEE(record[4:]))
+
+	b.records = append(b.records, record...)
+	b.keys = append(b.keys, string(key))
+	b.lengths = append(b.lengths, len(value))
+}
+
+// Open opens or creates store at path, torn records at the end of the file
+// written by crash are dropped
+func Open(path string) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		file:  file,
+		index: make(map[string]entry),
+	}
+	if err := db.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) load() error {
+	info, err := db.file.Stat()
+	if err != nil {
+		return err
+	}
+	header := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := db.file.ReadAt(header, offset); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		keyLength := int(binary.BigEndian.Uint32(header[4:]))
+		valueLength := int(binary.BigEndian.Uint32(header[8:]))
+		if offset+headerSize+int64(keyLength+valueLength) > info.Size() {
+			break
+		}
+		body := make([]byte, keyLength+valueLength)
+		if _, err := db.file.ReadAt(body, offset+headerSize); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		checksum := crc32.Update(crc32.ChecksumIEEE(header[4:]), crc32.IEEETable, body)
+		if checksum != binary.BigEndian.Uint32(header) {
+			break
+		}
+
+		db.index[string(body[:keyLength])] = entry{offset: offset + headerSize + int64(keyLength), length: valueLength}
+		offset += headerSize + int64(keyLength+valueLength)
+	}
+
+	// Drop torn tail
+	if err := db.file.Truncate(offset); err != nil {
+		return err
+	}
+	db.size = offset
+	return nil
+}
+
+// Put stores value of the key
+func (db *DB) Put(key []byte, value []byte) error {
+	var b Batch
+	b.Put(key, value)
+	return db.Write(&b)
+}
+
+// Write appends the batch and syncs the file
+func (db *DB) Write(b *Batch) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.file == nil {
+		return errors.New("Closed store")
+	}
+	if _, err := db.file.WriteAt(b.records, db.size); err != nil {
+		return err
+	}
+	if err := db.file.Sync(); err != nil {
+		return err
+	}
+
+	offset := db.size
+	for i, key := range b.keys {
+		offset += headerSize + int64(len(key))
+		db.index[key] = entry{offset: offset, length: b.lengths[i]}
+		offset += int64(b.lengths[i])
+	}
+	db.size = offset
+	return nil
+}
+
+// Get retrieves value of the key
+func (db *DB) Get(key []byte) ([]byte, bool, error) {
+	db.RLock()
+	defer db.RUnlock()
+	e, exist := db.index[string(key)]
+	if !exist {
+		return nil, false, nil
+	}
+	if db.file == nil {
+		return nil, false, errors.New("Closed store")
+	}
+	value := make([]byte, e.length)
+	if _, err := db.file.ReadAt(value, e.offset); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Close closes the file
+func (db *DB) Close() error {
+	db.Lock()
+	defer db.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
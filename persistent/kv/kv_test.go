@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store")
+
+	db, err := Open(path)
+	require.Nil(t, err)
+	require.Nil(t, db.Put([]byte("a"), []byte("1")))
+	var b Batch
+	b.Put([]byte("b"), []byte("2"))
+	b.Put([]byte("a"), []byte("3"))
+	require.Nil(t, db.Write(&b))
+	require.Nil(t, db.Close())
+
+	db, err = Open(path)
+	require.Nil(t, err)
+	value, exist, err := db.Get([]byte("a"))
+	require.Nil(t, err)
+	require.True(t, exist)
+	require.Equal(t, []byte("3"), value)
+	value, _, _ = db.Get([]byte("b"))
+	require.Equal(t, []byte("2"), value)
+	_, exist, _ = db.Get([]byte("c"))
+	require.False(t, exist)
+	require.Nil(t, db.Close())
+}
+
+func TestTornRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kv")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store")
+
+	db, err := Open(path)
+	require.Nil(t, err)
+	require.Nil(t, db.Put([]byte("a"), []byte("1")))
+	require.Nil(t, db.Put([]byte("b"), []byte("2")))
+	require.Nil(t, db.Close())
+
+	// Crash in the middle of writing the last record
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Nil(t, os.Truncate(path, info.Size()-1))
+
+	db, err = Open(path)
+	require.Nil(t, err)
+	_, exist, _ := db.Get([]byte("b"))
+	require.False(t, exist)
+	value, _, _ := db.Get([]byte("a"))
+	require.Equal(t, []byte("1"), value)
+
+	// New record follows the last valid record
+	require.Nil(t, db.Put([]byte("c"), []byte("3")))
+	require.Nil(t, db.Close())
+	db, err = Open(path)
+	require.Nil(t, err)
+	value, _, _ = db.Get([]byte("c"))
+	require.Equal(t, []byte("3"), value)
+	require.Nil(t, db.Close())
+}
@@ -1,49 +1,89 @@
 package persistent
 
 import (
+	"errors"
+
 	"github.com/hdac-io/simulator/block"
 	"github.com/hdac-io/simulator/signature"
 )
 
-// Persistent represents persistent media
-type Persistent struct {
-	blocks     []block.Block
-	signatures [][]signature.Signature
+// ErrHeight is returned for height out of stored blocks
+var ErrHeight = errors.New("Block height out of range")
+
+// Persistent represents persistent media of finalized blocks
+type Persistent interface {
+	// AddBlock stores finalized block with its finalization signature
+	AddBlock(block block.Block, sign []signature.Signature)
+	// GetBlock retrieves finalized block
+	GetBlock(height int) (block.Block, error)
+	// GetSignature retrieves finalization signature of a block
+	GetSignature(height int) ([]signature.Signature, error)
+	// SetConfirmedHeight stores confirmed height
+	SetConfirmedHeight(height int)
+	// GetFinalizedHeight returns height of the last stored block
+	GetFinalizedHeight() int
+	// GetConfirmedHeight returns stored confirmed height
+	GetConfirmedHeight() int
+	// Close releases the media
+	Close() error
 }
 
-// New return inittial Persistent type
+// memory keeps blocks in memory
+type memory struct {
+	blocks          []block.Block
+	signatures      [][]signature.Signature
+	confirmedHeight int
+}
+
+// New return inittial in-memory Persistent
 func New() Persistent {
-	return Persistent{
+	return &memory{
 		blocks:     make([]block.Block, 0),
 		signatures: make([][]signature.Signature, 0),
 	}
 }
 
-// AddBlock stores block
-func (p *Persistent) AddBlock(block block.Block) {
-	if len(p.blocks) != block.Header.Height-1 {
+// AddBlock stores block and signature
+func (p *memory) AddBlock(block block.Block, sign []signature.Signature) {
+	if len(p.blocks) != block.Header.Height-1 || len(sign) == 0 || sign[0].BlockHeight != block.Header.Height {
 		panic("Wrong block height !")
 	}
 	p.blocks = append(p.blocks, block)
+	p.signatures = append(p.signatures, sign)
 }
 
 // GetBlock retrieves block
-func (p *Persistent) GetBlock(height int) block.Block {
-	return p.blocks[height-1]
-}
-
-// AddSignature stores signature
-func (p *Persistent) AddSignature(sign []signature.Signature) {
-	if len(sign) == 0 || len(p.signatures) != sign[0].BlockHeight-1 {
-		panic("Wrong block height !")
+func (p *memory) GetBlock(height int) (block.Block, error) {
+	if height < 1 || height > len(p.blocks) {
+		return block.Block{}, ErrHeight
 	}
-	p.signatures = append(p.signatures, sign)
+	return p.blocks[height-1], nil
 }
 
 // GetSignature retrieves signature
-func (p *Persistent) GetSignature(height int) []signature.Signature {
-	if height < 1 {
-		return []signature.Signature{}
+func (p *memory) GetSignature(height int) ([]signature.Signature, error) {
+	if height < 1 || height > len(p.signatures) {
+		return nil, ErrHeight
 	}
-	return p.signatures[height-1]
+	return p.signatures[height-1], nil
+}
+
+// SetConfirmedHeight stores confirmed height
+func (p *memory) SetConfirmedHeight(height int) {
+	p.confirmedHeight = height
+}
+
+// GetFinalizedHeight returns height of the last stored block
+func (p *memory) GetFinalizedHeight() int {
+	return len(p.blocks)
+}
+
+// GetConfirmedHeight returns stored confirmed height
+func (p *memory) GetConfirmedHeight() int {
+	return p.confirmedHeight
+}
+
+// Close does nothing
+func (p *memory) Close() error {
+	return nil
 }
@@ -0,0 +1,94 @@
+package persistent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/vrfmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func testBlock(height int) block.Block {
+	return block.New(block.BlockHeader{Height: height, Timestamp: int64(height), Producer: 1}, vrfmessage.VRFMessage{}, nil)
+}
+
+func testSignature(height int) []signature.Signature {
+	return []signature.Signature{signature.New(1, signature.Commit, height, 0, []byte{byte(height)})}
+}
+
+func TestDiskReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persistent")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "validator.db")
+
+	p, err := Open(path)
+	require.Nil(t, err)
+	require.Equal(t, 0, p.GetFinalizedHeight())
+	for height := 1; height <= 3; height++ {
+		p.AddBlock(testBlock(height), testSignature(height))
+	}
+	p.SetConfirmedHeight(2)
+	require.Nil(t, p.Close())
+
+	p, err = Open(path)
+	require.Nil(t, err)
+	require.Equal(t, 3, p.GetFinalizedHeight())
+	require.Equal(t, 2, p.GetConfirmedHeight())
+	b, err := p.GetBlock(2)
+	require.Nil(t, err)
+	require.Equal(t, int64(2), b.Header.Timestamp)
+	signs, err := p.GetSignature(3)
+	require.Nil(t, err)
+	require.Equal(t, []byte{3}, signs[0].Payload)
+	_, err = p.GetBlock(0)
+	require.Equal(t, ErrHeight, err)
+	_, err = p.GetSignature(4)
+	require.Equal(t, ErrHeight, err)
+	require.Panics(t, func() {
+		p.AddBlock(testBlock(5), testSignature(5))
+	})
+	require.Nil(t, p.Close())
+}
+
+func TestDiskTornBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "persistent")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "validator.db")
+
+	p, err := Open(path)
+	require.Nil(t, err)
+	for height := 1; height <= 2; height++ {
+		p.AddBlock(testBlock(height), testSignature(height))
+	}
+	require.Nil(t, p.Close())
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+
+	p, err = Open(path)
+	require.Nil(t, err)
+	p.AddBlock(testBlock(3), testSignature(3))
+	require.Nil(t, p.Close())
+
+	// Crash right after the block record of height 3 with 12 bytes header
+	key := blockKey(3)
+	value := testBlock(3).Encode()
+	require.Nil(t, os.Truncate(path, info.Size()+int64(12+len(key)+len(value))))
+
+	p, err = Open(path)
+	require.Nil(t, err)
+	require.Equal(t, 2, p.GetFinalizedHeight())
+	signs, err := p.GetSignature(2)
+	require.Nil(t, err)
+	require.Equal(t, []byte{2}, signs[0].Payload)
+	p.AddBlock(testBlock(3), testSignature(3))
+	signs, err = p.GetSignature(3)
+	require.Nil(t, err)
+	require.Equal(t, []byte{3}, signs[0].Payload)
+	require.Nil(t, p.Close())
+}
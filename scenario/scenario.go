@@ -0,0 +1,602 @@
+package scenario
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/hdac-io/simulator/app"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/load"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/node"
+	"github.com/hdac-io/simulator/types"
+)
+
+// Duration is time.Duration written as "1s" or "150ms" in scenario file
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// MarshalJSON writes duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Key represents BLS key pair of a validator
+type Key struct {
+	ID        types.ID `json:"id"`
+	Secret    string   `json:"secret"`
+	PublicKey string   `json:"publicKey"`
+}
+
+// Link represents one-way link between validators
+type Link struct {
+	From  types.ID `json:"from"`
+	To    types.ID `json:"to"`
+	Delay string   `json:"delay,omitempty"`
+	Loss  *float64 `json:"loss,omitempty"`
+
+	delay net.DelaySpec
+}
+
+// Fault represents byzantine behavior of a validator between start and stop heights
+type Fault struct {
+	Validator types.ID `json:"validator"`
+	Kind      string   `json:"kind"`
+	Start     int      `json:"start,omitempty"`
+	Stop      int      `json:"stop,omitempty"`
+}
+
+// Churn represents crash of a validator at stop and its restart, times are
+// from genesis and zero restart keeps the validator stopped
+type Churn struct {
+	Validator types.ID `json:"validator"`
+	Stop      Duration `json:"stop"`
+	Restart   Duration `json:"restart,omitempty"`
+}
+
+// Join represents validator started late at time from genesis
+type Join struct {
+	Validator types.ID `json:"validator"`
+	At        Duration `json:"at"`
+}
+
+// Partition represents split of validators into groups, validators not in
+// any group form another group. It starts at time from genesis or when a
+// validator finalizes the height. Messages between groups are dropped, or
+// held if hold is set, until the partition heals after duration. Zero
+// duration keeps the partition.
+type Partition struct {
+	At       Duration     `json:"at,omitempty"`
+	Height   int          `json:"height,omitempty"`
+	Duration Duration     `json:"duration,omitempty"`
+	Groups   [][]types.ID `json:"groups"`
+	Hold     bool         `json:"hold,omitempty"`
+}
+
+// MessageFault represents faults injected to messages of the kind on
+// one-way links from -> to, zero from or to means every validator. Loads of
+// the kind are dropped, duplicated or delayed behind later ones with the
+// probabilities, empty kind applies to messages of other kinds.
+type MessageFault struct {
+	From         types.ID `json:"from,omitempty"`
+	To           types.ID `json:"to,omitempty"`
+	Kind         string   `json:"kind,omitempty"`
+	Drop         float64  `json:"drop,omitempty"`
+	Duplicate    float64  `json:"duplicate,omitempty"`
+	Reorder      float64  `json:"reorder,omitempty"`
+	ReorderDelay Duration `json:"reorderDelay,omitempty"`
+}
+
+func (f MessageFault) spec() net.FaultSpec {
+	return net.FaultSpec{
+		Drop:         f.Drop,
+		Duplicate:    f.Duplicate,
+		Reorder:      f.Reorder,
+		ReorderDelay: f.ReorderDelay.Duration,
+	}
+}
+
+// Bandwidth represents uplink and downlink of a validator, written as
+// "100Mbps", empty is unlimited
+type Bandwidth struct {
+	Validator types.ID `json:"validator,omitempty"`
+	Uplink    string   `json:"uplink,omitempty"`
+	Downlink  string   `json:"downlink,omitempty"`
+
+	uplink   net.Bandwidth
+	downlink net.Bandwidth
+}
+
+func (b *Bandwidth) parse() error {
+	var err error
+	if b.Uplink != "" {
+		if b.uplink, err = net.ParseBandwidth(b.Uplink); err != nil {
+			return err
+		}
+	}
+	if b.Downlink != "" {
+		if b.downlink, err = net.ParseBandwidth(b.Downlink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Network represents links between validators, every pair is linked unless
+// degree is given. Bandwidth applies to every validator unless configured
+// for the validator.
+type Network struct {
+	Delay    string  `json:"delay,omitempty"`
+	Loss     float64 `json:"loss,omitempty"`
+	Degree   int     `json:"degree,omitempty"`
+	Uplink   string  `json:"uplink,omitempty"`
+	Downlink string  `json:"downlink,omitempty"`
+
+	delay     net.DelaySpec
+	bandwidth Bandwidth
+}
+
+// Regions represents placement of validators in regions linked with
+// round-trip times of RTT matrix read from CSV file relative to the scenario
+// file. Validators not placed are assigned to regions in turn.
+type Regions struct {
+	RTT       string                `json:"rtt"`
+	Jitter    float64               `json:"jitter,omitempty"`
+	Placement map[string][]types.ID `json:"placement,omitempty"`
+
+	matrix *net.RTTMatrix
+}
+
+// Gossip represents dissemination of messages by relaying them to random peers
+type Gossip struct {
+	Fanout    int `json:"fanout"`
+	TTL       int `json:"ttl,omitempty"`
+	CacheSize int `json:"cacheSize,omitempty"`
+}
+
+// Scenario describes a full simulation run
+type Scenario struct {
+	Name string `json:"name,omitempty"`
+
+	// Validators are generated from the seed unless keys are given
+	Validators int   `json:"validators"`
+	Keys       []Key `json:"keys,omitempty"`
+	Seed       int64 `json:"seed"`
+
+	// Consensus
+	Consensus    string   `json:"consensus,omitempty"`
+	LenULB       *int     `json:"lenULB,omitempty"`
+	BlockTime    Duration `json:"blockTime,omitempty"`
+	RoundTimeout Duration `json:"roundTimeout,omitempty"`
+
+	// Transactions, load is submitted by each validator and executed on the application
+	App           string `json:"app,omitempty"`
+	Load          string `json:"load,omitempty"`
+	MaxBlockTxs   int    `json:"maxBlockTxs,omitempty"`
+	MaxBlockBytes int    `json:"maxBlockBytes,omitempty"`
+	MempoolSize   int    `json:"mempoolSize,omitempty"`
+
+	// Network
+	Network Network  `json:"network"`
+	Links   []Link   `json:"links,omitempty"`
+	Regions *Regions `json:"regions,omitempty"`
+
+	// Bandwidths of validators overriding bandwidth of the network
+	Bandwidths []Bandwidth `json:"bandwidths,omitempty"`
+	Gossip     *Gossip     `json:"gossip,omitempty"`
+
+	// Faults injected to messages, later ones override earlier ones
+	MessageFaults []MessageFault `json:"messageFaults,omitempty"`
+
+	// Byzantine behaviors
+	Faults []Fault `json:"faults,omitempty"`
+
+	// Crashes and restarts of validators, in time order of each validator
+	Churn []Churn `json:"churn,omitempty"`
+
+	// Validators started after genesis
+	Joins []Join `json:"joins,omitempty"`
+
+	// Partitions of the network, in order
+	Partitions []Partition `json:"partitions,omitempty"`
+
+	// Run
+	Virtual  bool     `json:"virtual"`
+	Duration Duration `json:"duration"`
+
+	load    load.Spec
+	dir     string
+	regions map[types.ID]string
+}
+
+// Load reads scenario file
+func Load(path string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(data, filepath.Dir(path))
+}
+
+// Parse parses and validates scenario, files are relative to working directory
+func Parse(data []byte) (*Scenario, error) {
+	return parse(data, "")
+}
+
+func parse(data []byte, dir string) (*Scenario, error) {
+	s := &Scenario{dir: dir}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Scenario) validate() error {
+	if len(s.Keys) > 0 {
+		if s.Validators != 0 && s.Validators != len(s.Keys) {
+			return errors.New("Number of keys does not match validators")
+		}
+		s.Validators = len(s.Keys)
+		for i, key := range s.Keys {
+			if key.ID != types.ID(i+1) {
+				return errors.New("Keys must have IDs from 1 in order")
+			}
+		}
+	}
+	if s.Validators <= 0 {
+		return errors.New("No validators")
+	}
+	if s.Consensus != "" && !node.IsConsensus(s.Consensus) {
+		return errors.New("Unknown consensus: " + s.Consensus)
+	}
+	if s.Duration.Duration <= 0 {
+		return errors.New("Invalid duration")
+	}
+
+	var err error
+	if s.Load != "" {
+		if s.load, err = load.ParseSpec(s.Load); err != nil {
+			return err
+		}
+	}
+	if s.App != "" && !app.IsApplication(s.App) {
+		return errors.New("Unknown application: " + s.App)
+	}
+	if s.MaxBlockTxs < 0 || s.MaxBlockBytes < 0 || s.MempoolSize < 0 {
+		return errors.New("Negative transaction limit")
+	}
+	if s.Network.Delay == "" {
+		s.Network.Delay = "constant:0s"
+	}
+	if s.Network.delay, err = net.ParseDelaySpec(s.Network.Delay); err != nil {
+		return err
+	}
+	if err := validateLoss(s.Network.Loss); err != nil {
+		return err
+	}
+	s.Network.bandwidth = Bandwidth{Uplink: s.Network.Uplink, Downlink: s.Network.Downlink}
+	if err := s.Network.bandwidth.parse(); err != nil {
+		return err
+	}
+	for i := range s.Bandwidths {
+		if !s.isValidator(s.Bandwidths[i].Validator) {
+			return fmt.Errorf("Unknown validator of bandwidth %d", s.Bandwidths[i].Validator)
+		}
+		if err := s.Bandwidths[i].parse(); err != nil {
+			return err
+		}
+	}
+	if s.Network.Degree < 0 || s.Network.Degree == 1 {
+		return errors.New("Degree must be at least 2")
+	}
+	if s.Network.Degree > 0 && s.Gossip == nil {
+		return errors.New("Partial connectivity requires gossip")
+	}
+	if s.Gossip != nil && (s.Gossip.Fanout <= 0 || s.Gossip.TTL < 0 || s.Gossip.CacheSize < 0) {
+		return errors.New("Invalid gossip")
+	}
+	for i := range s.Links {
+		link := &s.Links[i]
+		if !s.isValidator(link.From) || !s.isValidator(link.To) {
+			return fmt.Errorf("Unknown validator of link %d -> %d", link.From, link.To)
+		}
+		if link.Delay == "" {
+			link.delay = s.Network.delay
+		} else if link.delay, err = net.ParseDelaySpec(link.Delay); err != nil {
+			return err
+		}
+		if link.Loss != nil {
+			if err := validateLoss(*link.Loss); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.Regions != nil {
+		if err := s.placeRegions(); err != nil {
+			return err
+		}
+	}
+
+	for _, fault := range s.MessageFaults {
+		if (fault.From != 0 && !s.isValidator(fault.From)) || (fault.To != 0 && !s.isValidator(fault.To)) {
+			return fmt.Errorf("Unknown validator of message fault %d -> %d", fault.From, fault.To)
+		}
+		if fault.Kind != "" && !node.IsLoadKind(fault.Kind) {
+			return errors.New("Unknown message kind: " + fault.Kind)
+		}
+		if err := fault.spec().Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, fault := range s.Faults {
+		if !s.isValidator(fault.Validator) {
+			return fmt.Errorf("Unknown byzantine validator %d", fault.Validator)
+		}
+		if !node.IsFaultKind(fault.Kind) {
+			return errors.New("Unknown fault: " + fault.Kind)
+		}
+		if fault.Stop != 0 && fault.Stop < fault.Start {
+			return errors.New("Fault stops before start")
+		}
+	}
+
+	joins := make(map[types.ID]time.Duration)
+	for _, join := range s.Joins {
+		if !s.isValidator(join.Validator) {
+			return fmt.Errorf("Unknown joining validator %d", join.Validator)
+		}
+		if join.At.Duration <= 0 {
+			return errors.New("Invalid join time")
+		}
+		if _, exist := joins[join.Validator]; exist {
+			return fmt.Errorf("Validator %d joins twice", join.Validator)
+		}
+		joins[join.Validator] = join.At.Duration
+	}
+
+	// Validator is restarted before it stops again
+	restarts := make(map[types.ID]time.Duration)
+	for _, churn := range s.Churn {
+		if !s.isValidator(churn.Validator) {
+			return fmt.Errorf("Unknown churn validator %d", churn.Validator)
+		}
+		if churn.Stop.Duration <= 0 {
+			return errors.New("Invalid churn stop")
+		}
+		if churn.Restart.Duration != 0 && churn.Restart.Duration <= churn.Stop.Duration {
+			return errors.New("Churn restarts before stop")
+		}
+		if join, exist := joins[churn.Validator]; exist && churn.Stop.Duration <= join {
+			return fmt.Errorf("Validator %d stops before join", churn.Validator)
+		}
+		restart, exist := restarts[churn.Validator]
+		if exist && (restart == 0 || restart > churn.Stop.Duration) {
+			return fmt.Errorf("Overlapping churn of validator %d", churn.Validator)
+		}
+		restarts[churn.Validator] = churn.Restart.Duration
+	}
+
+	for i, partition := range s.Partitions {
+		if (partition.At.Duration > 0) == (partition.Height > 0) {
+			return errors.New("Partition starts at either time or height")
+		}
+		if partition.At.Duration < 0 || partition.Height < 0 || partition.Duration.Duration < 0 {
+			return errors.New("Invalid partition")
+		}
+		if len(partition.Groups) == 0 {
+			return errors.New("Partition without groups")
+		}
+		grouped := make(map[types.ID]bool)
+		for _, group := range partition.Groups {
+			for _, id := range group {
+				if !s.isValidator(id) {
+					return fmt.Errorf("Unknown partitioned validator %d", id)
+				}
+				if grouped[id] {
+					return fmt.Errorf("Validator %d is in two groups", id)
+				}
+				grouped[id] = true
+			}
+		}
+		if partition.Duration.Duration == 0 && i < len(s.Partitions)-1 {
+			return errors.New("Partition after permanent partition")
+		}
+	}
+
+	return nil
+}
+
+// placeRegions reads RTT matrix and places validators in regions
+func (s *Scenario) placeRegions() error {
+	path := s.Regions.RTT
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.dir, path)
+	}
+	matrix, err := net.LoadRTTMatrix(path)
+	if err != nil {
+		return err
+	}
+	if s.Regions.Jitter < 0 {
+		return errors.New("Negative jitter")
+	}
+	s.Regions.matrix = matrix
+
+	s.regions = make(map[types.ID]string)
+	for region, ids := range s.Regions.Placement {
+		if !matrix.HasRegion(region) {
+			return errors.New("Unknown region: " + region)
+		}
+		for _, id := range ids {
+			if !s.isValidator(id) {
+				return fmt.Errorf("Unknown validator %d in region %s", id, region)
+			}
+			if _, exist := s.regions[id]; exist {
+				return fmt.Errorf("Validator %d is in two regions", id)
+			}
+			s.regions[id] = region
+		}
+	}
+	next := 0
+	for id := types.ID(1); int(id) <= s.Validators; id++ {
+		if _, exist := s.regions[id]; !exist {
+			s.regions[id] = matrix.Regions[next%len(matrix.Regions)]
+			next++
+		}
+	}
+	return nil
+}
+
+// Region returns region of the validator, empty if validators are not placed
+func (s *Scenario) Region(id types.ID) string {
+	return s.regions[id]
+}
+
+func validateLoss(loss float64) error {
+	if loss < 0 || loss > 1 {
+		return errors.New("Loss must be in [0, 1]")
+	}
+	return nil
+}
+
+func (s *Scenario) isValidator(id types.ID) bool {
+	return id >= 1 && int(id) <= s.Validators
+}
+
+// JoinTime returns time from genesis when the validator joins, false if it starts at genesis
+func (s *Scenario) JoinTime(id types.ID) (time.Duration, bool) {
+	for _, join := range s.Joins {
+		if join.Validator == id {
+			return join.At.Duration, true
+		}
+	}
+	return 0, false
+}
+
+// Config returns configuration of the scenario
+func (s *Scenario) Config() *config.Config {
+	c := config.GetDefault()
+	if s.Consensus != "" {
+		c.Consensus.Algorithm = s.Consensus
+	}
+	if s.LenULB != nil {
+		c.Consensus.LenULB = *s.LenULB
+	}
+	if s.BlockTime.Duration > 0 {
+		c.Consensus.BlockTime = s.BlockTime.Duration
+	}
+	if s.RoundTimeout.Duration > 0 {
+		c.Consensus.RoundTimeout = s.RoundTimeout.Duration
+	}
+	if s.App != "" {
+		c.App.Name = s.App
+	}
+	c.Mempool.Load = s.load
+	if s.MaxBlockTxs > 0 {
+		c.Block.MaxTransactions = s.MaxBlockTxs
+	}
+	if s.MaxBlockBytes > 0 {
+		c.Block.MaxBytes = s.MaxBlockBytes
+	}
+	if s.MempoolSize > 0 {
+		c.Mempool.Size = s.MempoolSize
+	}
+	c.Network.Degree = s.Network.Degree
+	if s.Gossip != nil {
+		c.Gossip.Fanout = s.Gossip.Fanout
+		if s.Gossip.TTL > 0 {
+			c.Gossip.TTL = s.Gossip.TTL
+		}
+		if s.Gossip.CacheSize > 0 {
+			c.Gossip.CacheSize = s.Gossip.CacheSize
+		}
+	}
+	c.Simulation.Virtual = s.Virtual
+	c.Simulation.Seed = s.Seed
+	for _, fault := range s.Faults {
+		c.Byzantine.Faults[fault.Validator] = append(c.Byzantine.Faults[fault.Validator],
+			config.Fault{Kind: fault.Kind, Start: fault.Start, Stop: fault.Stop})
+	}
+
+	return c
+}
+
+// Addressbook returns addressbook of validators
+func (s *Scenario) Addressbook() node.Addressbook {
+	if len(s.Keys) == 0 {
+		return node.GenerateAddressbook(s.Validators, s.Seed)
+	}
+
+	addressbook := make(node.Addressbook)
+	for _, key := range s.Keys {
+		addressbook.Add(key.ID, fmt.Sprintf("127.0.0.1:%d", 7000+key.ID), key.Secret, key.PublicKey)
+	}
+	return addressbook
+}
+
+// NewSimulation constructs simulated network of the scenario driven by clock
+func (s *Scenario) NewSimulation(clock clock.Clock, addressbook node.Addressbook) *net.Simulation {
+	simulation := net.NewSimulation(clock, s.Seed, s.Network.delay)
+	simulation.SetLoss(s.Network.Loss)
+	simulation.SetClassifier(node.LoadKind)
+	if s.Regions != nil {
+		simulation.SetRTTMatrix(s.Regions.matrix, s.Regions.Jitter)
+		for id, region := range s.regions {
+			simulation.SetRegion(addressbook[id].Address, region)
+		}
+	}
+	simulation.SetSizer(node.LoadSize)
+	simulation.SetBandwidth(s.Network.bandwidth.uplink, s.Network.bandwidth.downlink)
+	for _, bandwidth := range s.Bandwidths {
+		simulation.SetNodeBandwidth(addressbook[bandwidth.Validator].Address, bandwidth.uplink, bandwidth.downlink)
+	}
+	for _, link := range s.Links {
+		from := addressbook[link.From].Address
+		to := addressbook[link.To].Address
+		simulation.SetLinkDelay(from, to, link.delay)
+		if link.Loss != nil {
+			simulation.SetLinkLoss(from, to, *link.Loss)
+		}
+	}
+	for _, fault := range s.MessageFaults {
+		if fault.From == 0 && fault.To == 0 {
+			simulation.SetFaults(fault.Kind, fault.spec())
+			continue
+		}
+		for _, from := range addressbook.IDs() {
+			for _, to := range addressbook.IDs() {
+				if from == to || (fault.From != 0 && from != fault.From) || (fault.To != 0 && to != fault.To) {
+					continue
+				}
+				simulation.SetLinkFaults(addressbook[from].Address, addressbook[to].Address, fault.Kind, fault.spec())
+			}
+		}
+	}
+
+	return simulation
+}
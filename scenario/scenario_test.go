@@ -0,0 +1,235 @@
+package scenario
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hdac-io/simulator/bls"
+	"github.com/hdac-io/simulator/clock"
+	"github.com/hdac-io/simulator/config"
+	"github.com/hdac-io/simulator/load"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/node"
+	"github.com/hdac-io/simulator/types"
+	log "github.com/inconshreveable/log15"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	bls.Init(bls.CurveFp254BNb)
+	os.Exit(m.Run())
+}
+
+// loadFile reads the scenario file
+func loadFile(t *testing.T, path string) *Scenario {
+	s, err := Load(path)
+	require.NoError(t, err)
+	return s
+}
+
+// run runs every validator of the scenario from genesis on virtual clock,
+// logs are written to handler
+func run(t *testing.T, s *Scenario, handler log.Handler) []*node.Node {
+	log.Root().SetHandler(handler)
+	defer log.Root().SetHandler(log.StdoutHandler)
+
+	// Same genesis as virtual run of friday, proposers depend on timestamps
+	epoch := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	genesisTime := epoch.Add(5 * time.Second)
+	c := clock.NewVirtual(epoch)
+	addressbook := s.Addressbook()
+	config := s.Config()
+	simulation := s.NewSimulation(c, addressbook)
+
+	nodes := make([]*node.Node, 0)
+	var wg sync.WaitGroup
+	for _, id := range addressbook.IDs() {
+		n := node.NewValidator(id, addressbook, config, simulation.Listen(addressbook[id].Address), c)
+		nodes = append(nodes, n)
+		wg.Add(1)
+		c.Go(func() { n.Start(genesisTime, &wg) })
+	}
+	c.Run(genesisTime.Sub(c.Now()) + s.Duration.Duration)
+	return nodes
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`{
+		"validators": 4,
+		"seed": 7,
+		"lenULB": 0,
+		"blockTime": "500ms",
+		"network": {"delay": "constant:10ms", "loss": 0.1, "degree": 2, "uplink": "100Mbps"},
+		"bandwidths": [{"validator": 1, "uplink": "1Gbps", "downlink": "1.5Mbps"}],
+		"gossip": {"fanout": 2},
+		"messageFaults": [{"kind": "prepare", "duplicate": 0.5, "reorder": 0.2, "reorderDelay": "50ms"}, {"from": 1, "drop": 0.1}],
+		"links": [{"from": 1, "to": 2, "delay": "uniform:10ms:20ms"}],
+		"faults": [{"validator": 3, "kind": "silent", "start": 5, "stop": 10}],
+		"churn": [{"validator": 2, "stop": "5s", "restart": "10s"}, {"validator": 2, "stop": "20s"}],
+		"joins": [{"validator": 4, "at": "3s"}],
+		"partitions": [{"at": "5s", "duration": "3s", "groups": [[1, 2], [3, 4]]}, {"height": 20, "groups": [[1]], "hold": true}],
+		"app": "noop",
+		"load": "poisson:50:128",
+		"maxBlockTxs": 100,
+		"virtual": true,
+		"duration": "30s"
+	}`))
+	require.Nil(t, err)
+	require.Equal(t, 30*time.Second, s.Duration.Duration)
+	require.Equal(t, 10*time.Second, s.Churn[0].Restart.Duration)
+	join, late := s.JoinTime(4)
+	require.True(t, late)
+	require.Equal(t, 3*time.Second, join)
+	_, late = s.JoinTime(1)
+	require.False(t, late)
+	require.Equal(t, 3*time.Second, s.Partitions[0].Duration.Duration)
+	require.Equal(t, 20, s.Partitions[1].Height)
+	require.Equal(t, 50*time.Millisecond, s.MessageFaults[0].ReorderDelay.Duration)
+	require.Equal(t, net.Bandwidth(100*1000*1000), s.Network.bandwidth.uplink)
+	require.Equal(t, net.Bandwidth(1500*1000), s.Bandwidths[0].downlink)
+
+	c := s.Config()
+	require.Equal(t, 0, c.Consensus.LenULB)
+	require.Equal(t, 500*time.Millisecond, c.Consensus.BlockTime)
+	require.Equal(t, int64(7), c.Simulation.Seed)
+	require.True(t, c.Simulation.Virtual)
+	require.Equal(t, []config.Fault{{Kind: "silent", Start: 5, Stop: 10}}, c.Byzantine.Faults[3])
+	require.Equal(t, load.Spec{Pattern: load.Poisson, Rate: 50, Size: 128, Payload: load.Random}, c.Mempool.Load)
+	require.Equal(t, 100, c.Block.MaxTransactions)
+	require.Equal(t, "noop", c.App.Name)
+	require.Equal(t, 2, c.Network.Degree)
+	require.Equal(t, 2, c.Gossip.Fanout)
+	require.Equal(t, 8, c.Gossip.TTL)
+
+	addressbook := s.Addressbook()
+	require.Equal(t, []types.ID{1, 2, 3, 4}, addressbook.IDs())
+	// Keys are generated from the seed
+	require.Equal(t, addressbook, s.Addressbook())
+}
+
+func TestLoadRegions(t *testing.T) {
+	s, err := Load("../scenarios/global.json")
+	require.NoError(t, err)
+	require.Equal(t, "ap-northeast", s.Region(7))
+	// Validators not placed are assigned to regions in turn
+	require.Equal(t, "us-east", s.Region(8))
+	require.Equal(t, "us-west", s.Region(9))
+	require.Equal(t, "us-east", s.Region(14))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, data := range []string{
+		`{"validators": 0, "duration": "1s"}`,
+		`{"validators": 4}`,
+		`{"validators": 4, "duration": "1s", "consensus": "pow"}`,
+		`{"validators": 4, "duration": "1s", "network": {"delay": "exponential:1s"}}`,
+		`{"validators": 4, "duration": "1s", "network": {"loss": 2}}`,
+		`{"validators": 4, "duration": "1s", "links": [{"from": 1, "to": 5}]}`,
+		`{"validators": 4, "duration": "1s", "network": {"uplink": "100MB"}}`,
+		`{"validators": 4, "duration": "1s", "regions": {"rtt": "missing.csv"}}`,
+		`{"validators": 4, "duration": "1s", "bandwidths": [{"validator": 5, "downlink": "1Mbps"}]}`,
+		`{"validators": 4, "duration": "1s", "faults": [{"validator": 1, "kind": "sleeping"}]}`,
+		`{"validators": 4, "duration": "1s", "faults": [{"validator": 1, "kind": "silent", "start": 5, "stop": 3}]}`,
+		`{"validators": 4, "duration": "1s", "churn": [{"validator": 1, "stop": "5s", "restart": "3s"}]}`,
+		`{"validators": 4, "duration": "1s", "load": "poisson:100"}`,
+		`{"validators": 4, "duration": "1s", "app": "evm"}`,
+		`{"validators": 4, "duration": "1s", "network": {"degree": 2}}`,
+		`{"validators": 4, "duration": "1s", "gossip": {"fanout": 0}}`,
+		`{"validators": 4, "duration": "1s", "partitions": [{"groups": [[1]]}]}`,
+		`{"validators": 4, "duration": "1s", "messageFaults": [{"kind": "signature", "drop": 0.1}]}`,
+		`{"validators": 4, "duration": "1s", "messageFaults": [{"to": 5, "duplicate": 0.1}]}`,
+		`{"validators": 4, "duration": "1s", "messageFaults": [{"kind": "block", "reorder": 1.5}]}`,
+		`{"validators": 4, "duration": "1s", "partitions": [{"at": "1s", "groups": [[1, 2], [2]]}]}`,
+		`{"validators": 4, "duration": "1s", "partitions": [{"at": "1s", "groups": [[1]]}, {"at": "2s", "groups": [[2]]}]}`,
+		`{"validators": 4, "duration": "1s", "churn": [{"validator": 1, "stop": "5s"}, {"validator": 1, "stop": "9s"}]}`,
+		`{"validators": 4, "duration": "1s", "joins": [{"validator": 1, "at": "5s"}], "churn": [{"validator": 1, "stop": "3s"}]}`,
+	} {
+		_, err := Parse([]byte(data))
+		require.NotNil(t, err, data)
+	}
+}
+
+func TestSilentProposer(t *testing.T) {
+	nodes := run(t, loadFile(t, "../scenarios/silent-proposer.json"), log.DiscardHandler())
+
+	// Rounds of the silent proposer are skipped and the chain goes on
+	skipped := 0
+	for _, n := range nodes {
+		require.True(t, n.FinalizedHeight() > 30)
+		for height := 10; height <= 30; height++ {
+			b, err := n.FinalizedBlock(height)
+			require.NoError(t, err)
+			if b.Header.Round > 0 {
+				skipped++
+			}
+		}
+	}
+	require.True(t, skipped > 0)
+}
+
+func TestBandwidth(t *testing.T) {
+	nodes := run(t, loadFile(t, "../scenarios/bandwidth.json"), log.DiscardHandler())
+
+	// Block of the slow proposer is not delivered in time, its round is
+	// skipped and block of the next proposer is finalized
+	skipped := 0
+	for height := 1; height <= nodes[0].FinalizedHeight() && skipped == 0; height++ {
+		b, err := nodes[0].FinalizedBlock(height)
+		require.NoError(t, err)
+		if b.Header.Round > 0 {
+			skipped = height
+		}
+	}
+	require.True(t, skipped > 0)
+
+	// Quorum goes on past the skipped round
+	finalized := 0
+	for _, n := range nodes {
+		if n.FinalizedHeight() > skipped {
+			finalized++
+		}
+	}
+	require.True(t, finalized > len(nodes)*2/3)
+}
+
+func TestEquivocation(t *testing.T) {
+	for _, consensus := range node.Consensuses() {
+		s := loadFile(t, "../scenarios/equivocation.json")
+		s.Consensus = consensus
+		// Votes on the other block of the equivocator are not faults of honest validators
+		var lock sync.Mutex
+		suspects := make(map[interface{}]bool)
+		nodes := run(t, s, log.FuncHandler(func(r *log.Record) error {
+			lock.Lock()
+			defer lock.Unlock()
+			for i := 0; i+1 < len(r.Ctx); i += 2 {
+				if r.Ctx[i] == "Suspect" {
+					suspects[r.Ctx[i+1]] = true
+				}
+			}
+			return nil
+		}))
+		for suspect := range suspects {
+			require.Equal(t, types.ID(3), suspect, consensus)
+		}
+
+		// Honest validators skip rounds of the equivocator and go on
+		skipped := 0
+		for _, n := range nodes {
+			if n.ID() == 3 {
+				continue
+			}
+			require.True(t, n.FinalizedHeight() > 40, consensus)
+			for height := 1; height <= 40; height++ {
+				b, err := n.FinalizedBlock(height)
+				require.NoError(t, err)
+				if b.Header.Round > 0 {
+					skipped++
+				}
+			}
+		}
+		require.True(t, skipped > 0, consensus)
+	}
+}
@@ -1,6 +1,9 @@
 package signature
 
-import "github.com/hdac-io/simulator/types"
+import (
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/types"
+)
 
 // Kind is signature enum type
 type Kind int
@@ -11,28 +14,100 @@ const (
 	Prepared Kind = 1
 	Commit   Kind = 2
 	Commited Kind = 3
+	// ViewChange is vote to skip the proposer of a round
+	ViewChange Kind = 4
 )
 
 // NumKind is number of signatures kind
-const NumKind = 4
+const NumKind = 5
 
-// Payload type for Various Kinds
-type Payload interface{}
+var kindNames = [NumKind]string{"prepare", "prepared", "commit", "commited", "view-change"}
 
-// Signature represents validation signature
+// String returns name of the kind
+func (k Kind) String() string {
+	if k < 0 || k >= NumKind {
+		return "unknown"
+	}
+	return kindNames[k]
+}
+
+// Signature represents validation signature, payload is serialized BLS
+// signature or message of the kind. Round is round of the voted block or
+// round voted to move to by view change. Hash is hash signed by the vote so
+// that vote on other block of the round is told from invalid vote.
 type Signature struct {
 	ID          types.ID
 	Kind        Kind
 	BlockHeight int
-	Payload     Payload
+	Round       int
+	Hash        []byte
+	Payload     []byte
 }
 
 // New returns signature type
-func New(id types.ID, kind Kind, height int, payload Payload) Signature {
+func New(id types.ID, kind Kind, height int, round int, payload []byte) Signature {
 	return Signature{
 		ID:          id,
 		Kind:        kind,
 		BlockHeight: height,
+		Round:       round,
 		Payload:     payload,
 	}
 }
+
+// NewVote returns signature of the kind signed on hash
+func NewVote(id types.ID, kind Kind, height int, round int, hash []byte, payload []byte) Signature {
+	sign := New(id, kind, height, round, payload)
+	sign.Hash = hash
+	return sign
+}
+
+// Encode returns canonical encoding of the signature
+func (s Signature) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeInt(int64(s.ID)),
+		codec.EncodeInt(int64(s.Kind)),
+		codec.EncodeInt(int64(s.BlockHeight)),
+		codec.EncodeInt(int64(s.Round)),
+		codec.EncodeBytes(s.Hash),
+		codec.EncodeBytes(s.Payload),
+	)
+}
+
+// Decode decodes signature from canonical encoding
+func Decode(item codec.Item) (Signature, error) {
+	d := codec.NewDecoder(item, 6)
+	s := Signature{
+		ID:          types.ID(d.Int()),
+		Kind:        Kind(d.Int()),
+		BlockHeight: int(d.Int()),
+		Round:       int(d.Int()),
+		Hash:        d.Bytes(),
+		Payload:     d.Bytes(),
+	}
+	return s, d.Err()
+}
+
+// EncodeList returns canonical encoding of the signatures
+func EncodeList(signs []Signature) []byte {
+	items := make([][]byte, len(signs))
+	for i, s := range signs {
+		items[i] = s.Encode()
+	}
+	return codec.EncodeList(items...)
+}
+
+// DecodeList decodes signatures from canonical encoding
+func DecodeList(item codec.Item) ([]Signature, error) {
+	items, err := item.List()
+	if err != nil {
+		return nil, err
+	}
+	signs := make([]Signature, len(items))
+	for i, item := range items {
+		if signs[i], err = Decode(item); err != nil {
+			return nil, err
+		}
+	}
+	return signs, nil
+}
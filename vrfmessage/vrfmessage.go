@@ -1,11 +1,17 @@
 package vrfmessage
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"io"
+	"math/big"
 
 	"github.com/google/keytransparency/core/crypto/vrf"
 	"github.com/google/keytransparency/core/crypto/vrf/p256"
+	"github.com/hdac-io/simulator/codec"
 	"github.com/hdac-io/simulator/types"
 )
 
@@ -18,6 +24,52 @@ type VRFMessage struct {
 	PreviousBlockHeight    int
 }
 
+// GenerateKey generates VRF key pair from random, same random stream gives same key pair
+func GenerateKey(random io.Reader) (vrf.PrivateKey, vrf.PublicKey) {
+	curve := elliptic.P256()
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(random, buf); err != nil {
+		panic(err)
+	}
+	// D in [1, N-1]
+	d := new(big.Int).SetBytes(buf)
+	d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	return newKey(d)
+}
+
+func newKey(d *big.Int) (vrf.PrivateKey, vrf.PublicKey) {
+	key := &ecdsa.PrivateKey{D: d}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d.Bytes())
+
+	return &p256.PrivateKey{PrivateKey: key}, &p256.PublicKey{PublicKey: &key.PublicKey}
+}
+
+// EncodePrivateKey returns secret scalar of the key in 32 bytes
+func EncodePrivateKey(privKey vrf.PrivateKey) []byte {
+	buf := make([]byte, 32)
+	d := privKey.(*p256.PrivateKey).D.Bytes()
+	copy(buf[32-len(d):], d)
+	return buf
+}
+
+// DecodePrivateKey returns key pair of secret scalar encoded by EncodePrivateKey
+func DecodePrivateKey(data []byte) (vrf.PrivateKey, vrf.PublicKey, error) {
+	d := new(big.Int).SetBytes(data)
+	if len(data) != 32 || d.Sign() == 0 || d.Cmp(elliptic.P256().Params().N) >= 0 {
+		return nil, nil, errors.New("Invalid VRF private key")
+	}
+	privKey, pubKey := newKey(d)
+	return privKey, pubKey, nil
+}
+
+// EncodePublicKey returns public key as carried by VRF message
+func EncodePublicKey(pubKey vrf.PublicKey) []byte {
+	return serialize(pubKey)
+}
+
 // VRF serialize
 func serialize(pkey vrf.PublicKey) []byte {
 	pk := pkey.(*p256.PublicKey)
@@ -68,3 +120,41 @@ func (message *VRFMessage) CalculateBPID(numValidators int) types.ID {
 
 	return types.ID(chosenNumber)
 }
+
+// CalculateFallbackBPID is return to extract validator ID of the round,
+// proposers of later rounds are derived from rand hashed with the round
+func (message *VRFMessage) CalculateFallbackBPID(numValidators int, round int) types.ID {
+	if round == 0 {
+		return message.CalculateBPID(numValidators)
+	}
+	buf := make([]byte, len(message.Rand)+8)
+	copy(buf, message.Rand[:])
+	binary.BigEndian.PutUint64(buf[len(message.Rand):], uint64(round))
+	fallback := VRFMessage{Rand: sha256.Sum256(buf)}
+
+	return fallback.CalculateBPID(numValidators)
+}
+
+// Encode returns canonical encoding of the message
+func (message VRFMessage) Encode() []byte {
+	return codec.EncodeList(
+		codec.EncodeBytes(message.Rand[:]),
+		codec.EncodeBytes(message.Proof),
+		codec.EncodeInt(int64(message.PreviousProposerID)),
+		codec.EncodeBytes(message.PreviousProposerPubkey),
+		codec.EncodeInt(int64(message.PreviousBlockHeight)),
+	)
+}
+
+// Decode decodes message from canonical encoding
+func Decode(item codec.Item) (VRFMessage, error) {
+	d := codec.NewDecoder(item, 5)
+	message := VRFMessage{
+		Rand:                   d.Hash(),
+		Proof:                  d.Bytes(),
+		PreviousProposerID:     types.ID(d.Int()),
+		PreviousProposerPubkey: d.Bytes(),
+		PreviousBlockHeight:    int(d.Int()),
+	}
+	return message, d.Err()
+}
@@ -0,0 +1,218 @@
+// Package wire implements the protocol of loads exchanged between nodes.
+// A load is sent as a frame:
+//
+//	| version (1) | type (1) | length (4, big endian) | payload (length) |
+//
+// The payload is canonical encoding of the load. Types of loads are kept in a
+// registry so that new kinds of messages are added without changing transports.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/blocksync"
+	"github.com/hdac-io/simulator/codec"
+	"github.com/hdac-io/simulator/net"
+	"github.com/hdac-io/simulator/signature"
+)
+
+// Version is version of the protocol
+const Version = 1
+
+// headerSize is size of the frame header
+const headerSize = 6
+
+// MaxSize limits payload size of a frame
+const MaxSize = 16 << 20
+
+// Type represents type of load
+type Type uint8
+
+// Types of load
+const (
+	TypeBlock Type = iota + 1
+	TypeSignature
+	TypeTransaction
+	TypeSyncRequest
+	TypeSyncResponse
+	TypeHandshake
+	TypePing
+	TypeGossip
+)
+
+// Errors of frames
+var (
+	ErrVersion     = errors.New("Unsupported protocol version")
+	ErrUnknownType = errors.New("Unknown message type")
+	ErrTooLarge    = errors.New("Too large frame")
+)
+
+// Ping keeps idle connection alive, transports consume it
+type Ping struct{}
+
+// Encode returns canonical encoding of ping
+func (Ping) Encode() []byte {
+	return codec.EncodeList()
+}
+
+// Encoder is load having canonical encoding
+type Encoder interface {
+	Encode() []byte
+}
+
+// Decoder decodes load from canonical encoding
+type Decoder func(item codec.Item) (net.Load, error)
+
+type message struct {
+	name   string
+	decode Decoder
+}
+
+var (
+	types    = make(map[reflect.Type]Type)
+	messages = make(map[Type]message)
+)
+
+func init() {
+	Register(TypeBlock, "block", block.Block{}, func(item codec.Item) (net.Load, error) {
+		return block.Decode(item)
+	})
+	Register(TypeSignature, "signature", signature.Signature{}, func(item codec.Item) (net.Load, error) {
+		return signature.Decode(item)
+	})
+	Register(TypeTransaction, "transaction", block.Transaction{}, func(item codec.Item) (net.Load, error) {
+		return block.DecodeTransaction(item)
+	})
+	Register(TypeSyncRequest, "sync-request", blocksync.Request{}, func(item codec.Item) (net.Load, error) {
+		return blocksync.DecodeRequest(item)
+	})
+	Register(TypeSyncResponse, "sync-response", blocksync.Response{}, func(item codec.Item) (net.Load, error) {
+		return blocksync.DecodeResponse(item)
+	})
+	Register(TypePing, "ping", Ping{}, func(item codec.Item) (net.Load, error) {
+		_, err := item.Fields(0)
+		return Ping{}, err
+	})
+}
+
+// Register adds type of load like the sample, it should be called on initialization
+func Register(t Type, name string, sample Encoder, decode Decoder) {
+	if _, exist := messages[t]; exist {
+		panic("Duplicate message type " + name + " !")
+	}
+	types[reflect.TypeOf(sample)] = t
+	messages[t] = message{name: name, decode: decode}
+}
+
+// TypeOf returns registered type of the load
+func TypeOf(load net.Load) (Type, bool) {
+	t, exist := types[reflect.TypeOf(load)]
+	return t, exist
+}
+
+// Names returns names of registered types in order
+func Names() []string {
+	names := make([]string, 0, len(messages))
+	for _, m := range messages {
+		names = append(names, m.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t Type) String() string {
+	if m, exist := messages[t]; exist {
+		return m.name
+	}
+	return "unknown"
+}
+
+// Encode returns frame of the load
+func Encode(load net.Load) ([]byte, error) {
+	t, exist := TypeOf(load)
+	if !exist {
+		return nil, ErrUnknownType
+	}
+	payload := load.(Encoder).Encode()
+	if len(payload) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	frame := make([]byte, headerSize, headerSize+len(payload))
+	frame[0] = Version
+	frame[1] = byte(t)
+	binary.BigEndian.PutUint32(frame[2:], uint32(len(payload)))
+	return append(frame, payload...), nil
+}
+
+// Decode decodes load from the frame
+func Decode(frame []byte) (net.Load, error) {
+	if len(frame) < headerSize {
+		return nil, codec.ErrUnexpectedEnd
+	}
+	t, size, err := readHeader(frame)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != headerSize+size {
+		return nil, errors.New("Invalid frame length")
+	}
+	return decodePayload(t, frame[headerSize:])
+}
+
+// ReadFrame reads the next frame from r and decodes its load, frame of
+// unsupported version, unknown type or too large size is rejected before
+// reading its payload
+func ReadFrame(r io.Reader) (net.Load, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	t, size, err := readHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return decodePayload(t, payload)
+}
+
+// WriteFrame writes frame of the load to w
+func WriteFrame(w io.Writer, load net.Load) error {
+	frame, err := Encode(load)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+func readHeader(header []byte) (Type, int, error) {
+	if header[0] != Version {
+		return 0, 0, ErrVersion
+	}
+	t := Type(header[1])
+	if _, exist := messages[t]; !exist {
+		return 0, 0, ErrUnknownType
+	}
+	size := binary.BigEndian.Uint32(header[2:])
+	if size > MaxSize {
+		return 0, 0, ErrTooLarge
+	}
+	return t, int(size), nil
+}
+
+func decodePayload(t Type, payload []byte) (net.Load, error) {
+	item, err := codec.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	return messages[t].decode(item)
+}
@@ -0,0 +1,73 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/hdac-io/simulator/block"
+	"github.com/hdac-io/simulator/blocksync"
+	"github.com/hdac-io/simulator/signature"
+	"github.com/hdac-io/simulator/vrfmessage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	b := block.New(block.BlockHeader{Height: 1, Timestamp: 1, Producer: 2}, vrfmessage.VRFMessage{}, []block.Transaction{{From: 2, Nonce: 1, Payload: []byte{1}}})
+	sign := signature.NewVote(2, signature.Commit, 1, 0, []byte{4, 5}, []byte{1, 2, 3})
+	loads := []interface{}{
+		b,
+		sign,
+		b.Transactions[0],
+		blocksync.Request{From: 1, To: 10},
+		blocksync.Response{Blocks: []block.Block{b}, Signatures: [][]signature.Signature{{sign}}, Height: 1},
+	}
+	stream := bytes.NewBuffer(nil)
+	for _, load := range loads {
+		frame, err := Encode(load)
+		require.NoError(t, err)
+		decoded, err := Decode(frame)
+		require.NoError(t, err)
+		require.Equal(t, load, decoded)
+		require.NoError(t, WriteFrame(stream, load))
+	}
+	for _, load := range loads {
+		decoded, err := ReadFrame(stream)
+		require.NoError(t, err)
+		require.Equal(t, load, decoded)
+	}
+
+	// Golden frame of a sync request
+	frame, err := Encode(blocksync.Request{From: 1, To: 10})
+	require.NoError(t, err)
+	require.Equal(t, []byte{Version, byte(TypeSyncRequest), 0, 0, 0, 3, 0xc2, 1, 10}, frame)
+
+	_, err = Encode(1)
+	require.Equal(t, ErrUnknownType, err)
+}
+
+func TestMalformedFrame(t *testing.T) {
+	valid, err := Encode(signature.New(2, signature.Commit, 1, 0, []byte{1, 2, 3}))
+	require.NoError(t, err)
+	frame := func(change func(frame []byte) []byte) []byte {
+		return change(append([]byte{}, valid...))
+	}
+
+	_, err = Decode(frame(func(f []byte) []byte { f[0] = Version + 1; return f }))
+	require.Equal(t, ErrVersion, err)
+	_, err = Decode(frame(func(f []byte) []byte { f[1] = 99; return f }))
+	require.Equal(t, ErrUnknownType, err)
+	_, err = ReadFrame(bytes.NewReader(frame(func(f []byte) []byte {
+		binary.BigEndian.PutUint32(f[2:], MaxSize+1)
+		return f
+	})))
+	require.Equal(t, ErrTooLarge, err)
+	_, err = Decode(frame(func(f []byte) []byte { return f[:len(f)-1] }))
+	require.Error(t, err)
+	_, err = ReadFrame(bytes.NewReader(frame(func(f []byte) []byte { return f[:len(f)-1] })))
+	require.Error(t, err)
+
+	// Payload of another type is rejected
+	_, err = Decode(frame(func(f []byte) []byte { f[1] = byte(TypeBlock); return f }))
+	require.Error(t, err)
+}